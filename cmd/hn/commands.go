@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/migrate"
+)
+
+var COMMAND_USAGE string = fmt.Sprintf(`
+USAGE: %s [command]
+
+With no command, starts the web server.
+
+COMMANDS:
+	migrate up		apply every pending schema migration
+	migrate down [n]	roll back the last n migrations (default 1)
+	migrate status		list migrations and whether they have been applied
+	repair			recompute vote and comment counters from the raw tables
+`, os.Args[0])
+
+// subcommands that run instead of the web server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "repair":
+		return runRepair()
+	default:
+		fmt.Println(COMMAND_USAGE)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(COMMAND_USAGE)
+		return fmt.Errorf("migrate needs one of: up, down, status")
+	}
+
+	if err := db.InitDB(); err != nil {
+		return err
+	}
+
+	migrator, err := migrate.New(db.GetDB())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		ran, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s), schema is at version %d\n", len(ran), migrator.Latest())
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		ran, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		for _, m := range ran {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("%04d_%-30s applied %s\n", s.Migration.Version, s.Migration.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%04d_%-30s pending\n", s.Migration.Version, s.Migration.Name)
+			}
+		}
+
+	default:
+		fmt.Println(COMMAND_USAGE)
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
+
+func runRepair() error {
+	if err := db.InitDB(); err != nil {
+		return err
+	}
+
+	repair, err := db.NewPostgresStore(db.GetDB()).RepairCounters(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("fixed counters on %d submission(s) and %d comment(s)\n", repair.Submissions, repair.Comments)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// longest a single cleanup may take before it's abandoned until the next tick
+const jobTimeout = time.Minute
+
+// deletes magic links that expired without being used, and passkey and two-factor challenges nobody finished,
+// straight away and then every interval, forever
+// run it in its own goroutine
+func purgeExpired(store db.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	purges := []struct {
+		what  string
+		purge func(ctx context.Context) (int, error)
+	}{
+		{"magic link", store.PurgeExpiredMagicLinks},
+		{"passkey challenge", store.PurgeExpiredPasskeyChallenges},
+		{"two-factor challenge", store.PurgeExpiredTwoFactorChallenges},
+	}
+
+	for {
+		for _, p := range purges {
+			ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+			purged, err := p.purge(ctx)
+			cancel()
+
+			if err != nil {
+				log.Printf("[WARN] Unable to purge expired %ss: %s\n", p.what, err)
+			} else if purged > 0 {
+				log.Printf("[INFO] Purged %d expired %s(s)\n", purged, p.what)
+			}
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/captcha"
+	"github.com/trentwiles/hackernews/internal/config"
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/email"
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+// front page ranking knobs, anything unset keeps the default from db.DefaultRanking()
+func rankingFromEnv() (db.RankingOptions, error) {
+	opts := db.DefaultRanking()
+
+	floats := []struct {
+		key string
+		dst *float64
+	}{
+		{"RANK_GRAVITY", &opts.Gravity},
+		{"RANK_FLAGGED_PENALTY", &opts.FlaggedPenalty},
+		{"RANK_LOW_COMMENT_PENALTY", &opts.LowCommentPenalty},
+	}
+
+	for _, f := range floats {
+		val, err := strconv.ParseFloat(config.GetEnvOrDefault(f.key, strconv.FormatFloat(*f.dst, 'f', -1, 64)), 64)
+		if err != nil || val < 0 {
+			return opts, fmt.Errorf("%s must be a non-negative number", f.key)
+		}
+		*f.dst = val
+	}
+
+	threshold, err := strconv.Atoi(config.GetEnvOrDefault("RANK_LOW_COMMENT_THRESHOLD", strconv.Itoa(opts.LowCommentThreshold)))
+	if err != nil || threshold < 0 {
+		return opts, fmt.Errorf("RANK_LOW_COMMENT_THRESHOLD must be a non-negative integer")
+	}
+	opts.LowCommentThreshold = threshold
+
+	return opts, nil
+}
+
+// page sizes for the list endpoints
+func pagingFromEnv() (handlers.Paging, error) {
+	defaultLimit, err := strconv.Atoi(config.GetEnvOrDefault("PAGE_DEFAULT_LIMIT", "10"))
+	if err != nil || defaultLimit < 1 {
+		return handlers.Paging{}, fmt.Errorf("PAGE_DEFAULT_LIMIT must be a positive integer")
+	}
+
+	maxLimit, err := strconv.Atoi(config.GetEnvOrDefault("PAGE_MAX_LIMIT", "100"))
+	if err != nil || maxLimit < defaultLimit {
+		return handlers.Paging{}, fmt.Errorf("PAGE_MAX_LIMIT must be an integer no smaller than PAGE_DEFAULT_LIMIT")
+	}
+
+	return handlers.Paging{DefaultLimit: defaultLimit, MaxLimit: maxLimit}, nil
+}
+
+// how hard bots can hit the API with their keys
+func apiKeyLimitFromEnv() (handlers.RateLimit, error) {
+	return rateLimitFromEnv("API_KEY_RATE_LIMIT", "60", "API_KEY_RATE_WINDOW", "1m")
+}
+
+// how many magic links can be asked for from one IP, and sent to one address, per window
+func loginLimitsFromEnv() (handlers.RateLimit, handlers.RateLimit, error) {
+	perIP, err := rateLimitFromEnv("LOGIN_RATE_LIMIT_IP", "10", "LOGIN_RATE_WINDOW", "15m")
+	if err != nil {
+		return handlers.RateLimit{}, handlers.RateLimit{}, err
+	}
+
+	perEmail, err := rateLimitFromEnv("LOGIN_RATE_LIMIT_EMAIL", "3", "LOGIN_RATE_WINDOW", "15m")
+	if err != nil {
+		return handlers.RateLimit{}, handlers.RateLimit{}, err
+	}
+	return perIP, perEmail, nil
+}
+
+// a request count and a Go duration, a count of 0 turns the limit off
+func rateLimitFromEnv(limitKey string, defaultLimit string, windowKey string, defaultWindow string) (handlers.RateLimit, error) {
+	limit, err := strconv.Atoi(config.GetEnvOrDefault(limitKey, defaultLimit))
+	if err != nil || limit < 0 {
+		return handlers.RateLimit{}, fmt.Errorf("%s must be a non-negative integer", limitKey)
+	}
+
+	window, err := durationFromEnv(windowKey, defaultWindow)
+	if err != nil {
+		return handlers.RateLimit{}, err
+	}
+
+	return handlers.RateLimit{Max: limit, Window: window}, nil
+}
+
+// TRUSTED_PROXIES is a comma separated list of the reverse proxies in front of the server (addresses or CIDR ranges),
+// whose PROXY_HEADER (X-Forwarded-For by default) says who the client is
+// without any, every client looks like whatever it connected from, which behind a proxy is the proxy
+func proxiesFromEnv() ([]string, string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(config.GetEnvOrDefault("TRUSTED_PROXIES", ""), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, "", fmt.Errorf("TRUSTED_PROXIES must be IP addresses or CIDR ranges, got %q", proxy)
+			}
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, config.GetEnvOrDefault("PROXY_HEADER", fiber.HeaderXForwardedFor), nil
+}
+
+// how long a refresh token lasts, a Go duration
+func sessionExpiryFromEnv() (time.Duration, error) {
+	return durationFromEnv("SESSION_EXPIRES_IN", "720h")
+}
+
+// how long a magic link works for after it's emailed
+func magicLinkTTLFromEnv() (time.Duration, error) {
+	return durationFromEnv("MAGIC_LINK_TTL", "15m")
+}
+
+// passkeys are tied to the site's domain, WEBAUTHN_RP_ID, so they stay off until it's set (nil, nil)
+// WEBAUTHN_RP_ORIGINS is a comma separated list of where the website is served from, https://<rp id> by default
+func webAuthnFromEnv() (*webauthn.WebAuthn, error) {
+	rpId := config.GetEnvOrDefault("WEBAUTHN_RP_ID", "")
+	if rpId == "" {
+		return nil, nil
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(config.GetEnvOrDefault("WEBAUTHN_RP_ORIGINS", "https://"+rpId), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	// the browser's own timeout isn't trusted, a prompt left open too long is refused here as well
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: 2 * time.Minute, TimeoutUVD: 2 * time.Minute}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpId,
+		RPDisplayName: config.GetEnvOrDefault("WEBAUTHN_RP_NAME", "Hacker News Clone"),
+		RPOrigins:     origins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("WEBAUTHN_RP_ID/WEBAUTHN_RP_ORIGINS: %w", err)
+	}
+	return w, nil
+}
+
+// CAPTCHA_PROVIDER picks who checks captchaToken: recaptcha (the default), turnstile or hcaptcha with CAPTCHA_SECRET_KEY,
+// pow to have clients solve our own proof-of-work challenges instead, or pass/fail to skip asking anyone in development. reCAPTCHA still reads GOOGLE_SECRET_KEY and GOOGLE_CUTOFF if the
+// CAPTCHA_ ones aren't set. CAPTCHA_TIMEOUT bounds each check and CAPTCHA_FAIL_OPEN says what happens when the provider is down
+func captchaFromEnv() (captcha.Verifier, error) {
+	provider := strings.ToLower(config.GetEnvOrDefault("CAPTCHA_PROVIDER", "recaptcha"))
+	secret := config.GetEnvOrDefault("CAPTCHA_SECRET_KEY", "")
+
+	var verifier captcha.Verifier
+	switch provider {
+	case "recaptcha":
+		if secret == "" {
+			secret = config.GetEnvOrDefault("GOOGLE_SECRET_KEY", "")
+		}
+		minScore, err := strconv.ParseFloat(config.GetEnvOrDefault("CAPTCHA_MIN_SCORE", config.GetEnvOrDefault("GOOGLE_CUTOFF", "0.5")), 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			return nil, fmt.Errorf("CAPTCHA_MIN_SCORE must be a number between 0 and 1")
+		}
+		verifier = captcha.NewRecaptcha(secret, minScore)
+	case "turnstile":
+		verifier = captcha.NewTurnstile(secret)
+	case "hcaptcha":
+		verifier = captcha.NewHCaptcha(secret)
+	case "pow":
+		return proofOfWorkFromEnv(secret)
+	case "pass":
+		return captcha.Static(true), nil
+	case "fail":
+		return captcha.Static(false), nil
+	default:
+		return nil, fmt.Errorf("CAPTCHA_PROVIDER must be recaptcha, turnstile, hcaptcha, pow, pass or fail, got %q", provider)
+	}
+
+	if secret == "" {
+		return nil, fmt.Errorf("CAPTCHA_SECRET_KEY is required for %s, or set CAPTCHA_PROVIDER=pass for development", provider)
+	}
+
+	timeout, err := durationFromEnv("CAPTCHA_TIMEOUT", "5s")
+	if err != nil {
+		return nil, err
+	}
+
+	failOpen, err := strconv.ParseBool(config.GetEnvOrDefault("CAPTCHA_FAIL_OPEN", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("CAPTCHA_FAIL_OPEN must be true or false")
+	}
+
+	return captcha.Policy{Verifier: verifier, Timeout: timeout, FailOpen: failOpen}, nil
+}
+
+// CAPTCHA_POW_DIFFICULTY is the zero bits every challenge asks for, each one doubles the work (18 takes a browser about a second)
+// secret signs the challenges, without one a random key is used and challenges don't survive a restart
+func proofOfWorkFromEnv(secret string) (*captcha.ProofOfWork, error) {
+	difficulty, err := strconv.Atoi(config.GetEnvOrDefault("CAPTCHA_POW_DIFFICULTY", "18"))
+	if err != nil || difficulty < 1 || difficulty > 32 {
+		return nil, fmt.Errorf("CAPTCHA_POW_DIFFICULTY must be a whole number between 1 and 32")
+	}
+
+	return captcha.NewProofOfWork([]byte(secret), difficulty)
+}
+
+// who can skip the captcha on /submit and /comment, CAPTCHA_TRUST_SUBMIT and CAPTCHA_TRUST_COMMENT are each "off" or a
+// comma separated list of age (minimum days since signing up), karma (minimum), reports (most report weight against them)
+// and flagged (most flagged posts), like "age=30,karma=50,reports=0.5,flagged=0"; whatever's left out isn't allowed any slack
+func captchaTrustFromEnv() (map[string]handlers.TrustPolicy, error) {
+	trust := map[string]handlers.TrustPolicy{}
+
+	for _, setting := range []struct {
+		action   string
+		key      string
+		fallback string
+	}{
+		{handlers.CaptchaSubmit, "CAPTCHA_TRUST_SUBMIT", "age=90,karma=100,reports=0.5,flagged=0"},
+		{handlers.CaptchaComment, "CAPTCHA_TRUST_COMMENT", "age=30,karma=20,reports=0.5,flagged=0"},
+	} {
+		value := strings.TrimSpace(config.GetEnvOrDefault(setting.key, setting.fallback))
+		if value == "off" {
+			continue
+		}
+
+		var policy handlers.TrustPolicy
+		for _, pair := range strings.Split(value, ",") {
+			name, number, _ := strings.Cut(strings.TrimSpace(pair), "=")
+
+			var err error
+			switch name {
+			case "age":
+				policy.MinAgeDays, err = strconv.Atoi(number)
+			case "karma":
+				policy.MinKarma, err = strconv.Atoi(number)
+			case "reports":
+				policy.MaxReportWeight, err = strconv.ParseFloat(number, 64)
+			case "flagged":
+				policy.MaxFlagged, err = strconv.Atoi(number)
+			default:
+				err = fmt.Errorf("unknown setting %q", name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s must be off or like %q: %w", setting.key, setting.fallback, err)
+			}
+		}
+		trust[setting.action] = policy
+	}
+
+	return trust, nil
+}
+
+// MAIL_BACKEND is smtp (the default) or maildir, which writes every email into MAILDIR (./mail) rather than sending it
+// SMTP goes to EMAIL_HOST on EMAIL_PORT with EMAIL_TLS (starttls, tls or none), signing in as EMAIL_USERNAME unless
+// it's empty, and sends from EMAIL_FROM (EMAIL_USERNAME by default)
+func mailerFromEnv() (email.Mailer, error) {
+	switch backend := config.GetEnvOrDefault("MAIL_BACKEND", "smtp"); backend {
+	case "maildir":
+		return &email.Maildir{Dir: config.GetEnvOrDefault("MAILDIR", "./mail")}, nil
+	case "smtp":
+	default:
+		return nil, fmt.Errorf("MAIL_BACKEND must be smtp or maildir, got %q", backend)
+	}
+
+	mailer := &email.SMTP{
+		Host:     config.GetEnvOrDefault("EMAIL_HOST", ""),
+		TLS:      email.TLSMode(strings.ToLower(config.GetEnvOrDefault("EMAIL_TLS", string(email.StartTLS)))),
+		Username: config.GetEnvOrDefault("EMAIL_USERNAME", ""),
+		Password: config.GetEnvOrDefault("EMAIL_PASSWORD", ""),
+	}
+	mailer.From = config.GetEnvOrDefault("EMAIL_FROM", mailer.Username)
+
+	if mailer.Host == "" {
+		return nil, fmt.Errorf("EMAIL_HOST is required, or set MAIL_BACKEND=maildir for development")
+	}
+	if mailer.From == "" {
+		return nil, fmt.Errorf("EMAIL_FROM is required when there's no EMAIL_USERNAME")
+	}
+	switch mailer.TLS {
+	case email.StartTLS, email.ImplicitTLS, email.NoTLS:
+	default:
+		return nil, fmt.Errorf("EMAIL_TLS must be starttls, tls or none, got %q", mailer.TLS)
+	}
+
+	port, err := strconv.Atoi(config.GetEnvOrDefault("EMAIL_PORT", "587"))
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("EMAIL_PORT must be a port number")
+	}
+	mailer.Port = port
+
+	return mailer, nil
+}
+
+// what emails call the site and link back to, shared with the frontend's .env
+func siteFromEnv() email.Site {
+	return email.Site{
+		Name: config.GetEnvOrDefault("VITE_SERVICE_NAME", "HackerNews"),
+		URL:  strings.TrimSuffix(config.GetEnvOrDefault("VITE_FRONTEND_URL", "http://localhost:5173"), "/"),
+	}
+}
+
+func durationFromEnv(key string, fallback string) (time.Duration, error) {
+	d, err := time.ParseDuration(config.GetEnvOrDefault(key, fallback))
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, ie. %q", key, fallback)
+	}
+	return d, nil
+}
@@ -1,78 +1,161 @@
 package captcha
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
-	"log"
-	"strconv"
 	"strings"
-
-	"github.com/trentwiles/hackernews/internal/config"
+	"time"
 )
 
-type RecaptchaResponse struct {
-	Success     bool     `json:"success"`
-	ChallengeTS string   `json:"challenge_ts"`
-	Hostname    string   `json:"hostname"`
-	Score       float64  `json:"score"`
-	Action      string   `json:"action"`
-	ErrorCodes  []string `json:"error-codes"`
+// checks the token a captcha widget gave the client
+// false is a failed captcha, an error means the provider couldn't say either way (down, slow, garbled answer)
+type Verifier interface {
+	Verify(ctx context.Context, token string) (bool, error)
 }
 
-func ValidateToken(token string) bool {
-	// for user privacy I don't pass the request IP address back to Google
-	resp, err := sendCaptchaToken(token)
+// passes or fails everything without asking anyone, for development and tests (CAPTCHA_PROVIDER=pass or fail)
+type Static bool
 
-	if err != nil {
-		log.Fatal(err)
+func (s Static) Verify(ctx context.Context, token string) (bool, error) {
+	return bool(s), nil
+}
+
+// wraps a provider with a deadline on every check and a decision for when it can't answer
+// FailOpen lets everyone through while the provider is down, otherwise nobody gets through
+type Policy struct {
+	Verifier Verifier
+	Timeout  time.Duration
+	FailOpen bool
+}
+
+// never returns an error, an unreachable provider is FailOpen's call
+func (p Policy) Verify(ctx context.Context, token string) (bool, error) {
+	if token == "" {
+		return false, nil
 	}
-	defer resp.Body.Close()
 
-	var result RecaptchaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Fatal("[FATAL] JSON decode error:", err)
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
 	}
 
-	if !result.Success {
-		for num, error_message := range result.ErrorCodes {
-			fmt.Printf("[WARN] Error from Google Captcha; Error #%d, Message: %s\n", num, error_message)
+	ok, err := p.Verifier.Verify(ctx, token)
+	if err != nil {
+		if p.FailOpen {
+			log.Printf("[WARN] Captcha provider unavailable, letting the request through: %s\n", err)
+		} else {
+			log.Printf("[WARN] Captcha provider unavailable, refusing the request: %s\n", err)
 		}
+		return p.FailOpen, nil
 	}
+	return ok, nil
+}
 
-	fmt.Printf("[INFO] Google Captcha Response was a success. Score for action %s was a %.1f/1.0\n", result.Action, result.Score)
-	
-	// Is the score provided by google higher or lower than the cutoff?
-	cutoff, err := strconv.ParseFloat(config.GetEnv("GOOGLE_CUTOFF"), 64)
-	if err != nil {
-		log.Fatal("[FATAL] Error parsing float:", err)
+// Google reCAPTCHA v3, tokens pass with a score of at least MinScore (out of 1.0)
+type Recaptcha struct {
+	siteVerify
+	MinScore float64
+}
+
+func NewRecaptcha(secret string, minScore float64) *Recaptcha {
+	return &Recaptcha{siteVerify: siteVerify{Name: "reCAPTCHA", Endpoint: "https://www.google.com/recaptcha/api/siteverify", Secret: secret}, MinScore: minScore}
+}
+
+func (r *Recaptcha) Verify(ctx context.Context, token string) (bool, error) {
+	result, err := r.check(ctx, token)
+	if err != nil || !result.Success {
+		return false, err
 	}
 
-	return result.Score >= cutoff
+	log.Printf("[INFO] reCAPTCHA score for action %s was %.1f/1.0\n", result.Action, result.Score)
+	return result.Score >= r.MinScore, nil
+}
+
+// Cloudflare Turnstile, pass or fail
+type Turnstile struct {
+	siteVerify
+}
+
+func NewTurnstile(secret string) *Turnstile {
+	return &Turnstile{siteVerify{Name: "Turnstile", Endpoint: "https://challenges.cloudflare.com/turnstile/v0/siteverify", Secret: secret}}
+}
+
+func (t *Turnstile) Verify(ctx context.Context, token string) (bool, error) {
+	result, err := t.check(ctx, token)
+	return result.Success, err
+}
+
+// hCaptcha, pass or fail (enterprise risk scores aren't used)
+type HCaptcha struct {
+	siteVerify
+}
+
+func NewHCaptcha(secret string) *HCaptcha {
+	return &HCaptcha{siteVerify{Name: "hCaptcha", Endpoint: "https://api.hcaptcha.com/siteverify", Secret: secret}}
+}
+
+func (h *HCaptcha) Verify(ctx context.Context, token string) (bool, error) {
+	result, err := h.check(ctx, token)
+	return result.Success, err
 }
 
+// the three providers all take the same form POST and answer with (roughly) the same JSON
+type siteVerify struct {
+	Name     string
+	Endpoint string // swapped out in tests
+	Secret   string
+	Client   *http.Client // http.DefaultClient when nil, the deadline comes from the context
+}
 
-func sendCaptchaToken(token string) (*http.Response, error) {
-	config.LoadEnv()
+type siteVerifyResponse struct {
+	Success     bool     `json:"success"`
+	ChallengeTS string   `json:"challenge_ts"`
+	Hostname    string   `json:"hostname"`
+	Score       float64  `json:"score"`
+	Action      string   `json:"action"`
+	ErrorCodes  []string `json:"error-codes"`
+}
 
-	endpoint := "https://www.google.com/recaptcha/api/siteverify"
+func (s siteVerify) check(ctx context.Context, token string) (siteVerifyResponse, error) {
+	// for user privacy the request IP address isn't passed along, it's optional for all three
 	data := url.Values{
-		"secret":   {config.GetEnv("GOOGLE_SECRET_KEY")},
+		"secret":   {s.Secret},
 		"response": {token},
 	}
 
-	req, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, strings.NewReader(data.Encode()))
 	if err != nil {
-		return nil, err
+		return siteVerifyResponse{}, err
 	}
-
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-
-	client := &http.Client{}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
 	resp, err := client.Do(req)
+	if err != nil {
+		return siteVerifyResponse{}, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return siteVerifyResponse{}, fmt.Errorf("%s: siteverify responded %s", s.Name, resp.Status)
+	}
+
+	var result siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return siteVerifyResponse{}, fmt.Errorf("%s: decoding siteverify response: %w", s.Name, err)
+	}
 
-	log.Printf("[INFO] Sent token of length %d to Google Captcha API\n", len([]rune(token)))
-	return resp, err
-}
\ No newline at end of file
+	// a bad token is a failed captcha rather than an outage, even a bad secret is: failing open on it would let everyone through
+	if !result.Success {
+		log.Printf("[WARN] %s rejected a token of length %d: %s\n", s.Name, len(token), strings.Join(result.ErrorCodes, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package captcha
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a siteverify endpoint that answers with body, after delay
+func testEndpoint(t *testing.T, status int, body string, delay time.Duration) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-secret", r.FormValue("secret"), "secret sent")
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestProviders(t *testing.T) {
+	ctx := context.Background()
+
+	recaptcha := NewRecaptcha("test-secret", 0.5)
+	recaptcha.Endpoint = testEndpoint(t, 200, `{"success": true, "score": 0.9, "action": "login"}`, 0)
+	ok, err := recaptcha.Verify(ctx, "token")
+	assert.Nil(t, err, "reCAPTCHA")
+	assert.True(t, ok, "high score passes")
+
+	recaptcha.Endpoint = testEndpoint(t, 200, `{"success": true, "score": 0.1}`, 0)
+	ok, _ = recaptcha.Verify(ctx, "token")
+	assert.False(t, ok, "low score fails")
+
+	turnstile := NewTurnstile("test-secret")
+	turnstile.Endpoint = testEndpoint(t, 200, `{"success": true}`, 0)
+	ok, err = turnstile.Verify(ctx, "token")
+	assert.Nil(t, err, "Turnstile")
+	assert.True(t, ok, "Turnstile pass")
+
+	hcaptcha := NewHCaptcha("test-secret")
+	hcaptcha.Endpoint = testEndpoint(t, 200, `{"success": false, "error-codes": ["invalid-input-response"]}`, 0)
+	ok, err = hcaptcha.Verify(ctx, "token")
+	assert.Nil(t, err, "a rejected token isn't an outage")
+	assert.False(t, ok, "hCaptcha fail")
+
+	hcaptcha.Endpoint = testEndpoint(t, 500, ``, 0)
+	_, err = hcaptcha.Verify(ctx, "token")
+	assert.NotNil(t, err, "server error")
+	hcaptcha.Endpoint = testEndpoint(t, 200, `not json`, 0)
+	_, err = hcaptcha.Verify(ctx, "token")
+	assert.NotNil(t, err, "garbled answer")
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	slow := NewTurnstile("test-secret")
+	slow.Endpoint = testEndpoint(t, 200, `{"success": true}`, 200*time.Millisecond)
+
+	ok, err := Policy{Verifier: slow, Timeout: 20 * time.Millisecond}.Verify(ctx, "token")
+	assert.Nil(t, err, "policy never errors")
+	assert.False(t, ok, "fail closed on a timeout")
+
+	ok, _ = Policy{Verifier: slow, Timeout: 20 * time.Millisecond, FailOpen: true}.Verify(ctx, "token")
+	assert.True(t, ok, "fail open on a timeout")
+
+	ok, _ = Policy{Verifier: slow, Timeout: time.Second}.Verify(ctx, "token")
+	assert.True(t, ok, "answered in time")
+
+	ok, _ = Policy{Verifier: Static(true), FailOpen: true}.Verify(ctx, "")
+	assert.False(t, ok, "no token never passes")
+
+	ok, _ = Static(false).Verify(ctx, "token")
+	assert.False(t, ok, "always fail")
+}
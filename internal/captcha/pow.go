@@ -0,0 +1,237 @@
+package captcha
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a Hashcash-style captcha that never leaves the server: GET /captcha/challenge hands out a signed challenge,
+// the client finds a nonce so that sha256("<challenge>:<nonce>") starts with Difficulty zero bits,
+// and "<challenge>:<nonce>" is the captchaToken
+// spent challenges are remembered in memory, like the rate limits, so it's for a single server
+type ProofOfWork struct {
+	Difficulty      int           // zero bits every challenge asks for, each one doubles the work
+	MaxDifficulty   int           // however much gets added on top, it stops here
+	TTL             time.Duration // how long a challenge can be solved and spent for
+	NewAccountAge   time.Duration // accounts younger than this get NewAccountExtra more bits
+	NewAccountExtra int
+	HotIPThreshold  int // an IP that asked for more than this many challenges in the last TTL gets HotIPExtra more bits
+	HotIPExtra      int
+
+	key []byte
+	now func() time.Time // swapped out in tests
+
+	mu     sync.Mutex
+	spent  map[string]time.Time // challenge id -> when it expires, after which it can't be replayed anyway
+	issued map[string]*ipWindow // per IP
+	pruned time.Time
+}
+
+// challenges issued to one IP since start
+type ipWindow struct {
+	start time.Time
+	count int
+}
+
+// what GET /captcha/challenge responds with
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// signed into every challenge, so none of it can be edited to make the work easier
+type powClaims struct {
+	Id         string `json:"id"`
+	Subject    string `json:"sub"` // the username it was issued to, "" when signed out
+	Difficulty int    `json:"bits"`
+	Expires    int64  `json:"exp"`
+}
+
+// key signs the challenges, pass nil for a random one (outstanding challenges stop working on restart)
+func NewProofOfWork(key []byte, difficulty int) (*ProofOfWork, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ProofOfWork{
+		Difficulty:      difficulty,
+		MaxDifficulty:   difficulty + 6,
+		TTL:             5 * time.Minute,
+		NewAccountAge:   24 * time.Hour,
+		NewAccountExtra: 2,
+		HotIPThreshold:  20,
+		HotIPExtra:      4,
+		key:             key,
+		now:             time.Now,
+		spent:           map[string]time.Time{},
+		issued:          map[string]*ipWindow{},
+	}, nil
+}
+
+type subjectKey struct{}
+
+// who's spending a captchaToken, a challenge issued to one account (or to nobody) doesn't pass for another,
+// otherwise a new account could fetch its challenges signed out to skip NewAccountExtra
+func WithSubject(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, username)
+}
+
+func subjectOf(ctx context.Context) string {
+	username, _ := ctx.Value(subjectKey{}).(string)
+	return username
+}
+
+// a fresh challenge for username (or "") asking from ip, joined is when username signed up (zero when signed out)
+func (p *ProofOfWork) Issue(username string, ip string, joined time.Time) (Challenge, error) {
+	now := p.now()
+
+	difficulty := p.Difficulty
+	if username != "" && !joined.IsZero() && now.Sub(joined) < p.NewAccountAge {
+		difficulty += p.NewAccountExtra
+	}
+	if p.countIssue(ip, now) > p.HotIPThreshold {
+		difficulty += p.HotIPExtra
+	}
+	difficulty = min(difficulty, p.MaxDifficulty)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Challenge{}, err
+	}
+	expires := now.Add(p.TTL)
+
+	payload, err := json.Marshal(powClaims{Id: hex.EncodeToString(id), Subject: username, Difficulty: difficulty, Expires: expires.Unix()})
+	if err != nil {
+		return Challenge{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return Challenge{
+		Challenge:  encoded + "." + base64.RawURLEncoding.EncodeToString(p.sign(encoded)),
+		Difficulty: difficulty,
+		ExpiresAt:  expires.UTC(),
+	}, nil
+}
+
+// checks the work, the signature, the expiry and who it was issued to, then spends the challenge
+// never returns an error, there's nobody else to ask
+func (p *ProofOfWork) Verify(ctx context.Context, token string) (bool, error) {
+	challenge, nonce, found := strings.Cut(token, ":")
+	if !found || nonce == "" || len(nonce) > 64 {
+		return false, nil
+	}
+
+	claims, err := p.parse(challenge)
+	if err != nil {
+		return false, nil
+	}
+
+	now := p.now()
+	if now.Unix() >= claims.Expires || claims.Subject != subjectOf(ctx) {
+		return false, nil
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(token))) < claims.Difficulty {
+		return false, nil
+	}
+
+	return p.spend(claims.Id, time.Unix(claims.Expires, 0), now), nil
+}
+
+func (p *ProofOfWork) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (p *ProofOfWork) parse(challenge string) (powClaims, error) {
+	var claims powClaims
+
+	payload, signature, found := strings.Cut(challenge, ".")
+	if !found {
+		return claims, fmt.Errorf("malformed challenge")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, p.sign(payload)) {
+		return claims, fmt.Errorf("bad signature")
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, err
+	}
+	err = json.Unmarshal(decoded, &claims)
+	return claims, err
+}
+
+// false when the challenge was already spent
+func (p *ProofOfWork) spend(id string, expires time.Time, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(now)
+	if _, used := p.spent[id]; used {
+		return false
+	}
+	p.spent[id] = expires
+	return true
+}
+
+// how many challenges ip has asked for in its current window, this one included
+func (p *ProofOfWork) countIssue(ip string, now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(now)
+	window, ok := p.issued[ip]
+	if !ok || now.Sub(window.start) >= p.TTL {
+		window = &ipWindow{start: now}
+		p.issued[ip] = window
+	}
+	window.count++
+	return window.count
+}
+
+// forgets expired spends and finished windows, at most once a TTL
+// callers hold mu
+func (p *ProofOfWork) prune(now time.Time) {
+	if now.Sub(p.pruned) < p.TTL {
+		return
+	}
+	p.pruned = now
+
+	for id, expires := range p.spent {
+		if !now.Before(expires) {
+			delete(p.spent, id)
+		}
+	}
+	for ip, window := range p.issued {
+		if now.Sub(window.start) >= p.TTL {
+			delete(p.issued, ip)
+		}
+	}
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,106 @@
+package captcha
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// does the client's half, fine at the small difficulties used here
+func solve(challenge Challenge) string {
+	for nonce := 0; ; nonce++ {
+		token := challenge.Challenge + ":" + strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(token))) >= challenge.Difficulty {
+			return token
+		}
+	}
+}
+
+func testProofOfWork(t *testing.T) (*ProofOfWork, *time.Time) {
+	pow, err := NewProofOfWork([]byte("test-key"), 8)
+	assert.Nil(t, err, "NewProofOfWork")
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	pow.now = func() time.Time { return now }
+	return pow, &now
+}
+
+func TestProofOfWork(t *testing.T) {
+	ctx := context.Background()
+	pow, now := testProofOfWork(t)
+
+	challenge, err := pow.Issue("", "1.2.3.4", time.Time{})
+	assert.Nil(t, err, "Issue")
+	assert.Equal(t, 8, challenge.Difficulty, "base difficulty")
+
+	token := solve(challenge)
+	ok, err := pow.Verify(ctx, token)
+	assert.Nil(t, err, "Verify")
+	assert.True(t, ok, "solved challenge passes")
+	ok, _ = pow.Verify(ctx, token)
+	assert.False(t, ok, "a challenge can only be spent once")
+
+	challenge, _ = pow.Issue("", "1.2.3.4", time.Time{})
+	unsolved := challenge.Challenge + ":0"
+	for nonce := 1; leadingZeroBits(sha256.Sum256([]byte(unsolved))) >= challenge.Difficulty; nonce++ {
+		unsolved = challenge.Challenge + ":" + strconv.Itoa(nonce)
+	}
+	ok, _ = pow.Verify(ctx, unsolved)
+	assert.False(t, ok, "not enough work")
+	ok, _ = pow.Verify(ctx, challenge.Challenge+":")
+	assert.False(t, ok, "no nonce")
+	ok, _ = pow.Verify(ctx, "garbage")
+	assert.False(t, ok, "garbage")
+
+	// bumping the difficulty down in the payload breaks the signature
+	challenge, _ = pow.Issue("", "1.2.3.4", time.Time{})
+	payload, signature, _ := strings.Cut(challenge.Challenge, ".")
+	ok, _ = pow.Verify(ctx, solve(Challenge{Challenge: payload + "x." + signature}))
+	assert.False(t, ok, "edited challenge")
+
+	other, _ := NewProofOfWork([]byte("other-key"), 8)
+	ok, _ = other.Verify(ctx, solve(challenge))
+	assert.False(t, ok, "signed with another key")
+
+	// issued signed out, spent signed in (and the other way round)
+	token = solve(challenge)
+	ok, _ = pow.Verify(WithSubject(ctx, "alice"), token)
+	assert.False(t, ok, "wrong subject")
+	challenge, _ = pow.Issue("alice", "1.2.3.4", now.Add(-30*24*time.Hour))
+	assert.Equal(t, 8, challenge.Difficulty, "established account")
+	ok, _ = pow.Verify(WithSubject(ctx, "alice"), solve(challenge))
+	assert.True(t, ok, "right subject")
+
+	challenge, _ = pow.Issue("", "1.2.3.4", time.Time{})
+	*now = now.Add(pow.TTL)
+	ok, _ = pow.Verify(ctx, solve(challenge))
+	assert.False(t, ok, "expired")
+}
+
+func TestProofOfWorkDifficulty(t *testing.T) {
+	pow, now := testProofOfWork(t)
+
+	challenge, _ := pow.Issue("bob", "1.2.3.4", now.Add(-time.Hour))
+	assert.Equal(t, 8+pow.NewAccountExtra, challenge.Difficulty, "new account")
+
+	for range pow.HotIPThreshold {
+		pow.Issue("", "5.6.7.8", time.Time{})
+	}
+	challenge, _ = pow.Issue("", "5.6.7.8", time.Time{})
+	assert.Equal(t, 8+pow.HotIPExtra, challenge.Difficulty, "hot IP")
+	challenge, _ = pow.Issue("", "9.9.9.9", time.Time{})
+	assert.Equal(t, 8, challenge.Difficulty, "other IPs aren't affected")
+
+	pow.MaxDifficulty = 12
+	challenge, _ = pow.Issue("bob", "5.6.7.8", now.Add(-time.Hour))
+	assert.Equal(t, 12, challenge.Difficulty, "capped")
+
+	*now = now.Add(pow.TTL)
+	challenge, _ = pow.Issue("", "5.6.7.8", time.Time{})
+	assert.Equal(t, 8, challenge.Difficulty, "cools off after a TTL")
+}
@@ -47,3 +47,13 @@ func GetEnv(key string) string {
     
     return val
 }
+
+// like GetEnv, but for optional settings: returns fallback instead of exiting when unset
+func GetEnvOrDefault(key string, fallback string) string {
+    val := os.Getenv(key)
+    if val == "" {
+        return fallback
+    }
+
+    return val
+}
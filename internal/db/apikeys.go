@@ -0,0 +1,92 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// what an API key is allowed to do, a JWT can do all of it
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"    // signed in GETs, ie. your own votes
+	ScopeVote    Scope = "vote"    // voting on and flagging submissions and comments
+	ScopeSubmit  Scope = "submit"  // posting and deleting submissions, editing your bio
+	ScopeComment Scope = "comment" // posting and deleting comments
+	ScopeAdmin   Scope = "admin"   // admin routes, the user still needs the role (see Role)
+)
+
+var Scopes = []Scope{ScopeRead, ScopeVote, ScopeSubmit, ScopeComment, ScopeAdmin}
+
+// most keys a user can have at once, revoked ones don't count
+const maxAPIKeys = 25
+
+// every key starts with this, so one pasted somewhere it shouldn't be is easy to spot
+const apiKeyPrefix = "hn_"
+
+// one of a user's API keys, the key itself is never stored (0007 migration), only a hash of it
+type APIKey struct {
+	Id        string
+	Username  string
+	Name      string
+	Prefix    string // first few characters of the key, so people can tell their keys apart
+	Scopes    []Scope
+	CreatedAt string
+	ExpiresAt string // "" if it never expires
+	LastUsed  string // "" until it's first used
+}
+
+// what to create a key with
+type APIKeyOptions struct {
+	Name      string
+	Scopes    []Scope
+	ExpiresAt time.Time // zero for a key that never expires
+}
+
+func (k APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (o APIKeyOptions) validate(op string, now time.Time) error {
+	if o.Name == "" || len(o.Name) > 100 {
+		return newError(op, ErrInvalidInput, "key name must be between 1 and 100 characters")
+	}
+	if len(o.Scopes) == 0 {
+		return newError(op, ErrInvalidInput, "a key needs at least one scope")
+	}
+
+	seen := map[Scope]bool{}
+	for _, scope := range o.Scopes {
+		if !(APIKey{Scopes: Scopes}).HasScope(scope) {
+			return newError(op, ErrInvalidInput, "unknown scope %q", scope)
+		}
+		if seen[scope] {
+			return newError(op, ErrInvalidInput, "scope %q given twice", scope)
+		}
+		seen[scope] = true
+	}
+
+	if !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(now) {
+		return newError(op, ErrInvalidInput, "expiry must be in the future")
+	}
+	return nil
+}
+
+// a fresh key, what's shown of it, and what's stored
+func newAPIKey() (token string, prefix string, hash string) {
+	token = apiKeyPrefix + SecureToken(40)
+	return token, token[:len(apiKeyPrefix)+8], hashToken(token)
+}
+
+// how API keys and refresh tokens are stored, they're long random strings so a plain SHA-256 is plenty,
+// there's nothing to brute force
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		james := User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		bob := User{Username: "bob", Email: "bob@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		store.CreateUser(ctx, bob)
+		defer store.DeleteUser(ctx, james)
+		defer store.DeleteUser(ctx, bob)
+
+		for name, opts := range map[string]APIKeyOptions{
+			"no name":       {Scopes: []Scope{ScopeRead}},
+			"no scopes":     {Name: "bot"},
+			"unknown scope": {Name: "bot", Scopes: []Scope{"everything"}},
+			"repeat scope":  {Name: "bot", Scopes: []Scope{ScopeRead, ScopeRead}},
+			"expired":       {Name: "bot", Scopes: []Scope{ScopeRead}, ExpiresAt: time.Now().Add(-time.Hour)},
+		} {
+			_, _, err := store.CreateAPIKey(ctx, james, opts)
+			assert.True(t, errors.Is(err, ErrInvalidInput), name)
+		}
+		_, _, err := store.CreateAPIKey(ctx, User{Username: "nobody"}, APIKeyOptions{Name: "bot", Scopes: []Scope{ScopeRead}})
+		assert.True(t, errors.Is(err, ErrNotFound), "no such user")
+
+		key, token, err := store.CreateAPIKey(ctx, james, APIKeyOptions{Name: "bot", Scopes: []Scope{ScopeRead, ScopeVote}, ExpiresAt: time.Now().Add(time.Hour)})
+		assert.Nil(t, err, "create")
+		assert.True(t, strings.HasPrefix(token, key.Prefix), "prefix is the start of the key")
+		assert.NotEqual(t, token, key.Prefix, "prefix isn't the whole key")
+		assert.NotEqual(t, "", key.ExpiresAt, "expiry kept")
+		assert.Equal(t, "", key.LastUsed, "never used")
+
+		found, err := store.ValidateAPIKey(ctx, token)
+		assert.Nil(t, err, "validate")
+		assert.Equal(t, key.Id, found.Id, "same key")
+		assert.Equal(t, "james", found.Username, "owner")
+		assert.True(t, found.HasScope(ScopeVote), "scopes kept")
+		assert.False(t, found.HasScope(ScopeAdmin), "only the scopes asked for")
+		assert.NotEqual(t, "", found.LastUsed, "last used recorded")
+
+		_, err = store.ValidateAPIKey(ctx, token+"x")
+		assert.True(t, errors.Is(err, ErrNotFound), "wrong key")
+
+		second, _, err := store.CreateAPIKey(ctx, james, APIKeyOptions{Name: "other bot", Scopes: []Scope{ScopeRead}})
+		assert.Nil(t, err, "second key")
+		keys, err := store.ListAPIKeys(ctx, james)
+		assert.Nil(t, err, "list")
+		assert.Equal(t, 2, len(keys), "both keys")
+		assert.Equal(t, second.Id, keys[0].Id, "newest first")
+
+		// someone else's key is as good as missing
+		assert.True(t, errors.Is(store.RevokeAPIKey(ctx, bob, key.Id), ErrNotFound), "revoke someone else's")
+		_, _, err = store.RotateAPIKey(ctx, bob, key.Id)
+		assert.True(t, errors.Is(err, ErrNotFound), "rotate someone else's")
+
+		rotated, newToken, err := store.RotateAPIKey(ctx, james, key.Id)
+		assert.Nil(t, err, "rotate")
+		assert.NotEqual(t, key.Id, rotated.Id, "new key")
+		assert.Equal(t, key.Name, rotated.Name, "same name")
+		assert.Equal(t, key.Scopes, rotated.Scopes, "same scopes")
+		_, err = store.ValidateAPIKey(ctx, token)
+		assert.True(t, errors.Is(err, ErrNotFound), "old key is dead")
+		_, err = store.ValidateAPIKey(ctx, newToken)
+		assert.Nil(t, err, "new key works")
+
+		assert.Nil(t, store.RevokeAPIKey(ctx, james, rotated.Id), "revoke")
+		assert.True(t, errors.Is(store.RevokeAPIKey(ctx, james, rotated.Id), ErrNotFound), "revoke twice")
+		_, err = store.ValidateAPIKey(ctx, newToken)
+		assert.True(t, errors.Is(err, ErrNotFound), "revoked key is dead")
+
+		keys, _ = store.ListAPIKeys(ctx, james)
+		assert.Equal(t, 1, len(keys), "revoked keys aren't listed")
+	})
+}
+
+// Postgres won't create a key that's already expired, so moving the clock is the only way to get one
+func TestAPIKeyExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.CreateUser(ctx, User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"})
+
+	_, token, err := store.CreateAPIKey(ctx, User{Username: "james"}, APIKeyOptions{Name: "bot", Scopes: []Scope{ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err, "create")
+
+	keys, _ := store.ListAPIKeys(ctx, User{Username: "james"})
+
+	store.now = func() time.Time { return time.Now().UTC().Add(2 * time.Hour) }
+	_, err = store.ValidateAPIKey(ctx, token)
+	assert.True(t, errors.Is(err, ErrNotFound), "expired")
+	_, _, err = store.RotateAPIKey(ctx, User{Username: "james"}, keys[0].Id)
+	assert.True(t, errors.Is(err, ErrNotFound), "rotating an expired key")
+}
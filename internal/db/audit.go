@@ -0,0 +1,46 @@
+package db
+
+import (
+	"time"
+)
+
+// one row of the audit log, which is append-only (0004 migration)
+type AuditEntry struct {
+	Id        string
+	Username  string // who did it, "" when there's no account to pin it on
+	Event     AuditEvent
+	Metadata  map[string]string // what it was done to, e.g. {"submission": "<id>"} or {"reason": "..."}
+	Ip        string
+	Timestamp string
+}
+
+// narrows down AuditLog, zero values match everything
+type AuditFilter struct {
+	Username string
+	Event    AuditEvent
+	After    time.Time // at or after
+	Before   time.Time // strictly before
+}
+
+var auditEvents = []AuditEvent{Login, Logout, FailedLogin, Post, CommentEvent, PostClick, SentEmail, VoteEvent, ReportEvent, DeleteEvent, AdminAction, APIKeyEvent, PasskeyEvent, TwoFactorEvent}
+
+func checkAuditEvent(op string, event AuditEvent) error {
+	for _, known := range auditEvents {
+		if event == known {
+			return nil
+		}
+	}
+	return newError(op, ErrInvalidInput, "unknown audit event %q", event)
+}
+
+func (f AuditFilter) validate(op string) error {
+	if f.Event != "" {
+		if err := checkAuditEvent(op, f.Event); err != nil {
+			return err
+		}
+	}
+	if !f.After.IsZero() && !f.Before.IsZero() && !f.After.Before(f.Before) {
+		return newError(op, ErrInvalidInput, "empty time range")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		start := time.Now().UTC().Add(-time.Minute)
+
+		// the log is append-only, so a fresh name keeps runs against the same database apart
+		who := "audit_" + uuid.NewString()[:8]
+
+		events := []AuditEvent{Login, Post, VoteEvent, VoteEvent, DeleteEvent}
+		for _, event := range events {
+			err := store.RecordAudit(ctx, AuditEntry{Username: who, Event: event, Metadata: map[string]string{"submission": "abc"}, Ip: "127.0.0.1"})
+			assert.Nil(t, err, "record %s", event)
+		}
+		assert.Nil(t, store.RecordAudit(ctx, AuditEntry{Event: FailedLogin, Ip: "127.0.0.1"}), "no username")
+
+		err := store.RecordAudit(ctx, AuditEntry{Username: who, Event: "made_up", Ip: "127.0.0.1"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "unknown event")
+
+		entries, next, err := store.AuditLog(ctx, AuditFilter{Username: who}, Page{Limit: 10})
+		assert.Nil(t, err, "query")
+		assert.Equal(t, "", next, "one page")
+		assert.Equal(t, len(events), len(entries), "every event for the user")
+		assert.Equal(t, DeleteEvent, entries[0].Event, "newest first")
+		assert.Equal(t, "abc", entries[0].Metadata["submission"], "metadata kept")
+		assert.Equal(t, "127.0.0.1", entries[0].Ip, "ip kept")
+
+		votes, _, _ := store.AuditLog(ctx, AuditFilter{Username: who, Event: VoteEvent}, Page{Limit: 10})
+		assert.Equal(t, 2, len(votes), "event filter")
+
+		recent, _, _ := store.AuditLog(ctx, AuditFilter{Username: who, After: start, Before: start.Add(time.Hour)}, Page{Limit: 10})
+		assert.Equal(t, len(events), len(recent), "time range")
+		old, _, _ := store.AuditLog(ctx, AuditFilter{Username: who, Before: start}, Page{Limit: 10})
+		assert.Equal(t, 0, len(old), "nothing before the test started")
+
+		seen := map[string]bool{}
+		page := Page{Limit: 2}
+		for {
+			entries, next, err = store.AuditLog(ctx, AuditFilter{Username: who}, page)
+			assert.Nil(t, err, "page")
+			for _, e := range entries {
+				assert.False(t, seen[e.Id], "no entry on two pages")
+				seen[e.Id] = true
+			}
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		assert.Equal(t, len(events), len(seen), "paged through everything")
+
+		_, _, err = store.AuditLog(ctx, AuditFilter{After: start, Before: start}, Page{Limit: 10})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "empty range")
+	})
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// the score/upvotes/downvotes/comment_count columns on submissions and comments
+// (migration 0002) are caches of the votes, comment_votes and comments tables
+// every write that changes those tables also updates the counters in the same transaction
+
+// moves the vote counters on a submission or comment by the given amounts and returns the new score
+// table is always "submissions" or "comments", never user input
+func adjustVoteCounters(ctx context.Context, tx *sql.Tx, table string, id string, upvotes int, downvotes int) (int, error) {
+	var score int
+	err := tx.QueryRowContext(ctx,
+		"UPDATE "+table+" SET upvotes = upvotes + $1, downvotes = downvotes + $2, score = score + $1 - $2 WHERE id = $3 RETURNING score",
+		upvotes, downvotes, id,
+	).Scan(&score)
+
+	return score, err
+}
+
+// a user's votes cascade away when they're deleted, this takes them off the counters beforehand
+// (votes are unique per user, so each row joins at most one vote)
+func removeUserVotesFromCounters(ctx context.Context, tx *sql.Tx, username string) error {
+	queries := []string{`
+		UPDATE submissions SET
+			upvotes = upvotes - (CASE WHEN votes.positive THEN 1 ELSE 0 END),
+			downvotes = downvotes - (CASE WHEN votes.positive THEN 0 ELSE 1 END),
+			score = score - (CASE WHEN votes.positive THEN 1 ELSE -1 END)
+		FROM votes
+		WHERE votes.submission_id = submissions.id AND votes.voter_username = $1
+	`, `
+		UPDATE comments SET
+			upvotes = upvotes - (CASE WHEN comment_votes.positive THEN 1 ELSE 0 END),
+			downvotes = downvotes - (CASE WHEN comment_votes.positive THEN 0 ELSE 1 END),
+			score = score - (CASE WHEN comment_votes.positive THEN 1 ELSE -1 END)
+		FROM comment_votes
+		WHERE comment_votes.comment_id = comments.id AND comment_votes.voter_username = $1
+	`}
+
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// a user's comments go when they're deleted, this takes them off the post's and parent's counts
+// the way DeleteComment does, then deletes them
+// a comment someone else has replied to holds the whole thing back, parent_comment has no cascade
+func removeUserComments(ctx context.Context, tx *sql.Tx, username string) error {
+	queries := []string{`
+		UPDATE submissions SET comment_count = comment_count - gone.count
+		FROM (SELECT in_response_to AS id, COUNT(*) AS count FROM comments WHERE author = $1 GROUP BY in_response_to) gone
+		WHERE submissions.id = gone.id
+	`, `
+		UPDATE comments SET comment_count = comment_count - gone.count
+		FROM (SELECT parent_comment AS id, COUNT(*) AS count FROM comments WHERE author = $1 AND parent_comment IS NOT NULL GROUP BY parent_comment) gone
+		WHERE comments.id = gone.id
+	`,
+		"DELETE FROM comments WHERE author = $1",
+	}
+
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// how many rows RepairCounters had to fix
+type CounterRepair struct {
+	Submissions int64
+	Comments    int64
+}
+
+// recomputes every counter from the raw tables and fixes any that have drifted
+// the source tables are locked against writes while it runs, so votes and comments
+// will wait until it's done
+//
+// Postgres only, MemoryStore works its counts out on read so it can't drift
+func (s *PostgresStore) RepairCounters(ctx context.Context) (CounterRepair, error) {
+	var repair CounterRepair
+
+	err := s.inTx(ctx, "RepairCounters", func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE votes, comment_votes, comments IN SHARE MODE"); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE submissions SET
+				upvotes = actual.upvotes,
+				downvotes = actual.downvotes,
+				score = actual.upvotes - actual.downvotes,
+				comment_count = actual.comment_count
+			FROM (
+				SELECT s.id,
+					(SELECT COUNT(*) FROM votes v WHERE v.submission_id = s.id AND v.positive) AS upvotes,
+					(SELECT COUNT(*) FROM votes v WHERE v.submission_id = s.id AND NOT v.positive) AS downvotes,
+					(SELECT COUNT(*) FROM comments c WHERE c.in_response_to = s.id) AS comment_count
+				FROM submissions s
+			) actual
+			WHERE submissions.id = actual.id
+			AND (submissions.upvotes, submissions.downvotes, submissions.score, submissions.comment_count)
+				IS DISTINCT FROM (actual.upvotes, actual.downvotes, actual.upvotes - actual.downvotes, actual.comment_count)
+		`)
+		if err != nil {
+			return err
+		}
+		if repair.Submissions, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		res, err = tx.ExecContext(ctx, `
+			UPDATE comments SET
+				upvotes = actual.upvotes,
+				downvotes = actual.downvotes,
+				score = actual.upvotes - actual.downvotes,
+				comment_count = actual.comment_count
+			FROM (
+				SELECT c.id,
+					(SELECT COUNT(*) FROM comment_votes v WHERE v.comment_id = c.id AND v.positive) AS upvotes,
+					(SELECT COUNT(*) FROM comment_votes v WHERE v.comment_id = c.id AND NOT v.positive) AS downvotes,
+					(SELECT COUNT(*) FROM comments r WHERE r.parent_comment = c.id) AS comment_count
+				FROM comments c
+			) actual
+			WHERE comments.id = actual.id
+			AND (comments.upvotes, comments.downvotes, comments.score, comments.comment_count)
+				IS DISTINCT FROM (actual.upvotes, actual.downvotes, actual.upvotes - actual.downvotes, actual.comment_count)
+		`)
+		if err != nil {
+			return err
+		}
+		repair.Comments, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return CounterRepair{}, err
+	}
+
+	log.Printf("[INFO] Counter repair fixed %d submission(s) and %d comment(s)\n", repair.Submissions, repair.Comments)
+
+	return repair, nil
+}
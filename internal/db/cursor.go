@@ -0,0 +1,73 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// which page of a list query to return
+// Cursor -> NextCursor from the previous page, "" for the first page
+// Limit -> page size, required, the API picks the default/maximum (see cmd/hn/settings.go)
+type Page struct {
+	Cursor string
+	Limit  int
+}
+
+// there's no package-wide default on purpose, every caller says how many rows it wants
+func (p Page) size(op string) (int, error) {
+	if p.Limit <= 0 {
+		return 0, newError(op, ErrInvalidInput, "page limit must be > 0, got %d", p.Limit)
+	}
+	return p.Limit, nil
+}
+
+// position of the last row on a page (keyset pagination), the next page starts strictly after it
+// clients only ever see it base64 encoded, so the fields can change without breaking anyone
+// beyond invalidating cursors that are in flight
+type cursor struct {
+	Sort      SortMethod `json:"s,omitempty"` // so a cursor can't be replayed against a different sort
+	CreatedAt string     `json:"c,omitempty"`
+	Id        string     `json:"i"`
+	Score     int        `json:"v,omitempty"` // Best
+	Rank      float64    `json:"r,omitempty"` // Hot
+	AsOf      string     `json:"t,omitempty"` // Hot: ranks are computed as of this time on every page so they don't shift
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// nil (and no error) for the first page
+func decodeCursor(op string, encoded string, sort SortMethod) (*cursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newError(op, ErrInvalidInput, "malformed cursor")
+	}
+
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Id == "" {
+		return nil, newError(op, ErrInvalidInput, "malformed cursor")
+	}
+
+	if c.Sort != sort {
+		return nil, newError(op, ErrInvalidInput, "cursor is for a different sort order")
+	}
+
+	return &c, nil
+}
+
+// trims the extra row fetched to see if there's another page, and builds the cursor for it
+// "" when this is the last page
+func paginate[T any](items []T, limit int, position func(last T) cursor) ([]T, string) {
+	if len(items) <= limit {
+		return items, ""
+	}
+
+	items = items[:limit]
+	return items, position(items[len(items)-1]).encode()
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+
+		var ids []string
+		for i := 0; i < 7; i++ {
+			id, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: fmt.Sprintf("Page me %d", i), Link: "https://example.com"})
+			ids = append(ids, id)
+		}
+
+		for _, sort := range []SortMethod{Latest, Oldest, Best, Hot} {
+			seen := map[string]bool{}
+			page := Page{Limit: 3}
+			pages := 0
+
+			for {
+				results, next, err := store.AllSubmissions(ctx, sort, page)
+				assert.Nil(t, err, "page of %s", sort)
+				assert.LessOrEqual(t, len(results), 3, "page respects limit")
+
+				for _, sub := range results {
+					assert.False(t, seen[sub.Id], "%s: no submission on two pages", sort)
+					seen[sub.Id] = true
+				}
+
+				pages++
+				if next == "" {
+					break
+				}
+				page.Cursor = next
+
+				// a post arriving mid-walk shouldn't shift anything onto a page we've already seen
+				if pages == 1 {
+					id, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Late arrival " + string(sort), Link: "https://example.com"})
+					ids = append(ids, id)
+				}
+			}
+
+			for _, id := range ids[:7] {
+				assert.True(t, seen[id], "%s: every original submission appears", sort)
+			}
+		}
+
+		_, _, err := store.AllSubmissions(ctx, Latest, Page{Cursor: "not a cursor"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "garbage cursor")
+
+		_, next, _ := store.AllSubmissions(ctx, Best, Page{Limit: 1})
+		_, _, err = store.AllSubmissions(ctx, Latest, Page{Cursor: next})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "cursor from another sort")
+
+		mine, next, err := store.LatestUserSubmissions(ctx, Page{Limit: 100}, james)
+		assert.Nil(t, err, "user submissions")
+		assert.Equal(t, len(ids), len(mine), "all of james' submissions on one page")
+		assert.Equal(t, "", next, "no next page")
+
+		for _, id := range ids {
+			store.DeleteSubmission(ctx, Submission{Id: id})
+		}
+		store.DeleteUser(ctx, james)
+	})
+}
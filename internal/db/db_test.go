@@ -1,68 +1,330 @@
 package db
 
 import (
-    "testing"
-    "fmt"
-    "github.com/stretchr/testify/assert"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
+// runs a test against every Store implementation
+// the Postgres one talks to a real database, so it only runs when HN_TEST_POSTGRES is set
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		if os.Getenv("HN_TEST_POSTGRES") == "" {
+			t.Skip("HN_TEST_POSTGRES not set")
+		}
+		test(t, NewPostgresStore(GetDB()))
+	})
+}
+
 func TestUserCreation(t *testing.T) {
-    CreateUser(User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"})
-    var res CompleteUser = SearchUser(User{Username: "james"})
-    var coreUserData User = res.User
-   
-    assert.Equal(t, coreUserData.Username, "james", "select statement for sample user (username)")
-    assert.Equal(t, coreUserData.Email, "test@example.com", "select statement for sample user (email)")
-    assert.Equal(t, coreUserData.Registered_ip, "127.0.0.1", "select statement for sample user (ip address)")
-    DeleteUser(User{Username: "james"})
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		store.CreateUser(ctx, User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"})
+		res, err := store.SearchUser(ctx, User{Username: "james"})
+		var coreUserData User = res.User
+
+		assert.Nil(t, err, "select statement for sample user (error)")
+		assert.Equal(t, coreUserData.Username, "james", "select statement for sample user (username)")
+		assert.Equal(t, coreUserData.Email, "test@example.com", "select statement for sample user (email)")
+		assert.Equal(t, coreUserData.Registered_ip, "127.0.0.1", "select statement for sample user (ip address)")
+		store.DeleteUser(ctx, User{Username: "james"})
+	})
 }
 
 func TestUserBioCreation(t *testing.T) {
-    var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
-    var jamesPersonalDetails UserMetadata = UserMetadata{Username: "james", Full_name: "James Johnson", Birthdate: "02/03/2001", Bio_text: "blahblahblahblahblahblah"}
-    CreateUser(james)
-    UpsertUserMetadata(jamesPersonalDetails)
-    var res UserMetadata = SearchUser(james).Metadata
-    assert.Equal(t, res.Full_name, "James Johnson", "metadata full name")
-    jamesPersonalDetails.Bio_text = "this is my new bio"
-    UpsertUserMetadata(jamesPersonalDetails)
-    res = SearchUser(james).Metadata
-    assert.Equal(t, res.Bio_text, "this is my new bio", "metadata test bio update")
-    DeleteUser(james)
-    var res2 UserMetadata = SearchUser(james).Metadata
-    assert.Equal(t, res2.Full_name, "", "metadata ensure postgres cascade delete")
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		var jamesPersonalDetails UserMetadata = UserMetadata{Username: "james", Full_name: "James Johnson", Birthdate: "02/03/2001", Bio_text: "blahblahblahblahblahblah"}
+		store.CreateUser(ctx, james)
+		store.UpsertUserMetadata(ctx, jamesPersonalDetails)
+		res, _ := store.SearchUser(ctx, james)
+		assert.Equal(t, res.Metadata.Full_name, "James Johnson", "metadata full name")
+		jamesPersonalDetails.Bio_text = "this is my new bio"
+		store.UpsertUserMetadata(ctx, jamesPersonalDetails)
+		res, _ = store.SearchUser(ctx, james)
+		assert.Equal(t, res.Metadata.Bio_text, "this is my new bio", "metadata test bio update")
+		store.DeleteUser(ctx, james)
+		res2, err := store.SearchUser(ctx, james)
+		assert.Equal(t, res2.Metadata.Full_name, "", "metadata ensure postgres cascade delete")
+		assert.True(t, errors.Is(err, ErrNotFound), "deleted user is not found")
+	})
 }
 
 func TestSubmissionCreation(t *testing.T) {
-    // need a test user in the database due to FKs
-    var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
-    CreateUser(james)
-    var testSubmission Submission = Submission{Username: "james", Title: "Google Search", Link: "https://www.google.com", Body: "here's a search engine", Flagged: true}
-    var generatedID string = CreateSubmission(testSubmission)
-    var searchedSubmission Submission = SearchSubmission(Submission{Id: generatedID})
-    assert.Equal(t, searchedSubmission.Body, testSubmission.Body, "submission insert, check body is the same")
-    assert.Equal(t, searchedSubmission.Flagged, testSubmission.Flagged, "submission insert, check flagged is the same")
-    assert.Equal(t, searchedSubmission.Link, testSubmission.Link, "submission insert, check link is the same")
-    assert.Equal(t, searchedSubmission.Title, testSubmission.Title, "submission insert, check title is the same")
-    DeleteSubmission(Submission{Id: generatedID})
-    searchedSubmission = SearchSubmission(Submission{Id: generatedID})
-    assert.Equal(t, searchedSubmission.Link, "", "submission delete, ensure link is blank")
-    DeleteUser(james)
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		// need a test user in the database due to FKs
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		var testSubmission Submission = Submission{Username: "james", Title: "Google Search", Link: "https://www.google.com", Body: "here's a search engine", Flagged: true}
+		generatedID, _ := store.CreateSubmission(ctx, testSubmission)
+		searchedSubmission, _ := store.SearchSubmission(ctx, Submission{Id: generatedID})
+		assert.Equal(t, searchedSubmission.Body, testSubmission.Body, "submission insert, check body is the same")
+		assert.Equal(t, searchedSubmission.Flagged, testSubmission.Flagged, "submission insert, check flagged is the same")
+		assert.Equal(t, searchedSubmission.Link, testSubmission.Link, "submission insert, check link is the same")
+		assert.Equal(t, searchedSubmission.Title, testSubmission.Title, "submission insert, check title is the same")
+		store.DeleteSubmission(ctx, Submission{Id: generatedID})
+		searchedSubmission, err := store.SearchSubmission(ctx, Submission{Id: generatedID})
+		assert.Equal(t, searchedSubmission.Link, "", "submission delete, ensure link is blank")
+		assert.True(t, errors.Is(err, ErrNotFound), "submission delete, ensure not found")
+		store.DeleteUser(ctx, james)
+	})
+}
+
+func TestSearchSubmissionBadID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		_, err := store.SearchSubmission(context.Background(), Submission{Id: "not-a-uuid"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "malformed UUID is invalid input, not a crash")
+	})
+}
+
+func TestVoting(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		var anna User = User{Username: "anna", Email: "anna@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		store.CreateUser(ctx, anna)
+		id, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Vote on me", Link: "https://example.com"})
+
+		res, err := store.Vote(ctx, anna, Submission{Id: id}, true)
+		assert.Nil(t, err, "first vote")
+		assert.Equal(t, VoteResult{Changed: true, Score: 1}, res, "first vote goes through")
+
+		res, _ = store.Vote(ctx, anna, Submission{Id: id}, true)
+		assert.Equal(t, VoteResult{Changed: false, Score: 1}, res, "double vote rejected")
+
+		res, _ = store.Vote(ctx, anna, Submission{Id: id}, false)
+		assert.Equal(t, VoteResult{Changed: true, Score: -1}, res, "vote can be flipped")
+
+		votes, _ := store.CountVotes(ctx, Submission{Id: id})
+		assert.Equal(t, VoteMetrics{Upvotes: 0, Downvotes: 1}, votes, "flipped vote is counted once")
+
+		didVote, didUpvote, _ := store.GetUserVote(ctx, anna, Submission{Id: id})
+		assert.True(t, didVote, "vote recorded")
+		assert.False(t, didUpvote, "vote recorded as a downvote")
+
+		user, _ := store.SearchUser(ctx, james)
+		assert.Equal(t, -1, user.User.Score, "author score follows votes")
+
+		res, err = store.Unvote(ctx, anna, Submission{Id: id})
+		assert.Nil(t, err, "unvote")
+		assert.Equal(t, VoteResult{Changed: true, Score: 0}, res, "unvote removes the vote")
+
+		res, _ = store.Unvote(ctx, anna, Submission{Id: id})
+		assert.False(t, res.Changed, "nothing left to unvote")
+
+		didVote, _, _ = store.GetUserVote(ctx, anna, Submission{Id: id})
+		assert.False(t, didVote, "vote gone after unvote")
+
+		store.DeleteSubmission(ctx, Submission{Id: id})
+		store.DeleteUser(ctx, james)
+		store.DeleteUser(ctx, anna)
+	})
+}
+
+func TestCommentsAndReports(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		postId, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Discuss", Link: "https://example.com"})
+
+		commentId, err := store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: "first", Author: "james"})
+		assert.Nil(t, err, "comment insert")
+
+		_, err = store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: "reply", Author: "nobody"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "comment from unknown author violates FK")
+
+		vote, _ := store.VoteOnComment(ctx, james, Comment{Id: commentId}, true)
+		assert.Equal(t, 1, vote.Score, "comment vote returns the new score")
+		comments, _ := store.GetCommentsOnSubmission(ctx, Submission{Id: postId}, james)
+		assert.Equal(t, 1, len(comments), "one comment on post")
+		assert.Equal(t, 1, comments[0].Upvotes, "comment upvote counted")
+		assert.True(t, comments[0].HasUpvoted, "viewer's upvote reported")
+
+		vote, _ = store.UnvoteComment(ctx, james, Comment{Id: commentId})
+		assert.Equal(t, VoteResult{Changed: true, Score: 0}, vote, "comment unvote")
+		comments, _ = store.GetCommentsOnSubmission(ctx, Submission{Id: postId}, james)
+		assert.False(t, comments[0].HasUpvoted, "viewer's upvote gone")
+
+		_, _, err = store.ReportComment(ctx, Comment{Id: commentId}, james)
+		assert.Nil(t, err, "first report")
+		_, _, err = store.ReportComment(ctx, Comment{Id: commentId}, james)
+		assert.True(t, errors.Is(err, ErrConflict), "second report from same user")
+	})
+}
+
+func TestReputation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, name := range []string{"rita", "sam", "tess"} {
+			store.CreateUser(ctx, User{Username: name, Email: name + "@example.com", Registered_ip: "127.0.0.1"})
+		}
+		postId, _ := store.CreateSubmission(ctx, Submission{Username: "rita", Title: "Reputation", Link: "https://example.com"})
+		store.Vote(ctx, User{Username: "sam"}, Submission{Id: postId}, true)
+
+		reputation, err := store.GetReputation(ctx, User{Username: "rita"})
+		assert.Nil(t, err, "GetReputation")
+		assert.Equal(t, Reputation{AgeDays: 0, Karma: 1}, reputation, "brand new, one upvote")
+
+		_, err = store.GetReputation(ctx, User{Username: "nobody"})
+		assert.True(t, errors.Is(err, ErrNotFound), "unknown user")
+		_, err = store.GetReputation(ctx, User{})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "blank username")
+
+		// reports from accounts less than a day old weigh nothing, so only the memory store's clock can age them
+		memory, ok := store.(*MemoryStore)
+		if !ok {
+			return
+		}
+		memory.now = func() time.Time { return time.Now().UTC().Add(60 * 24 * time.Hour) }
+
+		store.ReportSubmission(ctx, User{Username: "sam"}, Submission{Id: postId})
+		store.ReportSubmission(ctx, User{Username: "tess"}, Submission{Id: postId})
+		reputation, _ = store.GetReputation(ctx, User{Username: "rita"})
+		assert.Equal(t, Reputation{AgeDays: 60, Karma: 1, ReportWeight: 1.0, Flagged: 1}, reputation, "two reports flagged the post")
+	})
+}
+
+func TestCounters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		var anna User = User{Username: "anna", Email: "anna@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		store.CreateUser(ctx, anna)
+		postId, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Count me", Link: "https://example.com"})
+
+		store.Vote(ctx, james, Submission{Id: postId}, true)
+		store.Vote(ctx, anna, Submission{Id: postId}, true)
+		store.Vote(ctx, anna, Submission{Id: postId}, false)
+
+		post, _ := store.SearchSubmission(ctx, Submission{Id: postId})
+		assert.Equal(t, 0, post.Votes, "flipped vote moves the score by two")
+		votes, _ := store.CountVotes(ctx, Submission{Id: postId})
+		assert.Equal(t, VoteMetrics{Upvotes: 1, Downvotes: 1}, votes, "counts follow the flip")
+
+		parentId, _ := store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: "parent", Author: "james"})
+		replyId, _ := store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: "reply", Author: "anna", ParentComment: parentId})
+		post, _ = store.SearchSubmission(ctx, Submission{Id: postId})
+		assert.Equal(t, 2, post.CommentCount, "replies count towards the post")
+
+		comments, _ := store.GetCommentsOnSubmission(ctx, Submission{Id: postId}, james)
+		for _, c := range comments {
+			if c.Id == parentId {
+				assert.Equal(t, 1, c.ReplyCount, "parent counts its reply")
+			}
+		}
+
+		store.DeleteComment(ctx, Comment{Id: replyId})
+		post, _ = store.SearchSubmission(ctx, Submission{Id: postId})
+		assert.Equal(t, 1, post.CommentCount, "deleted comment comes off the count")
+
+		// anna's downvote and comments go with her
+		store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: "another reply", Author: "anna", ParentComment: parentId})
+		assert.Nil(t, store.DeleteUser(ctx, anna), "delete user with comments")
+		post, _ = store.SearchSubmission(ctx, Submission{Id: postId})
+		assert.Equal(t, 1, post.Votes, "deleted user's vote comes off the score")
+		assert.Equal(t, 1, post.CommentCount, "deleted user's comments come off the count")
+		comments, _ = store.GetCommentsOnSubmission(ctx, Submission{Id: postId}, james)
+		assert.Equal(t, 1, len(comments), "only the parent is left")
+		assert.Equal(t, 0, comments[0].ReplyCount, "parent's reply count too")
+
+		store.DeleteComment(ctx, Comment{Id: parentId})
+		store.DeleteSubmission(ctx, Submission{Id: postId})
+		store.DeleteUser(ctx, james)
+	})
 }
 
 func TestCreateMagicLink(t *testing.T) {
-    // need a test user in the database due to FKs
-    var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
-    CreateUser(james)
-    var insertedToken string = CreateMagicLink(james)
-    fmt.Println(insertedToken)
-    DeleteUser(james)
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		// need a test user in the database due to FKs
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		insertedToken, err := store.CreateMagicLink(ctx, james, time.Now().Add(15*time.Minute))
+		assert.Nil(t, err, "magic link creation")
+		fmt.Println(insertedToken)
+
+		user, err := store.ValidateMagicLink(ctx, insertedToken, "127.0.0.1")
+		assert.Nil(t, err, "magic link validation")
+		assert.Equal(t, "james", user.Username, "magic link resolves to user")
+
+		_, err = store.ValidateMagicLink(ctx, insertedToken, "127.0.0.1")
+		assert.True(t, errors.Is(err, ErrNotFound), "magic link is single use")
+		store.DeleteUser(ctx, james)
+	})
 }
 
 func TestCreateMagicLinkTwo(t *testing.T) {
-    fmt.Println(CreateMagicLink(User{Email: "me@trentwil.es"}))
+	forEachStore(t, func(t *testing.T, store Store) {
+		_, err := store.CreateMagicLink(context.Background(), User{Email: "me@trentwil.es"}, time.Now().Add(15*time.Minute))
+		assert.True(t, errors.Is(err, ErrInvalidInput), "magic link without a username")
+	})
+}
+
+func TestMagicLinkExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		james := User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		defer store.DeleteUser(ctx, james)
+
+		stale, err := store.CreateMagicLink(ctx, james, time.Now().Add(-time.Minute))
+		assert.Nil(t, err, "magic link creation")
+		_, err = store.ValidateMagicLink(ctx, stale, "127.0.0.1")
+		assert.True(t, errors.Is(err, ErrNotFound), "expired link")
+
+		store.CreateMagicLink(ctx, james, time.Now().Add(-time.Minute))
+		purged, err := store.PurgeExpiredMagicLinks(ctx)
+		assert.Nil(t, err, "purge")
+		assert.GreaterOrEqual(t, purged, 1, "expired link purged")
+
+		fresh, _ := store.CreateMagicLink(ctx, james, time.Now().Add(15*time.Minute))
+		store.PurgeExpiredMagicLinks(ctx)
+		_, err = store.ValidateMagicLink(ctx, fresh, "127.0.0.1")
+		assert.Nil(t, err, "live link survives the purge")
+
+		replaced, _ := store.CreateMagicLink(ctx, james, time.Now().Add(15*time.Minute))
+		store.CreateMagicLink(ctx, james, time.Now().Add(15*time.Minute))
+		_, err = store.ValidateMagicLink(ctx, replaced, "127.0.0.1")
+		assert.True(t, errors.Is(err, ErrNotFound), "asking for a new link replaces the old one")
+	})
+}
+
+func TestMagicLinkOtherEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		victim := User{Username: "victim", Email: "victim@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, victim)
+		defer store.DeleteUser(ctx, victim)
+
+		token, err := store.CreateMagicLink(ctx, User{Username: "victim", Email: "attacker@example.com"}, time.Now().Add(15*time.Minute))
+		assert.Nil(t, err, "magic link creation")
+		_, err = store.ValidateMagicLink(ctx, token, "127.0.0.1")
+		assert.True(t, errors.Is(err, ErrConflict), "link sent to an address the account doesn't have")
+
+		token, _ = store.CreateMagicLink(ctx, User{Username: "victim", Email: "Victim@Example.com"}, time.Now().Add(15*time.Minute))
+		user, err := store.ValidateMagicLink(ctx, token, "127.0.0.1")
+		assert.Nil(t, err, "the account's own address, in any case")
+		assert.Equal(t, "victim", user.Username, "signed in as victim")
+	})
 }
 
 func TestCreateRandomData(t *testing.T) {
-    GenerateNonsenseData(10, 300);
-}
\ No newline at end of file
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert.Nil(t, GenerateNonsenseData(context.Background(), store, 10, 300), "random data generation")
+	})
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// kinds of failure the data layer reports, match on these with errors.Is
+// rather than digging through driver errors
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUnavailable  = errors.New("database unavailable")
+)
+
+// Error is what every function in this package returns when it fails
+// Op -> name of the function that failed
+// Kind -> one of the Err* values above, nil if the failure doesn't fit any of them
+// Err -> the underlying cause (driver error, validation message, etc.)
+type Error struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Kind == nil {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// builds an error of a known kind from a message, mostly used for validation failures
+func newError(op string, kind error, format string, args ...any) error {
+	return &Error{Op: op, Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// wraps an error coming back from database/sql, working out which kind it is
+// based on the Postgres SQLSTATE code (https://www.postgresql.org/docs/current/errcodes-appendix.html)
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// already classified further down the call stack
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Op: op, Kind: ErrNotFound, Err: err}
+	}
+
+	// client went away or the request deadline passed, leave the context error visible
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Op: op, Err: err}
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return &Error{Op: op, Kind: ErrConflict, Err: err}
+		case "23503", "23502", "23514": // foreign_key_violation, not_null_violation, check_violation
+			return &Error{Op: op, Kind: ErrInvalidInput, Err: err}
+		}
+
+		switch pqErr.Code.Class() {
+		case "22": // data exception, ie. a malformed UUID or a string that's too long
+			return &Error{Op: op, Kind: ErrInvalidInput, Err: err}
+		case "08", "53", "57": // connection exception, insufficient resources, operator intervention
+			return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+		}
+
+		return &Error{Op: op, Err: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+	}
+
+	return &Error{Op: op, Err: err}
+}
+
+// turns "nothing matched the WHERE clause" on an UPDATE/DELETE into ErrNotFound
+func expectAffected(op string, res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if n == 0 {
+		return newError(op, ErrNotFound, "no rows affected")
+	}
+
+	return nil
+}
@@ -0,0 +1,2429 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store that keeps everything in maps, guarded by a single lock
+// it mirrors the Postgres constraints (foreign keys, unique votes, malformed UUIDs)
+// closely enough that handlers behave the same against either one
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	users               map[string]*memUser
+	bios                map[string]UserMetadata
+	admins              map[string]memStaff
+	submissions         map[string]*memSubmission
+	votes               map[voteKey]*memVote
+	comments            map[string]*memComment
+	commentVotes        map[voteKey]*memVote
+	reports             []*memReport
+	audit               []*memAudit
+	magicLinks          map[string]memMagicLink           // hashToken(token) -> link
+	apiKeys             map[string]*memAPIKey             // by id, revoked keys stay so rotation history is kept
+	sessions            map[string]*memSession            // by id
+	passkeys            map[string]*memPasskey            // by id
+	challenges          map[string]*memChallenge          // passkey ceremonies, by id
+	twoFactor           map[string]*memTwoFactor          // by username
+	twoFactorChallenges map[string]*memTwoFactorChallenge // hashToken(token) -> challenge
+
+	nextReportId int
+	nextAuditId  int
+
+	// used by the Hot sort, same as PostgresStore.Ranking
+	Ranking RankingOptions
+
+	// overridable so tests can move the clock
+	now func() time.Time
+}
+
+type memUser struct {
+	User
+	createdAt     time.Time
+	passkeyHandle []byte // nil until the first PasskeyHandle
+}
+
+type memSubmission struct {
+	Submission
+	createdAt time.Time
+}
+
+type memComment struct {
+	Comment
+	createdAt time.Time
+}
+
+type memVote struct {
+	positive bool
+	ts       time.Time
+}
+
+type memReport struct {
+	Report
+	createdAt time.Time
+}
+
+type memAudit struct {
+	AuditEntry
+	createdAt time.Time
+}
+
+type memAPIKey struct {
+	APIKey
+	hash      string
+	createdAt time.Time
+	expiresAt time.Time // zero for never
+	lastUsed  time.Time // zero until first used
+	revokedAt time.Time // zero while it's live
+}
+
+// what callers get to see, times in the same format as Postgres
+func (k *memAPIKey) public() APIKey {
+	key := k.APIKey
+	key.Scopes = append([]Scope(nil), k.Scopes...)
+	key.CreatedAt = formatTime(k.createdAt)
+	if !k.expiresAt.IsZero() {
+		key.ExpiresAt = formatTime(k.expiresAt)
+	}
+	if !k.lastUsed.IsZero() {
+		key.LastUsed = formatTime(k.lastUsed)
+	}
+	return key
+}
+
+type memPasskey struct {
+	Passkey
+	createdAt time.Time
+	lastUsed  time.Time // zero until first used
+}
+
+func (p *memPasskey) public() Passkey {
+	passkey := p.Passkey
+	passkey.CredentialId = append([]byte(nil), p.CredentialId...)
+	passkey.Credential = append([]byte(nil), p.Credential...)
+	passkey.CreatedAt = formatTime(p.createdAt)
+	if !p.lastUsed.IsZero() {
+		passkey.LastUsed = formatTime(p.lastUsed)
+	}
+	return passkey
+}
+
+type memChallenge struct {
+	PasskeyChallenge
+	expiresAt time.Time
+}
+
+type memTwoFactor struct {
+	secret        string
+	enabledAt     time.Time // zero until enabled
+	lastStep      int64
+	recoveryCodes map[string]bool // hashes
+}
+
+func (t *memTwoFactor) public() TwoFactor {
+	twoFactor := TwoFactor{Enabled: !t.enabledAt.IsZero(), RecoveryCodesLeft: len(t.recoveryCodes), Secret: t.secret}
+	if twoFactor.Enabled {
+		twoFactor.EnabledAt = formatTime(t.enabledAt)
+	}
+	return twoFactor
+}
+
+type memTwoFactorChallenge struct {
+	username  string
+	attempts  int
+	expiresAt time.Time
+}
+
+type memSession struct {
+	Session
+	hash         string
+	previousHash string
+	createdAt    time.Time
+	lastSeen     time.Time
+	expiresAt    time.Time
+	revokedAt    time.Time // zero while it's live
+}
+
+func (k *memSession) public() Session {
+	session := k.Session
+	session.CreatedAt = formatTime(k.createdAt)
+	session.LastSeen = formatTime(k.lastSeen)
+	session.ExpiresAt = formatTime(k.expiresAt)
+	return session
+}
+
+// a row of the admins table
+type memStaff struct {
+	role    Role
+	remarks string
+}
+
+type memMagicLink struct {
+	username  string
+	email     string
+	expiresAt time.Time
+}
+
+// (target id, voter) pair, same shape as the UNIQUE constraint on votes/comment_votes
+type voteKey struct {
+	id       string
+	username string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:               map[string]*memUser{},
+		bios:                map[string]UserMetadata{},
+		admins:              map[string]memStaff{},
+		submissions:         map[string]*memSubmission{},
+		votes:               map[voteKey]*memVote{},
+		comments:            map[string]*memComment{},
+		commentVotes:        map[voteKey]*memVote{},
+		magicLinks:          map[string]memMagicLink{},
+		apiKeys:             map[string]*memAPIKey{},
+		sessions:            map[string]*memSession{},
+		passkeys:            map[string]*memPasskey{},
+		challenges:          map[string]*memChallenge{},
+		twoFactor:           map[string]*memTwoFactor{},
+		twoFactorChallenges: map[string]*memTwoFactorChallenge{},
+		Ranking:             DefaultRanking(),
+		now:                 func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// grants admin, there's no Store method for this since it's done by hand (or the CLI) in Postgres
+func (s *MemoryStore) AddAdmin(username string, remarks string) {
+	s.AddStaff(username, RoleAdmin, remarks)
+}
+
+// like AddAdmin, for any role, RoleUser takes the user back out of the admins table
+func (s *MemoryStore) AddStaff(username string, role Role, remarks string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if role == RoleUser {
+		delete(s.admins, username)
+		return
+	}
+	s.admins[username] = memStaff{role: role, remarks: remarks}
+}
+
+// same text format lib/pq produces when scanning a TIMESTAMP into a string
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Postgres rejects malformed UUIDs with a data exception, which wrapError turns into ErrInvalidInput
+func checkUUID(op string, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return newError(op, ErrInvalidInput, "invalid input syntax for type uuid: %q", id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user User) error {
+	if user.Username == "" || user.Email == "" || user.Registered_ip == "" {
+		return newError("CreateUser", ErrInvalidInput, "username, email and registration IP are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[user.Username]; exists {
+		return newError("CreateUser", ErrConflict, "user %s already exists", user.Username)
+	}
+
+	now := s.now()
+	user.Created_at = formatTime(now)
+	user.Score = 0
+	s.users[user.Username] = &memUser{User: user, createdAt: now}
+
+	log.Printf("[INFO] Create user %s with email %s from IP address %s\n", user.Username, user.Email, user.Registered_ip)
+	return nil
+}
+
+func (s *MemoryStore) UpsertUserMetadata(ctx context.Context, metadata UserMetadata) error {
+	if metadata.Username == "" {
+		return newError("UpsertUserMetadata", ErrInvalidInput, "please provide a username")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[metadata.Username]; !exists {
+		return newError("UpsertUserMetadata", ErrInvalidInput, "no user %s for bio", metadata.Username)
+	}
+
+	metadata.IsAdmin = false
+	s.bios[metadata.Username] = metadata
+	return nil
+}
+
+func (s *MemoryStore) SearchUser(ctx context.Context, user User) (CompleteUser, error) {
+	if user.Email == "" && user.Username == "" {
+		return CompleteUser{}, newError("SearchUser", ErrInvalidInput, "to select a user, you must pass either an email or username")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := s.findUser(user)
+	if found == nil {
+		return CompleteUser{}, newError("SearchUser", ErrNotFound, "no such user")
+	}
+
+	result := found.User
+	result.Score = s.userScore(found.Username)
+
+	metadata, hasBio := s.bios[found.Username]
+	if hasBio {
+		metadata.IsAdmin = s.admins[found.Username].role == RoleAdmin
+	}
+
+	return CompleteUser{User: result, Metadata: metadata}, nil
+}
+
+// caller must hold the lock
+func (s *MemoryStore) findUser(user User) *memUser {
+	if user.Username != "" {
+		return s.users[user.Username]
+	}
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// net votes across every submission the user has made, caller must hold the lock
+func (s *MemoryStore) userScore(username string) int {
+	score := 0
+	for key, vote := range s.votes {
+		if sub, ok := s.submissions[key.id]; ok && sub.Username == username {
+			score += voteValue(vote.positive)
+		}
+	}
+	return score
+}
+
+func voteValue(positive bool) int {
+	if positive {
+		return 1
+	}
+	return -1
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, user User) error {
+	if user.Email == "" && user.Username == "" {
+		return newError("DeleteUser", ErrInvalidInput, "to delete a user, you must pass either an email or username")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := s.findUser(user)
+	if found == nil {
+		return newError("DeleteUser", ErrNotFound, "no rows affected")
+	}
+	username := found.Username
+
+	// submissions and reports reference users without ON DELETE CASCADE
+	for _, sub := range s.submissions {
+		if sub.Username == username {
+			return newError("DeleteUser", ErrInvalidInput, "user %s still has submissions", username)
+		}
+	}
+	// comments go with the user like in PostgresStore, unless someone else has replied to one
+	for _, c := range s.comments {
+		if parent, ok := s.comments[c.ParentComment]; ok && parent.Author == username && c.Author != username {
+			return newError("DeleteUser", ErrInvalidInput, "comment %s still has replies", c.ParentComment)
+		}
+	}
+	for _, r := range s.reports {
+		if r.Reporter == username || r.Target_user == username {
+			return newError("DeleteUser", ErrInvalidInput, "user %s is still referenced by reports", username)
+		}
+	}
+
+	for id, c := range s.comments {
+		if c.Author == username {
+			delete(s.comments, id)
+		}
+	}
+	for key := range s.commentVotes {
+		if _, ok := s.comments[key.id]; !ok {
+			delete(s.commentVotes, key)
+		}
+	}
+
+	// everything else cascades
+	delete(s.users, username)
+	delete(s.bios, username)
+	delete(s.admins, username)
+	for id, key := range s.apiKeys {
+		if key.Username == username {
+			delete(s.apiKeys, id)
+		}
+	}
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+		}
+	}
+	for id, passkey := range s.passkeys {
+		if passkey.Username == username {
+			delete(s.passkeys, id)
+		}
+	}
+	for id, challenge := range s.challenges {
+		if challenge.Username == username {
+			delete(s.challenges, id)
+		}
+	}
+	delete(s.twoFactor, username)
+	for hash, challenge := range s.twoFactorChallenges {
+		if challenge.username == username {
+			delete(s.twoFactorChallenges, hash)
+		}
+	}
+	for key := range s.votes {
+		if key.username == username {
+			delete(s.votes, key)
+		}
+	}
+	for key := range s.commentVotes {
+		if key.username == username {
+			delete(s.commentVotes, key)
+		}
+	}
+
+	log.Printf("[INFO] Deleted user %s\n", username)
+	return nil
+}
+
+func (s *MemoryStore) CheckAdminStatus(ctx context.Context, user User) (bool, error) {
+	if user.Username == "" {
+		return false, newError("CheckAdminStatus", ErrInvalidInput, "unable to check admin status of user with blank username")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.admins[user.Username].role == RoleAdmin, nil
+}
+
+func (s *MemoryStore) GetRole(ctx context.Context, user User) (Role, error) {
+	if user.Username == "" {
+		return "", newError("GetRole", ErrInvalidInput, "unable to look up the role of a user with blank username")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if staff, ok := s.admins[user.Username]; ok {
+		return staff.role, nil
+	}
+	return RoleUser, nil
+}
+
+func (s *MemoryStore) CreateSubmission(ctx context.Context, submission Submission) (string, error) {
+	if submission.Username == "" || submission.Title == "" || submission.Link == "" {
+		return "", newError("CreateSubmission", ErrInvalidInput, "username, title and link are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[submission.Username]; !exists {
+		return "", newError("CreateSubmission", ErrInvalidInput, "no user %s", submission.Username)
+	}
+
+	now := s.now()
+	submission.Id = uuid.NewString()
+	submission.Created_at = formatTime(now)
+	submission.Votes = 0
+	s.submissions[submission.Id] = &memSubmission{Submission: submission, createdAt: now}
+
+	log.Printf("[INFO] New submission authored by %s with ID %s created\n", submission.Username, submission.Id)
+	return submission.Id, nil
+}
+
+func (s *MemoryStore) SearchSubmission(ctx context.Context, stub Submission) (Submission, error) {
+	if stub.Id == "" {
+		return Submission{}, newError("SearchSubmission", ErrInvalidInput, "please use an ID when searching for a submission")
+	}
+	if err := checkUUID("SearchSubmission", stub.Id); err != nil {
+		return Submission{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.submissions[stub.Id]
+	if !ok {
+		return Submission{}, newError("SearchSubmission", ErrNotFound, "no such submission")
+	}
+
+	return s.submissionWithCounts(sub), nil
+}
+
+func (s *MemoryStore) UpdateSubmission(ctx context.Context, stub Submission) error {
+	if stub.Id == "" {
+		return newError("UpdateSubmission", ErrInvalidInput, "please use an ID when updating a submission")
+	}
+	if err := checkUUID("UpdateSubmission", stub.Id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.submissions[stub.Id]
+	if !ok {
+		return newError("UpdateSubmission", ErrNotFound, "no rows affected")
+	}
+
+	sub.Link = stub.Link
+	sub.Title = stub.Title
+	sub.Body = stub.Body
+	sub.Flagged = stub.Flagged
+	return nil
+}
+
+func (s *MemoryStore) DeleteSubmission(ctx context.Context, submission Submission) error {
+	if submission.Id == "" {
+		return newError("DeleteSubmission", ErrInvalidInput, "to delete a submission, you must pass a submission ID")
+	}
+	if err := checkUUID("DeleteSubmission", submission.Id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.submissions[submission.Id]; !ok {
+		return newError("DeleteSubmission", ErrNotFound, "no rows affected")
+	}
+
+	// comments.in_response_to has no ON DELETE CASCADE
+	for _, c := range s.comments {
+		if c.InResponseTo == submission.Id {
+			return newError("DeleteSubmission", ErrInvalidInput, "submission %s still has comments", submission.Id)
+		}
+	}
+
+	delete(s.submissions, submission.Id)
+	for key := range s.votes {
+		if key.id == submission.Id {
+			delete(s.votes, key)
+		}
+	}
+
+	log.Printf("[INFO] Deleted submission %s", submission.Id)
+	return nil
+}
+
+// net score of a submission, caller must hold the lock
+func (s *MemoryStore) submissionScore(id string) int {
+	score := 0
+	for key, vote := range s.votes {
+		if key.id == id {
+			score += voteValue(vote.positive)
+		}
+	}
+	return score
+}
+
+// comments on a submission, replies included
+func (s *MemoryStore) commentCount(submissionId string) int {
+	count := 0
+	for _, c := range s.comments {
+		if c.InResponseTo == submissionId {
+			count++
+		}
+	}
+	return count
+}
+
+// direct replies to a comment
+func (s *MemoryStore) replyCount(commentId string) int {
+	count := 0
+	for _, c := range s.comments {
+		if c.ParentComment == commentId {
+			count++
+		}
+	}
+	return count
+}
+
+// fills in what Postgres keeps in the counter columns, caller must hold the lock
+//
+// nothing is cached here, so there's nothing to drift and no RepairCounters
+func (s *MemoryStore) submissionWithCounts(sub *memSubmission) Submission {
+	result := sub.Submission
+	result.Votes = s.submissionScore(sub.Id)
+	result.CommentCount = s.commentCount(sub.Id)
+	return result
+}
+
+func (s *MemoryStore) AllSubmissions(ctx context.Context, sortMethod SortMethod, page Page) ([]Submission, string, error) {
+	after, err := decodeCursor("AllSubmissions", page.Cursor, sortMethod)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// same as the SQL version, Hot ranks everything as of the first page's timestamp
+	asOf := s.now()
+	if after != nil && sortMethod == Hot {
+		if asOf, err = time.Parse(time.RFC3339Nano, after.AsOf); err != nil {
+			return nil, "", newError("AllSubmissions", ErrInvalidInput, "malformed cursor")
+		}
+	}
+
+	keys := map[string]sortKey{}
+	var all []*memSubmission
+	for _, sub := range s.submissions {
+		if sortMethod == Hot && sub.createdAt.After(asOf) {
+			continue
+		}
+
+		key := sortKey{createdAt: sub.createdAt, id: sub.Id, score: s.submissionScore(sub.Id)}
+		if sortMethod == Hot {
+			key.rank = HotRank(key.score, asOf.Sub(sub.createdAt), s.commentCount(sub.Id), sub.Flagged, s.Ranking)
+		}
+
+		keys[sub.Id] = key
+		all = append(all, sub)
+	}
+
+	// true if a sorts before b
+	var before func(a, b sortKey) bool
+	switch sortMethod {
+	case Latest:
+		before = sortKey.newerThan
+	case Oldest:
+		before = func(a, b sortKey) bool { return b.newerThan(a) }
+	case Best:
+		before = sortKey.betterThan
+	case Hot:
+		before = func(a, b sortKey) bool {
+			if a.rank != b.rank {
+				return a.rank > b.rank
+			}
+			return a.newerThan(b)
+		}
+	default:
+		return nil, "", newError("AllSubmissions", ErrInvalidInput, "unknown sort method %q", sortMethod)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return before(keys[all[i].Id], keys[all[j].Id]) })
+
+	if after != nil {
+		position, err := after.sortKey()
+		if err != nil {
+			return nil, "", newError("AllSubmissions", ErrInvalidInput, "malformed cursor")
+		}
+		all = seek(all, func(sub *memSubmission) bool { return before(position, keys[sub.Id]) })
+	}
+
+	limit, err := page.size("AllSubmissions")
+	if err != nil {
+		return nil, "", err
+	}
+	all, next := paginate(window(all, 0, limit+1), limit, func(last *memSubmission) cursor {
+		key := keys[last.Id]
+		return cursor{Sort: sortMethod, CreatedAt: last.Created_at, Id: last.Id, Score: key.score, Rank: key.rank, AsOf: formatTime(asOf)}
+	})
+
+	submissions := []Submission{}
+	for _, sub := range all {
+		submissions = append(submissions, s.submissionWithCounts(sub))
+	}
+
+	return submissions, next, nil
+}
+
+// everything a list can be ordered by, the in-memory version of the columns in an ORDER BY
+type sortKey struct {
+	rank      float64
+	score     int
+	createdAt time.Time
+	id        string
+}
+
+func (a sortKey) newerThan(b sortKey) bool {
+	return newerFirst(a.createdAt, a.id, b.createdAt, b.id)
+}
+
+// higher score first, then newest first
+func (a sortKey) betterThan(b sortKey) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.newerThan(b)
+}
+
+func (c cursor) sortKey() (sortKey, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, c.CreatedAt)
+	return sortKey{rank: c.Rank, score: c.Score, createdAt: createdAt, id: c.Id}, err
+}
+
+// drops everything up to and including the cursor position from a sorted slice
+func seek[T any](items []T, isAfter func(T) bool) []T {
+	for i, item := range items {
+		if isAfter(item) {
+			return items[i:]
+		}
+	}
+	return nil
+}
+
+// ordering helper for "newest first", ties broken by id so results are deterministic
+func newerFirst(aTime time.Time, aId string, bTime time.Time, bId string) bool {
+	if !aTime.Equal(bTime) {
+		return aTime.After(bTime)
+	}
+	return aId > bId
+}
+
+// LIMIT/OFFSET over a slice
+func window[T any](items []T, offset int, limit int) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(items) {
+		return nil
+	}
+
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func (s *MemoryStore) LatestUserSubmissions(ctx context.Context, page Page, user User) ([]BasicSubmission, string, error) {
+	after, err := decodeCursor("LatestUserSubmissions", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var mine []*memSubmission
+	for _, sub := range s.submissions {
+		if sub.Username == user.Username {
+			mine = append(mine, sub)
+		}
+	}
+
+	limit, err := page.size("LatestUserSubmissions")
+	if err != nil {
+		return nil, "", err
+	}
+
+	mine, next, err := newestFirstPage(mine, after, limit, func(sub *memSubmission) sortKey {
+		return sortKey{createdAt: sub.createdAt, id: sub.Id}
+	})
+	if err != nil {
+		return nil, "", &Error{Op: "LatestUserSubmissions", Kind: ErrInvalidInput, Err: err}
+	}
+
+	submissions := []BasicSubmission{}
+	for _, sub := range mine {
+		submissions = append(submissions, BasicSubmission{Id: sub.Id, Title: sub.Title, Link: sub.Link, Created_at: sub.Created_at})
+	}
+
+	return submissions, next, nil
+}
+
+// sorts newest first and returns the page after the cursor, for the lists that only go by time
+func newestFirstPage[T any](items []T, after *cursor, limit int, key func(T) sortKey) ([]T, string, error) {
+	sort.Slice(items, func(i, j int) bool { return key(items[i]).newerThan(key(items[j])) })
+
+	if after != nil {
+		position, err := after.sortKey()
+		if err != nil {
+			return nil, "", fmt.Errorf("malformed cursor")
+		}
+		items = seek(items, func(item T) bool { return position.newerThan(key(item)) })
+	}
+
+	items, next := paginate(window(items, 0, limit+1), limit, func(last T) cursor {
+		k := key(last)
+		return cursor{CreatedAt: formatTime(k.createdAt), Id: k.id}
+	})
+
+	return items, next, nil
+}
+
+// no index, just a scan with searchExpr standing in for tsvector matching, so no stemming
+func (s *MemoryStore) Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, string, error) {
+	after, err := decodeCursor("Search", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	expr, domain, err := query.validate("Search")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	asOf := s.now()
+	if after != nil {
+		if asOf, err = time.Parse(time.RFC3339Nano, after.AsOf); err != nil {
+			return nil, "", newError("Search", ErrInvalidInput, "malformed cursor")
+		}
+	}
+
+	inRange := func(author string, createdAt time.Time) bool {
+		return (query.Author == "" || author == query.Author) &&
+			!createdAt.After(asOf) &&
+			(query.After.IsZero() || !createdAt.Before(query.After)) &&
+			(query.Before.IsZero() || createdAt.Before(query.Before))
+	}
+
+	keys := map[string]sortKey{}
+	var results []SearchResult
+
+	if query.includes(SearchSubmissions) {
+		for _, sub := range s.submissions {
+			if sub.Flagged || !inRange(sub.Username, sub.createdAt) {
+				continue
+			}
+			if linked := normalizeDomain(sub.Link); domain != "" && linked != domain && !strings.HasSuffix(linked, "."+domain) {
+				continue
+			}
+
+			relevance, ok := expr.relevance(map[float64][]string{1.0: searchWords(sub.Title), 0.4: searchWords(sub.Body)})
+			if !ok {
+				continue
+			}
+
+			full := s.submissionWithCounts(sub)
+			keys[sub.Id] = sortKey{rank: SearchRank(relevance, full.Votes, asOf.Sub(sub.createdAt)), createdAt: sub.createdAt, id: sub.Id}
+			results = append(results, SearchResult{
+				Type:       SearchSubmissions,
+				Submission: &full,
+				Rank:       keys[sub.Id].rank,
+				Title:      expr.highlight(sub.Title, 0),
+				Snippet:    expr.highlight(sub.Body, 35),
+			})
+		}
+	}
+
+	if query.includes(SearchComments) && domain == "" {
+		for _, c := range s.comments {
+			if c.Flagged || !inRange(c.Author, c.createdAt) {
+				continue
+			}
+
+			relevance, ok := expr.relevance(map[float64][]string{1.0: searchWords(c.Content)})
+			if !ok {
+				continue
+			}
+
+			full := s.commentWithVotes(c, "")
+			keys[c.Id] = sortKey{rank: SearchRank(relevance, full.Upvotes-full.Downvotes, asOf.Sub(c.createdAt)), createdAt: c.createdAt, id: c.Id}
+			results = append(results, SearchResult{
+				Type:    SearchComments,
+				Comment: &full,
+				Rank:    keys[c.Id].rank,
+				Snippet: expr.highlight(c.Content, 35),
+			})
+		}
+	}
+
+	id := func(r SearchResult) string {
+		if r.Submission != nil {
+			return r.Submission.Id
+		}
+		return r.Comment.Id
+	}
+	before := func(a, b sortKey) bool {
+		if a.rank != b.rank {
+			return a.rank > b.rank
+		}
+		return a.newerThan(b)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return before(keys[id(results[i])], keys[id(results[j])]) })
+
+	if after != nil {
+		position, err := after.sortKey()
+		if err != nil {
+			return nil, "", newError("Search", ErrInvalidInput, "malformed cursor")
+		}
+		results = seek(results, func(r SearchResult) bool { return before(position, keys[id(r)]) })
+	}
+
+	limit, err := page.size("Search")
+	if err != nil {
+		return nil, "", err
+	}
+	results, next := paginate(window(results, 0, limit+1), limit, func(last SearchResult) cursor {
+		key := keys[id(last)]
+		return cursor{CreatedAt: formatTime(key.createdAt), Id: key.id, Rank: key.rank, AsOf: formatTime(asOf)}
+	})
+
+	if results == nil {
+		results = []SearchResult{}
+	}
+	return results, next, nil
+}
+
+func (s *MemoryStore) Vote(ctx context.Context, user User, submission Submission, isUpvote bool) (VoteResult, error) {
+	if user.Username == "" || submission.Id == "" {
+		return VoteResult{}, newError("Vote", ErrInvalidInput, "username or submission id is blank (required to vote on a submission)")
+	}
+	if err := checkUUID("Vote", submission.Id); err != nil {
+		return VoteResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.submissions[submission.Id]; !ok {
+		return VoteResult{}, newError("Vote", ErrInvalidInput, "no submission %s", submission.Id)
+	}
+	if _, ok := s.users[user.Username]; !ok {
+		return VoteResult{}, newError("Vote", ErrInvalidInput, "no user %s", user.Username)
+	}
+
+	changed := s.castVote(s.votes, voteKey{id: submission.Id, username: user.Username}, isUpvote)
+	return VoteResult{Changed: changed, Score: s.submissionScore(submission.Id)}, nil
+}
+
+func (s *MemoryStore) Unvote(ctx context.Context, user User, submission Submission) (VoteResult, error) {
+	if user.Username == "" || submission.Id == "" {
+		return VoteResult{}, newError("Unvote", ErrInvalidInput, "username or submission id is blank (required to remove a vote)")
+	}
+	if err := checkUUID("Unvote", submission.Id); err != nil {
+		return VoteResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.submissions[submission.Id]; !ok {
+		return VoteResult{}, newError("Unvote", ErrNotFound, "no such submission")
+	}
+
+	key := voteKey{id: submission.Id, username: user.Username}
+	_, changed := s.votes[key]
+	delete(s.votes, key)
+
+	return VoteResult{Changed: changed, Score: s.submissionScore(submission.Id)}, nil
+}
+
+// insert or flip, false for a repeat of the same vote, caller must hold the lock
+func (s *MemoryStore) castVote(votes map[voteKey]*memVote, key voteKey, isUpvote bool) bool {
+	existing, ok := votes[key]
+	if !ok {
+		votes[key] = &memVote{positive: isUpvote, ts: s.now()}
+		return true
+	}
+
+	// "can't vote twice"
+	if existing.positive == isUpvote {
+		return false
+	}
+
+	existing.positive = isUpvote
+	return true
+}
+
+func (s *MemoryStore) GetUserVote(ctx context.Context, user User, submission Submission) (bool, bool, error) {
+	if user.Username == "" {
+		return false, false, newError("GetUserVote", ErrInvalidInput, "missing username")
+	}
+	if submission.Id == "" {
+		return false, false, newError("GetUserVote", ErrInvalidInput, "missing submission ID")
+	}
+	if err := checkUUID("GetUserVote", submission.Id); err != nil {
+		return false, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vote, ok := s.votes[voteKey{id: submission.Id, username: user.Username}]
+	if !ok {
+		return false, false, nil
+	}
+
+	return true, vote.positive, nil
+}
+
+func (s *MemoryStore) GetAllUserVotes(ctx context.Context, page Page, user User) ([]BasicSubmissionAndVote, string, error) {
+	if user.Username == "" {
+		return nil, "", newError("GetAllUserVotes", ErrInvalidInput, "user's username cannot be blank")
+	}
+
+	after, err := decodeCursor("GetAllUserVotes", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("GetAllUserVotes")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var voted []*memSubmission
+	for key := range s.votes {
+		if key.username == user.Username {
+			voted = append(voted, s.submissions[key.id])
+		}
+	}
+
+	voted, next, err := newestFirstPage(voted, after, limit, func(sub *memSubmission) sortKey {
+		return sortKey{createdAt: sub.createdAt, id: sub.Id}
+	})
+	if err != nil {
+		return nil, "", &Error{Op: "GetAllUserVotes", Kind: ErrInvalidInput, Err: err}
+	}
+
+	submissions := []BasicSubmissionAndVote{}
+	for _, sub := range voted {
+		submissions = append(submissions, BasicSubmissionAndVote{
+			Title:      sub.Title,
+			Link:       sub.Link,
+			Body:       sub.Body,
+			Created_at: sub.Created_at,
+			Username:   sub.Username,
+			Id:         sub.Id,
+			IsUpvoted:  s.votes[voteKey{id: sub.Id, username: user.Username}].positive,
+		})
+	}
+
+	return submissions, next, nil
+}
+
+func (s *MemoryStore) CountVotes(ctx context.Context, post Submission) (VoteMetrics, error) {
+	if post.Id == "" {
+		return VoteMetrics{}, newError("CountVotes", ErrInvalidInput, "cannot query votes with a blank submission ID")
+	}
+	if err := checkUUID("CountVotes", post.Id); err != nil {
+		return VoteMetrics{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.submissions[post.Id]; !ok {
+		return VoteMetrics{}, newError("CountVotes", ErrNotFound, "no such submission")
+	}
+
+	var metrics VoteMetrics
+	for key, vote := range s.votes {
+		if key.id != post.Id {
+			continue
+		}
+		if vote.positive {
+			metrics.Upvotes++
+		} else {
+			metrics.Downvotes++
+		}
+	}
+
+	return metrics, nil
+}
+
+func (s *MemoryStore) VoteOnComment(ctx context.Context, user User, comment Comment, isUpvote bool) (VoteResult, error) {
+	if user.Username == "" || comment.Id == "" {
+		return VoteResult{}, newError("VoteOnComment", ErrInvalidInput, "username or comment id is blank (required to vote on a comment)")
+	}
+	if err := checkUUID("VoteOnComment", comment.Id); err != nil {
+		return VoteResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.comments[comment.Id]; !ok {
+		return VoteResult{}, newError("VoteOnComment", ErrInvalidInput, "no comment %s", comment.Id)
+	}
+	if _, ok := s.users[user.Username]; !ok {
+		return VoteResult{}, newError("VoteOnComment", ErrInvalidInput, "no user %s", user.Username)
+	}
+
+	changed := s.castVote(s.commentVotes, voteKey{id: comment.Id, username: user.Username}, isUpvote)
+	return VoteResult{Changed: changed, Score: s.commentScore(comment.Id)}, nil
+}
+
+func (s *MemoryStore) UnvoteComment(ctx context.Context, user User, comment Comment) (VoteResult, error) {
+	if user.Username == "" || comment.Id == "" {
+		return VoteResult{}, newError("UnvoteComment", ErrInvalidInput, "username or comment id is blank (required to remove a vote)")
+	}
+	if err := checkUUID("UnvoteComment", comment.Id); err != nil {
+		return VoteResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.comments[comment.Id]; !ok {
+		return VoteResult{}, newError("UnvoteComment", ErrNotFound, "no such comment")
+	}
+
+	key := voteKey{id: comment.Id, username: user.Username}
+	_, changed := s.commentVotes[key]
+	delete(s.commentVotes, key)
+
+	return VoteResult{Changed: changed, Score: s.commentScore(comment.Id)}, nil
+}
+
+func (s *MemoryStore) commentScore(id string) int {
+	score := 0
+	for key, vote := range s.commentVotes {
+		if key.id == id {
+			score += voteValue(vote.positive)
+		}
+	}
+	return score
+}
+
+func (s *MemoryStore) InsertNewComment(ctx context.Context, comment Comment) (string, error) {
+	if comment.InResponseTo == "" || comment.Author == "" || comment.Content == "" {
+		return "", newError("InsertNewComment", ErrInvalidInput, "attempted to insert new comment without one or more of the following: InResponseTo, Author, Content")
+	}
+	if err := checkUUID("InsertNewComment", comment.InResponseTo); err != nil {
+		return "", err
+	}
+	if comment.ParentComment != "" {
+		if err := checkUUID("InsertNewComment", comment.ParentComment); err != nil {
+			return "", err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.submissions[comment.InResponseTo]; !ok {
+		return "", newError("InsertNewComment", ErrInvalidInput, "no submission %s", comment.InResponseTo)
+	}
+	if _, ok := s.users[comment.Author]; !ok {
+		return "", newError("InsertNewComment", ErrInvalidInput, "no user %s", comment.Author)
+	}
+	if comment.ParentComment != "" {
+		if _, ok := s.comments[comment.ParentComment]; !ok {
+			return "", newError("InsertNewComment", ErrInvalidInput, "no parent comment %s", comment.ParentComment)
+		}
+	}
+
+	now := s.now()
+	comment.Id = uuid.NewString()
+	comment.Flagged = false
+	comment.CreatedAt = formatTime(now)
+	comment.Upvotes, comment.Downvotes = 0, 0
+	comment.HasUpvoted, comment.HasDownvoted = false, false
+	s.comments[comment.Id] = &memComment{Comment: comment, createdAt: now}
+
+	return comment.Id, nil
+}
+
+func (s *MemoryStore) GetCommentsOnSubmission(ctx context.Context, submission Submission, contextUser User) ([]Comment, error) {
+	if submission.Id == "" {
+		return nil, newError("GetCommentsOnSubmission", ErrInvalidInput, "please use an ID when searching for a submission's comments")
+	}
+	if err := checkUUID("GetCommentsOnSubmission", submission.Id); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matching []*memComment
+	for _, c := range s.comments {
+		if c.InResponseTo == submission.Id {
+			matching = append(matching, c)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return newerFirst(matching[i].createdAt, matching[i].Id, matching[j].createdAt, matching[j].Id)
+	})
+
+	var commentHolder []Comment
+	for _, c := range matching {
+		commentHolder = append(commentHolder, s.commentWithVotes(c, contextUser.Username))
+	}
+
+	return commentHolder, nil
+}
+
+func (s *MemoryStore) CommentTree(ctx context.Context, submission Submission, contextUser User, opts TreeOptions) ([]CommentNode, string, error) {
+	if submission.Id == "" {
+		return nil, "", newError("CommentTree", ErrInvalidInput, "please use an ID when searching for a submission's comments")
+	}
+	if err := checkUUID("CommentTree", submission.Id); err != nil {
+		return nil, "", err
+	}
+
+	limit, err := opts.validate("CommentTree")
+	if err != nil {
+		return nil, "", err
+	}
+
+	from, err := decodeThreadCursor("CommentTree", opts.Page.Cursor, submission.Id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var position sortKey
+	if from.Id != "" {
+		createdAt, err := time.Parse(time.RFC3339Nano, from.CreatedAt)
+		if err != nil {
+			return nil, "", newError("CommentTree", ErrInvalidInput, "malformed cursor")
+		}
+		position = sortKey{score: from.Score, createdAt: createdAt, id: from.Id}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := map[string]sortKey{}
+	var matching []*memComment
+	for _, c := range s.comments {
+		if c.InResponseTo != submission.Id {
+			continue
+		}
+
+		key := sortKey{score: s.commentScore(c.Id), createdAt: c.createdAt, id: c.Id}
+		// only the level the cursor is for starts part way through, buildTree ignores anything it can't reach
+		if from.Id != "" && c.ParentComment == from.Parent && !position.betterThan(key) {
+			continue
+		}
+
+		keys[c.Id] = key
+		matching = append(matching, c)
+	}
+	sort.Slice(matching, func(i, j int) bool { return keys[matching[i].Id].betterThan(keys[matching[j].Id]) })
+
+	var comments []Comment
+	for _, c := range matching {
+		comments = append(comments, s.commentWithVotes(c, contextUser.Username))
+	}
+
+	tree, more := buildTree(comments, from, limit, opts.MaxDepth)
+	return tree, more, nil
+}
+
+// fills in the vote counts + whether viewer has voted, caller must hold the lock
+func (s *MemoryStore) commentWithVotes(c *memComment, viewer string) Comment {
+	result := c.Comment
+	result.Upvotes, result.Downvotes = 0, 0
+	result.HasUpvoted, result.HasDownvoted = false, false
+	result.ReplyCount = s.replyCount(c.Id)
+
+	for key, vote := range s.commentVotes {
+		if key.id != c.Id {
+			continue
+		}
+		if vote.positive {
+			result.Upvotes++
+		} else {
+			result.Downvotes++
+		}
+		if key.username == viewer {
+			result.HasUpvoted = vote.positive
+			result.HasDownvoted = !vote.positive
+		}
+	}
+
+	return result
+}
+
+func (s *MemoryStore) SearchComment(ctx context.Context, comment Comment) (Comment, error) {
+	if comment.Id == "" {
+		return Comment{}, newError("SearchComment", ErrInvalidInput, "please use an ID when searching for a comment")
+	}
+	if err := checkUUID("SearchComment", comment.Id); err != nil {
+		return Comment{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.comments[comment.Id]
+	if !ok {
+		return Comment{}, newError("SearchComment", ErrNotFound, "no such comment")
+	}
+
+	result := c.Comment
+	result.Upvotes, result.Downvotes = 0, 0
+	return result, nil
+}
+
+func (s *MemoryStore) DeleteComment(ctx context.Context, comment Comment) error {
+	if comment.Id == "" {
+		return newError("DeleteComment", ErrInvalidInput, "please provide a comment ID to delete a comment")
+	}
+	if err := checkUUID("DeleteComment", comment.Id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.comments[comment.Id]; !ok {
+		return newError("DeleteComment", ErrNotFound, "no rows affected")
+	}
+
+	// parent_comment has no ON DELETE CASCADE
+	for _, c := range s.comments {
+		if c.ParentComment == comment.Id {
+			return newError("DeleteComment", ErrInvalidInput, "comment %s still has replies", comment.Id)
+		}
+	}
+
+	delete(s.comments, comment.Id)
+	for key := range s.commentVotes {
+		if key.id == comment.Id {
+			delete(s.commentVotes, key)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) LatestUserComments(ctx context.Context, page Page, user User) ([]Comment, string, error) {
+	after, err := decodeCursor("LatestUserComments", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var mine []*memComment
+	for _, c := range s.comments {
+		if c.Author == user.Username {
+			mine = append(mine, c)
+		}
+	}
+
+	limit, err := page.size("LatestUserComments")
+	if err != nil {
+		return nil, "", err
+	}
+
+	mine, next, err := newestFirstPage(mine, after, limit, func(c *memComment) sortKey {
+		return sortKey{createdAt: c.createdAt, id: c.Id}
+	})
+	if err != nil {
+		return nil, "", &Error{Op: "LatestUserComments", Kind: ErrInvalidInput, Err: err}
+	}
+
+	comments := []Comment{}
+	for _, c := range mine {
+		current := c.Comment
+		current.Upvotes, current.Downvotes = 0, 0
+		comments = append(comments, current)
+	}
+
+	return comments, next, nil
+}
+
+// shared by ReportSubmission and ReportComment, caller must hold the lock
+func (s *MemoryStore) report(op string, user User, targetType string, targetId string, targetUser string) (float64, error) {
+	reporter, ok := s.users[user.Username]
+	if !ok {
+		return 0.0, newError(op, ErrNotFound, "no user %s", user.Username)
+	}
+
+	for _, r := range s.reports {
+		if r.Reporter == user.Username && r.Target_id == targetId {
+			return 0.0, newError(op, ErrConflict, "unable to flag, user has already flagged")
+		}
+	}
+
+	now := s.now()
+	days := int(now.Sub(reporter.createdAt).Hours() / 24)
+
+	s.nextReportId++
+	s.reports = append(s.reports, &memReport{
+		Report: Report{
+			Id:            strconv.Itoa(s.nextReportId),
+			Reporter:      user.Username,
+			Target_type:   targetType,
+			Target_id:     targetId,
+			Target_user:   targetUser,
+			Target_weight: reportWeightForAge(days),
+			Created_at:    formatTime(now),
+		},
+		createdAt: now,
+	})
+
+	total := 0.0
+	for _, r := range s.reports {
+		if r.Target_id == targetId {
+			total += r.Target_weight
+		}
+	}
+
+	return total, nil
+}
+
+func (s *MemoryStore) ReportSubmission(ctx context.Context, user User, submission Submission) (float64, bool, error) {
+	if submission.Id == "" {
+		return 0.0, false, newError("ReportSubmission", ErrInvalidInput, "please use an ID when reporting a submission")
+	}
+	if err := checkUUID("ReportSubmission", submission.Id); err != nil {
+		return 0.0, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.submissions[submission.Id]
+	if !ok {
+		return 0.0, false, newError("ReportSubmission", ErrNotFound, "no such submission")
+	}
+
+	if sub.Flagged {
+		return 0.0, false, newError("ReportSubmission", ErrConflict, "submission %s is already flagged, cannot report", submission.Id)
+	}
+
+	totalWeight, err := s.report("ReportSubmission", user, "submission", sub.Id, sub.Username)
+	if err != nil {
+		return 0.0, false, err
+	}
+
+	if totalWeight >= 1.0 {
+		sub.Flagged = true
+		return totalWeight, true, nil
+	}
+
+	return totalWeight, false, nil
+}
+
+func (s *MemoryStore) ReportComment(ctx context.Context, comment Comment, user User) (float64, bool, error) {
+	if comment.Id == "" {
+		return 0.0, false, newError("ReportComment", ErrInvalidInput, "please use an ID when reporting a comment")
+	}
+	if err := checkUUID("ReportComment", comment.Id); err != nil {
+		return 0.0, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[comment.Id]
+	if !ok {
+		return 0.0, false, newError("ReportComment", ErrNotFound, "no such comment")
+	}
+
+	if c.Flagged {
+		return 0.0, false, newError("ReportComment", ErrConflict, "comment %s is already flagged; cannot report", comment.Id)
+	}
+
+	totalWeight, err := s.report("ReportComment", user, "comment", c.Id, c.Author)
+	if err != nil {
+		return 0.0, false, err
+	}
+
+	if totalWeight >= 1.0 {
+		c.Flagged = true
+		return totalWeight, true, nil
+	}
+
+	return totalWeight, false, nil
+}
+
+func (s *MemoryStore) GetReputation(ctx context.Context, user User) (Reputation, error) {
+	if user.Username == "" {
+		return Reputation{}, newError("GetReputation", ErrInvalidInput, "username cannot be blank when checking reputation")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.users[user.Username]
+	if !ok {
+		return Reputation{}, newError("GetReputation", ErrNotFound, "no user %s", user.Username)
+	}
+
+	reputation := Reputation{
+		AgeDays: int(s.now().Sub(found.createdAt).Hours() / 24),
+		Karma:   s.userScore(user.Username),
+	}
+	for _, r := range s.reports {
+		if r.Target_user == user.Username {
+			reputation.ReportWeight += r.Target_weight
+		}
+	}
+	for _, sub := range s.submissions {
+		if sub.Username == user.Username && sub.Flagged {
+			reputation.Flagged++
+		}
+	}
+	for _, c := range s.comments {
+		if c.Author == user.Username && c.Flagged {
+			reputation.Flagged++
+		}
+	}
+
+	return reputation, nil
+}
+
+func (s *MemoryStore) SelectAllReportsFromUser(ctx context.Context, page Page, user User) ([]Report, string, error) {
+	if user.Username == "" {
+		return nil, "", newError("SelectAllReportsFromUser", ErrInvalidInput, "username cannot be blank when selecting reports from user")
+	}
+
+	after, err := decodeCursor("SelectAllReportsFromUser", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var mine []*memReport
+	for _, r := range s.reports {
+		if r.Reporter == user.Username {
+			mine = append(mine, r)
+		}
+	}
+
+	// report ids are a serial, zero pad them so they compare as strings the way Postgres compares the numbers
+	limit, err := page.size("SelectAllReportsFromUser")
+	if err != nil {
+		return nil, "", err
+	}
+
+	mine, next, err := newestFirstPage(mine, after, limit, func(r *memReport) sortKey {
+		n, _ := strconv.Atoi(r.Id)
+		return sortKey{createdAt: r.createdAt, id: fmt.Sprintf("%020d", n)}
+	})
+	if err != nil {
+		return nil, "", &Error{Op: "SelectAllReportsFromUser", Kind: ErrInvalidInput, Err: err}
+	}
+
+	reports := []Report{}
+	for _, r := range mine {
+		reports = append(reports, r.Report)
+	}
+
+	return reports, next, nil
+}
+
+func (s *MemoryStore) CreateMagicLink(ctx context.Context, user User, expiresAt time.Time) (string, error) {
+	if user.Username == "" || user.Email == "" {
+		return "", newError("CreateMagicLink", ErrInvalidInput, "to create a magic link, user must have a username and email")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// first, delete all old magic links for given user
+	for hash, link := range s.magicLinks {
+		if link.username == user.Username {
+			delete(s.magicLinks, hash)
+		}
+	}
+
+	token := SecureToken(100)
+	s.magicLinks[hashToken(token)] = memMagicLink{username: user.Username, email: user.Email, expiresAt: expiresAt}
+	return token, nil
+}
+
+func (s *MemoryStore) DeleteMagicLink(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.magicLinks, hashToken(token))
+	return nil
+}
+
+func (s *MemoryStore) ValidateMagicLink(ctx context.Context, token string, ip string) (User, error) {
+	if token == "" {
+		return User{}, newError("ValidateMagicLink", ErrInvalidInput, "you must pass in a token to validate")
+	}
+	if ip == "" {
+		return User{}, newError("ValidateMagicLink", ErrInvalidInput, "registration IP address required")
+	}
+
+	s.mu.Lock()
+	link, ok := s.magicLinks[hashToken(token)]
+	if ok {
+		delete(s.magicLinks, hashToken(token))
+	}
+	now := s.now()
+	s.mu.Unlock()
+
+	if !ok {
+		return User{}, newError("ValidateMagicLink", ErrNotFound, "no magic link for token")
+	}
+	if !link.expiresAt.After(now) {
+		return User{}, newError("ValidateMagicLink", ErrNotFound, "magic link has expired")
+	}
+
+	searched, err := s.SearchUser(ctx, User{Username: link.username})
+	if err == nil {
+		if !strings.EqualFold(searched.User.Email, link.email) {
+			return User{}, newError("ValidateMagicLink", ErrConflict, "magic link was sent to a different address than the account's")
+		}
+		return searched.User, nil
+	}
+
+	toInsert := User{Username: link.username, Email: link.email, Registered_ip: ip}
+	if err := s.CreateUser(ctx, toInsert); err != nil {
+		return User{}, wrapError("ValidateMagicLink", err)
+	}
+
+	return toInsert, nil
+}
+
+func (s *MemoryStore) PurgeExpiredMagicLinks(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for hash, link := range s.magicLinks {
+		if !link.expiresAt.After(s.now()) {
+			delete(s.magicLinks, hash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, user User, opts APIKeyOptions) (APIKey, string, error) {
+	if user.Username == "" {
+		return APIKey{}, "", newError("CreateAPIKey", ErrInvalidInput, "cannot create API key for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := opts.validate("CreateAPIKey", s.now()); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, ok := s.users[user.Username]; !ok {
+		return APIKey{}, "", newError("CreateAPIKey", ErrNotFound, "no such user")
+	}
+
+	if len(s.activeAPIKeys(user.Username)) >= maxAPIKeys {
+		return APIKey{}, "", newError("CreateAPIKey", ErrConflict, "user %s already has %d API keys, revoke one first", user.Username, maxAPIKeys)
+	}
+
+	key, token := s.insertAPIKey(user.Username, opts)
+	return key.public(), token, nil
+}
+
+// caller holds the lock
+func (s *MemoryStore) insertAPIKey(username string, opts APIKeyOptions) (*memAPIKey, string) {
+	token, prefix, hash := newAPIKey()
+	key := &memAPIKey{
+		APIKey:    APIKey{Id: uuid.NewString(), Username: username, Name: opts.Name, Prefix: prefix, Scopes: append([]Scope(nil), opts.Scopes...)},
+		hash:      hash,
+		createdAt: s.now(),
+		expiresAt: opts.ExpiresAt,
+	}
+	s.apiKeys[key.Id] = key
+	return key, token
+}
+
+// caller holds the lock, newest first like the Postgres index
+func (s *MemoryStore) activeAPIKeys(username string) []*memAPIKey {
+	var keys []*memAPIKey
+	for _, key := range s.apiKeys {
+		if key.Username == username && key.revokedAt.IsZero() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].createdAt.Equal(keys[j].createdAt) {
+			return keys[i].createdAt.After(keys[j].createdAt)
+		}
+		return keys[i].Id > keys[j].Id
+	})
+	return keys
+}
+
+// ErrNotFound for unknown, revoked and expired keys alike
+func (s *MemoryStore) ValidateAPIKey(ctx context.Context, token string) (APIKey, error) {
+	if token == "" {
+		return APIKey{}, newError("ValidateAPIKey", ErrInvalidInput, "cannot validate blank API key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(token)
+	now := s.now()
+	for _, key := range s.apiKeys {
+		if key.hash == hash && key.revokedAt.IsZero() && (key.expiresAt.IsZero() || key.expiresAt.After(now)) {
+			key.lastUsed = now
+			return key.public(), nil
+		}
+	}
+
+	return APIKey{}, newError("ValidateAPIKey", ErrNotFound, "no such API key")
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context, user User) ([]APIKey, error) {
+	if user.Username == "" {
+		return nil, newError("ListAPIKeys", ErrInvalidInput, "cannot list API keys of a blank user")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, key := range s.activeAPIKeys(user.Username) {
+		keys = append(keys, key.public())
+	}
+	return keys, nil
+}
+
+// caller holds the lock, ErrNotFound unless the key is the user's and not already revoked
+func (s *MemoryStore) ownedAPIKey(op string, user User, id string) (*memAPIKey, error) {
+	if user.Username == "" {
+		return nil, newError(op, ErrInvalidInput, "blank username")
+	}
+	if err := checkUUID(op, id); err != nil {
+		return nil, err
+	}
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.Username != user.Username || !key.revokedAt.IsZero() {
+		return nil, newError(op, ErrNotFound, "no such API key")
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) RotateAPIKey(ctx context.Context, user User, id string) (APIKey, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.ownedAPIKey("RotateAPIKey", user, id)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if !old.expiresAt.IsZero() && !old.expiresAt.After(s.now()) {
+		return APIKey{}, "", newError("RotateAPIKey", ErrNotFound, "no such API key")
+	}
+
+	old.revokedAt = s.now()
+	key, token := s.insertAPIKey(user.Username, APIKeyOptions{Name: old.Name, Scopes: old.Scopes, ExpiresAt: old.expiresAt})
+	return key.public(), token, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, user User, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.ownedAPIKey("RevokeAPIKey", user, id)
+	if err != nil {
+		return err
+	}
+
+	key.revokedAt = s.now()
+	return nil
+}
+func (s *MemoryStore) CreateSession(ctx context.Context, user User, ip string, userAgent string, expiresAt time.Time) (Session, string, error) {
+	if user.Username == "" || ip == "" {
+		return Session{}, "", newError("CreateSession", ErrInvalidInput, "username and IP are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Username]; !ok {
+		return Session{}, "", newError("CreateSession", ErrNotFound, "no such user")
+	}
+
+	token, hash := newRefreshToken()
+	now := s.now()
+	session := &memSession{
+		Session:   Session{Id: uuid.NewString(), Username: user.Username, Ip: ip, UserAgent: userAgent},
+		hash:      hash,
+		createdAt: now,
+		lastSeen:  now,
+		expiresAt: expiresAt,
+	}
+	s.sessions[session.Id] = session
+	return session.public(), token, nil
+}
+
+// caller holds the lock
+func (s *MemoryStore) liveSession(session *memSession) bool {
+	return session.revokedAt.IsZero() && session.expiresAt.After(s.now())
+}
+
+// swaps the refresh token for a new one, ErrNotFound for unknown, expired and revoked tokens
+// a token that was already swapped out means it was copied, so the session is revoked
+func (s *MemoryStore) RefreshSession(ctx context.Context, refreshToken string, ip string, userAgent string, expiresAt time.Time) (Session, string, error) {
+	if refreshToken == "" {
+		return Session{}, "", newError("RefreshSession", ErrInvalidInput, "cannot refresh with a blank token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(refreshToken)
+	for _, session := range s.sessions {
+		if session.previousHash == hash && session.revokedAt.IsZero() {
+			session.revokedAt = s.now()
+			return Session{}, "", newError("RefreshSession", ErrNotFound, "refresh token was already used, session %s revoked", session.Id)
+		}
+		if session.hash != hash || !s.liveSession(session) {
+			continue
+		}
+
+		token, newHash := newRefreshToken()
+		session.previousHash, session.hash = session.hash, newHash
+		session.Ip, session.UserAgent = ip, userAgent
+		session.lastSeen = s.now()
+		session.expiresAt = expiresAt
+		return session.public(), token, nil
+	}
+
+	return Session{}, "", newError("RefreshSession", ErrNotFound, "no such session")
+}
+
+// ErrNotFound unless the session is live, otherwise last_seen is bumped to now
+func (s *MemoryStore) TouchSession(ctx context.Context, id string, ip string) (Session, error) {
+	if err := checkUUID("TouchSession", id); err != nil {
+		return Session{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || !s.liveSession(session) {
+		return Session{}, newError("TouchSession", ErrNotFound, "no such session")
+	}
+
+	session.lastSeen = s.now()
+	if ip != "" {
+		session.Ip = ip
+	}
+	return session.public(), nil
+}
+
+// live sessions, most recently seen first
+func (s *MemoryStore) ListSessions(ctx context.Context, user User) ([]Session, error) {
+	if user.Username == "" {
+		return nil, newError("ListSessions", ErrInvalidInput, "cannot list sessions of a blank user")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var live []*memSession
+	for _, session := range s.sessions {
+		if session.Username == user.Username && s.liveSession(session) {
+			live = append(live, session)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if !live[i].lastSeen.Equal(live[j].lastSeen) {
+			return live[i].lastSeen.After(live[j].lastSeen)
+		}
+		return live[i].Id > live[j].Id
+	})
+
+	sessions := []Session{}
+	for _, session := range live {
+		sessions = append(sessions, session.public())
+	}
+	return sessions, nil
+}
+
+// ErrNotFound unless the session is the user's and still live
+func (s *MemoryStore) RevokeSession(ctx context.Context, user User, id string) error {
+	if user.Username == "" {
+		return newError("RevokeSession", ErrInvalidInput, "blank username")
+	}
+	if err := checkUUID("RevokeSession", id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Username != user.Username || !s.liveSession(session) {
+		return newError("RevokeSession", ErrNotFound, "no such session")
+	}
+
+	session.revokedAt = s.now()
+	return nil
+}
+
+// every live session the user has, returns how many there were
+func (s *MemoryStore) RevokeAllSessions(ctx context.Context, user User) (int, error) {
+	if user.Username == "" {
+		return 0, newError("RevokeAllSessions", ErrInvalidInput, "blank username")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for _, session := range s.sessions {
+		if session.Username == user.Username && s.liveSession(session) {
+			session.revokedAt = s.now()
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStore) GetAdminMetrics(ctx context.Context) (AdminMetrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+
+	// posts per day over the last week, same windows as days_between() in the SQL version
+	var perDay [7]int
+	for _, sub := range s.submissions {
+		for day := 0; day < 7; day++ {
+			from := now.Add(-time.Duration(day+1) * 24 * time.Hour)
+			to := now.Add(-time.Duration(day) * 24 * time.Hour)
+			if !sub.createdAt.Before(from) && !sub.createdAt.After(to) {
+				perDay[day]++
+			}
+		}
+	}
+
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+	active := map[string]bool{}
+	for _, sub := range s.submissions {
+		if sub.createdAt.After(weekAgo) {
+			active[sub.Username] = true
+		}
+	}
+	for key, vote := range s.votes {
+		if vote.ts.After(weekAgo) {
+			active[key.username] = true
+		}
+	}
+
+	return AdminMetrics{
+		TodayPosts:              perDay[0],
+		TodayMinusOnePosts:      perDay[1],
+		TodayMinusTwoPosts:      perDay[2],
+		TodayMinusThreePosts:    perDay[3],
+		TodayMinusFourPosts:     perDay[4],
+		TodayMinusFivePosts:     perDay[5],
+		TodayMinusSixPosts:      perDay[6],
+		TotalAllTimeSubmissions: len(s.submissions),
+		TotalAllTimeUsers:       len(s.users),
+		TotalActiveUsers:        len(active),
+	}, nil
+}
+
+func (s *MemoryStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	if err := checkAuditEvent("RecordAudit", entry.Event); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.nextAuditId++
+	entry.Id = strconv.Itoa(s.nextAuditId)
+	entry.Timestamp = formatTime(now)
+	s.audit = append(s.audit, &memAudit{AuditEntry: entry, createdAt: now})
+
+	return nil
+}
+
+func (s *MemoryStore) AuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, string, error) {
+	if err := filter.validate("AuditLog"); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodeCursor("AuditLog", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matching []*memAudit
+	for _, e := range s.audit {
+		if (filter.Username == "" || e.Username == filter.Username) &&
+			(filter.Event == "" || e.Event == filter.Event) &&
+			(filter.After.IsZero() || !e.createdAt.Before(filter.After)) &&
+			(filter.Before.IsZero() || e.createdAt.Before(filter.Before)) {
+			matching = append(matching, e)
+		}
+	}
+
+	limit, err := page.size("AuditLog")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// zero padded like the report ids
+	matching, next, err := newestFirstPage(matching, after, limit, func(e *memAudit) sortKey {
+		n, _ := strconv.Atoi(e.Id)
+		return sortKey{createdAt: e.createdAt, id: fmt.Sprintf("%020d", n)}
+	})
+	if err != nil {
+		return nil, "", &Error{Op: "AuditLog", Kind: ErrInvalidInput, Err: err}
+	}
+
+	entries := []AuditEntry{}
+	for _, e := range matching {
+		entries = append(entries, e.AuditEntry)
+	}
+
+	return entries, next, nil
+}
+
+func (s *MemoryStore) PasskeyHandle(ctx context.Context, user User) ([]byte, error) {
+	if user.Username == "" {
+		return nil, newError("PasskeyHandle", ErrInvalidInput, "cannot get the passkey handle of a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := s.users[user.Username]
+	if found == nil {
+		return nil, newError("PasskeyHandle", ErrNotFound, "no user %s", user.Username)
+	}
+	if found.passkeyHandle == nil {
+		found.passkeyHandle = newPasskeyHandle()
+	}
+
+	return append([]byte(nil), found.passkeyHandle...), nil
+}
+
+func (s *MemoryStore) PasskeyOwner(ctx context.Context, handle []byte) (string, error) {
+	if len(handle) == 0 {
+		return "", newError("PasskeyOwner", ErrInvalidInput, "blank passkey handle")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.passkeyHandle != nil && bytes.Equal(u.passkeyHandle, handle) {
+			return u.Username, nil
+		}
+	}
+
+	return "", newError("PasskeyOwner", ErrNotFound, "no user has that passkey handle")
+}
+
+func (s *MemoryStore) CreatePasskey(ctx context.Context, user User, name string, credentialId []byte, credential []byte) (Passkey, error) {
+	if user.Username == "" || len(credentialId) == 0 || len(credential) == 0 {
+		return Passkey{}, newError("CreatePasskey", ErrInvalidInput, "username, credential id and credential are required")
+	}
+	if !validPasskeyName(name) {
+		return Passkey{}, newError("CreatePasskey", ErrInvalidInput, "passkey name must be between 1 and 100 characters")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users[user.Username] == nil {
+		return Passkey{}, newError("CreatePasskey", ErrNotFound, "no user %s", user.Username)
+	}
+
+	count := 0
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialId, credentialId) {
+			return Passkey{}, newError("CreatePasskey", ErrConflict, "passkey is already registered")
+		}
+		if p.Username == user.Username {
+			count++
+		}
+	}
+	if count >= maxPasskeys {
+		return Passkey{}, newError("CreatePasskey", ErrConflict, "user %s already has %d passkeys, remove one first", user.Username, maxPasskeys)
+	}
+
+	passkey := &memPasskey{
+		Passkey: Passkey{
+			Id:           uuid.NewString(),
+			Username:     user.Username,
+			Name:         name,
+			CredentialId: append([]byte(nil), credentialId...),
+			Credential:   append([]byte(nil), credential...),
+		},
+		createdAt: s.now(),
+	}
+	s.passkeys[passkey.Id] = passkey
+
+	return passkey.public(), nil
+}
+
+func (s *MemoryStore) UsePasskey(ctx context.Context, credentialId []byte, credential []byte) (Passkey, error) {
+	if len(credentialId) == 0 || len(credential) == 0 {
+		return Passkey{}, newError("UsePasskey", ErrInvalidInput, "credential id and credential are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialId, credentialId) {
+			p.Credential = append([]byte(nil), credential...)
+			p.lastUsed = s.now()
+			return p.public(), nil
+		}
+	}
+
+	return Passkey{}, newError("UsePasskey", ErrNotFound, "no such passkey")
+}
+
+func (s *MemoryStore) ListPasskeys(ctx context.Context, user User) ([]Passkey, error) {
+	if user.Username == "" {
+		return nil, newError("ListPasskeys", ErrInvalidInput, "cannot list passkeys of a blank user")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var mine []*memPasskey
+	for _, p := range s.passkeys {
+		if p.Username == user.Username {
+			mine = append(mine, p)
+		}
+	}
+	sort.Slice(mine, func(i, j int) bool {
+		if !mine[i].createdAt.Equal(mine[j].createdAt) {
+			return mine[i].createdAt.Before(mine[j].createdAt)
+		}
+		return mine[i].Id < mine[j].Id
+	})
+
+	passkeys := []Passkey{}
+	for _, p := range mine {
+		passkeys = append(passkeys, p.public())
+	}
+	return passkeys, nil
+}
+
+func (s *MemoryStore) DeletePasskey(ctx context.Context, user User, id string) error {
+	if user.Username == "" || id == "" {
+		return newError("DeletePasskey", ErrInvalidInput, "username and passkey id are required")
+	}
+	if err := checkUUID("DeletePasskey", id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	passkey, ok := s.passkeys[id]
+	if !ok || passkey.Username != user.Username {
+		return newError("DeletePasskey", ErrNotFound, "no rows affected")
+	}
+
+	delete(s.passkeys, id)
+	return nil
+}
+
+func (s *MemoryStore) CreatePasskeyChallenge(ctx context.Context, username string, data []byte, expiresAt time.Time) (string, error) {
+	if len(data) == 0 {
+		return "", newError("CreatePasskeyChallenge", ErrInvalidInput, "challenge data is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if username != "" && s.users[username] == nil {
+		return "", newError("CreatePasskeyChallenge", ErrInvalidInput, "no user %s", username)
+	}
+
+	challenge := &memChallenge{
+		PasskeyChallenge: PasskeyChallenge{Id: uuid.NewString(), Username: username, Data: append([]byte(nil), data...)},
+		expiresAt:        expiresAt,
+	}
+	s.challenges[challenge.Id] = challenge
+
+	return challenge.Id, nil
+}
+
+func (s *MemoryStore) TakePasskeyChallenge(ctx context.Context, id string) (PasskeyChallenge, error) {
+	if id == "" {
+		return PasskeyChallenge{}, newError("TakePasskeyChallenge", ErrInvalidInput, "blank challenge id")
+	}
+	if err := checkUUID("TakePasskeyChallenge", id); err != nil {
+		return PasskeyChallenge{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[id]
+	if !ok {
+		return PasskeyChallenge{}, newError("TakePasskeyChallenge", ErrNotFound, "no such passkey challenge")
+	}
+	delete(s.challenges, id)
+
+	if !challenge.expiresAt.After(s.now()) {
+		return PasskeyChallenge{}, newError("TakePasskeyChallenge", ErrNotFound, "passkey challenge has expired")
+	}
+	return challenge.PasskeyChallenge, nil
+}
+
+func (s *MemoryStore) PurgeExpiredPasskeyChallenges(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, challenge := range s.challenges {
+		if !challenge.expiresAt.After(s.now()) {
+			delete(s.challenges, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStore) GetTwoFactor(ctx context.Context, user User) (TwoFactor, error) {
+	if user.Username == "" {
+		return TwoFactor{}, newError("GetTwoFactor", ErrInvalidInput, "cannot look up two-factor for a blank user")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	twoFactor, ok := s.twoFactor[user.Username]
+	if !ok {
+		return TwoFactor{}, newError("GetTwoFactor", ErrNotFound, "two-factor was never set up")
+	}
+	return twoFactor.public(), nil
+}
+
+func (s *MemoryStore) SetupTOTP(ctx context.Context, user User, secret string) error {
+	if user.Username == "" || secret == "" {
+		return newError("SetupTOTP", ErrInvalidInput, "username and secret are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users[user.Username] == nil {
+		return newError("SetupTOTP", ErrInvalidInput, "no user %s", user.Username)
+	}
+	if existing, ok := s.twoFactor[user.Username]; ok && !existing.enabledAt.IsZero() {
+		return newError("SetupTOTP", ErrConflict, "two-factor is already on for %s", user.Username)
+	}
+
+	s.twoFactor[user.Username] = &memTwoFactor{secret: secret, recoveryCodes: map[string]bool{}}
+	return nil
+}
+
+func (s *MemoryStore) EnableTOTP(ctx context.Context, user User, step int64) ([]string, error) {
+	if user.Username == "" {
+		return nil, newError("EnableTOTP", ErrInvalidInput, "cannot enable two-factor for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactor[user.Username]
+	if !ok {
+		return nil, newError("EnableTOTP", ErrNotFound, "two-factor was never set up")
+	}
+	if !twoFactor.enabledAt.IsZero() {
+		return nil, newError("EnableTOTP", ErrConflict, "two-factor is already on for %s", user.Username)
+	}
+
+	codes, hashes := newRecoveryCodes()
+	twoFactor.enabledAt = s.now()
+	twoFactor.lastStep = step
+	twoFactor.recoveryCodes = map[string]bool{}
+	for _, hash := range hashes {
+		twoFactor.recoveryCodes[hash] = true
+	}
+	return codes, nil
+}
+
+func (s *MemoryStore) UseTOTPStep(ctx context.Context, user User, step int64) error {
+	if user.Username == "" {
+		return newError("UseTOTPStep", ErrInvalidInput, "cannot use a code for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactor[user.Username]
+	if !ok || twoFactor.enabledAt.IsZero() || twoFactor.lastStep >= step {
+		return newError("UseTOTPStep", ErrConflict, "code has already been used")
+	}
+
+	twoFactor.lastStep = step
+	return nil
+}
+
+func (s *MemoryStore) DisableTOTP(ctx context.Context, user User) error {
+	if user.Username == "" {
+		return newError("DisableTOTP", ErrInvalidInput, "cannot disable two-factor for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.twoFactor[user.Username]; !ok {
+		return newError("DisableTOTP", ErrNotFound, "no rows affected")
+	}
+	delete(s.twoFactor, user.Username)
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, user User, code string) error {
+	if user.Username == "" || code == "" {
+		return newError("UseRecoveryCode", ErrInvalidInput, "username and code are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactor[user.Username]
+	hash := hashRecoveryCode(code)
+	if !ok || !twoFactor.recoveryCodes[hash] {
+		return newError("UseRecoveryCode", ErrNotFound, "no rows affected")
+	}
+
+	delete(twoFactor.recoveryCodes, hash)
+	return nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, user User) ([]string, error) {
+	if user.Username == "" {
+		return nil, newError("ReplaceRecoveryCodes", ErrInvalidInput, "cannot replace recovery codes for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactor[user.Username]
+	if !ok || twoFactor.enabledAt.IsZero() {
+		return nil, newError("ReplaceRecoveryCodes", ErrNotFound, "two-factor isn't on")
+	}
+
+	codes, hashes := newRecoveryCodes()
+	twoFactor.recoveryCodes = map[string]bool{}
+	for _, hash := range hashes {
+		twoFactor.recoveryCodes[hash] = true
+	}
+	return codes, nil
+}
+
+func (s *MemoryStore) CreateTwoFactorChallenge(ctx context.Context, user User, expiresAt time.Time) (string, error) {
+	if user.Username == "" {
+		return "", newError("CreateTwoFactorChallenge", ErrInvalidInput, "cannot create a two-factor challenge for a blank user")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users[user.Username] == nil {
+		return "", newError("CreateTwoFactorChallenge", ErrInvalidInput, "no user %s", user.Username)
+	}
+
+	token, hash := newRefreshToken()
+	s.twoFactorChallenges[hash] = &memTwoFactorChallenge{username: user.Username, expiresAt: expiresAt}
+	return token, nil
+}
+
+func (s *MemoryStore) GetTwoFactorChallenge(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", newError("GetTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	challenge, ok := s.twoFactorChallenges[hashToken(token)]
+	if !ok || !challenge.expiresAt.After(s.now()) {
+		return "", newError("GetTwoFactorChallenge", ErrNotFound, "no such two-factor challenge")
+	}
+	return challenge.username, nil
+}
+
+func (s *MemoryStore) FailTwoFactorChallenge(ctx context.Context, token string) (int, error) {
+	if token == "" {
+		return 0, newError("FailTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(token)
+	challenge, ok := s.twoFactorChallenges[hash]
+	if !ok || !challenge.expiresAt.After(s.now()) {
+		return 0, newError("FailTwoFactorChallenge", ErrNotFound, "no such two-factor challenge")
+	}
+
+	challenge.attempts++
+	if challenge.attempts >= maxTwoFactorAttempts {
+		delete(s.twoFactorChallenges, hash)
+	}
+	return max(maxTwoFactorAttempts-challenge.attempts, 0), nil
+}
+
+func (s *MemoryStore) FinishTwoFactorChallenge(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", newError("FinishTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(token)
+	challenge, ok := s.twoFactorChallenges[hash]
+	if !ok {
+		return "", newError("FinishTwoFactorChallenge", ErrNotFound, "no such two-factor challenge")
+	}
+	delete(s.twoFactorChallenges, hash)
+
+	if !challenge.expiresAt.After(s.now()) {
+		return "", newError("FinishTwoFactorChallenge", ErrNotFound, "two-factor challenge has expired")
+	}
+	return challenge.username, nil
+}
+
+func (s *MemoryStore) PurgeExpiredTwoFactorChallenges(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for hash, challenge := range s.twoFactorChallenges {
+		if !challenge.expiresAt.After(s.now()) {
+			delete(s.twoFactorChallenges, hash)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,38 @@
+package db
+
+import "crypto/rand"
+
+// a WebAuthn credential a user can sign in with instead of a magic link (0010 migration)
+// the db package doesn't look inside Credential, the handlers keep go-webauthn's credential there as JSON
+type Passkey struct {
+	Id           string
+	Username     string
+	Name         string
+	CredentialId []byte `json:"-"`
+	Credential   []byte `json:"-"`
+	CreatedAt    string
+	LastUsed     string // "" until it's first used
+}
+
+// a passkey registration or sign in that's been started and not finished
+type PasskeyChallenge struct {
+	Id       string
+	Username string // "" when signing in, nobody's known until the authenticator answers
+	Data     []byte // go-webauthn's session data, as JSON
+}
+
+// most passkeys a user can have at once
+const maxPasskeys = 10
+
+// the spec's maximum length for a user handle, it's random so might as well use all of it
+const passkeyHandleLength = 64
+
+func newPasskeyHandle() []byte {
+	handle := make([]byte, passkeyHandleLength)
+	rand.Read(handle)
+	return handle
+}
+
+func validPasskeyName(name string) bool {
+	return name != "" && len(name) <= 100
+}
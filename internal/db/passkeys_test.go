@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasskeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		carol := User{Username: "carol", Email: "carol@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, carol)
+		defer store.DeleteUser(ctx, carol)
+
+		handle, err := store.PasskeyHandle(ctx, carol)
+		assert.Nil(t, err, "handle")
+		assert.Equal(t, passkeyHandleLength, len(handle), "handle length")
+		again, _ := store.PasskeyHandle(ctx, carol)
+		assert.Equal(t, handle, again, "handle is made once")
+
+		owner, err := store.PasskeyOwner(ctx, handle)
+		assert.Nil(t, err, "owner")
+		assert.Equal(t, "carol", owner, "handle resolves to the user")
+		_, err = store.PasskeyOwner(ctx, []byte("nobody"))
+		assert.True(t, errors.Is(err, ErrNotFound), "unknown handle")
+		_, err = store.PasskeyHandle(ctx, User{Username: "nobody_" + uuid.NewString()[:8]})
+		assert.True(t, errors.Is(err, ErrNotFound), "handle for a missing user")
+
+		// credential ids are unique across every user, so the run gets its own
+		credentialId := []byte("credential-" + uuid.NewString())
+		phone, err := store.CreatePasskey(ctx, carol, "phone", credentialId, []byte(`{"signCount": 0}`))
+		assert.Nil(t, err, "create")
+		assert.Equal(t, "phone", phone.Name, "name")
+		assert.Equal(t, "", phone.LastUsed, "never used")
+
+		_, err = store.CreatePasskey(ctx, carol, "phone again", credentialId, []byte(`{}`))
+		assert.True(t, errors.Is(err, ErrConflict), "credential registered twice")
+		_, err = store.CreatePasskey(ctx, carol, "", []byte("other-"+uuid.NewString()), []byte(`{}`))
+		assert.True(t, errors.Is(err, ErrInvalidInput), "blank name")
+
+		used, err := store.UsePasskey(ctx, credentialId, []byte(`{"signCount": 1}`))
+		assert.Nil(t, err, "use")
+		assert.NotEqual(t, "", used.LastUsed, "last used set")
+		assert.JSONEq(t, `{"signCount": 1}`, string(used.Credential), "credential saved")
+		_, err = store.UsePasskey(ctx, []byte("unknown"), []byte(`{}`))
+		assert.True(t, errors.Is(err, ErrNotFound), "unknown credential")
+
+		key, err := store.CreatePasskey(ctx, carol, "security key", []byte("credential-"+uuid.NewString()), []byte(`{}`))
+		assert.Nil(t, err, "second passkey")
+		list, err := store.ListPasskeys(ctx, carol)
+		assert.Nil(t, err, "list")
+		assert.Equal(t, 2, len(list), "both listed")
+		assert.Equal(t, phone.Id, list[0].Id, "oldest first")
+
+		assert.True(t, errors.Is(store.DeletePasskey(ctx, User{Username: "mallory"}, key.Id), ErrNotFound), "someone else's passkey")
+		assert.Nil(t, store.DeletePasskey(ctx, carol, key.Id), "delete")
+		assert.True(t, errors.Is(store.DeletePasskey(ctx, carol, key.Id), ErrNotFound), "already deleted")
+		list, _ = store.ListPasskeys(ctx, carol)
+		assert.Equal(t, 1, len(list), "one left")
+	})
+}
+
+func TestPasskeyChallenges(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		carol := User{Username: "carol", Email: "carol@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, carol)
+		defer store.DeleteUser(ctx, carol)
+
+		id, err := store.CreatePasskeyChallenge(ctx, "carol", []byte(`{"challenge": "abc"}`), time.Now().Add(time.Minute))
+		assert.Nil(t, err, "registration challenge")
+		challenge, err := store.TakePasskeyChallenge(ctx, id)
+		assert.Nil(t, err, "take")
+		assert.Equal(t, "carol", challenge.Username, "user kept")
+		assert.JSONEq(t, `{"challenge": "abc"}`, string(challenge.Data), "data kept")
+		_, err = store.TakePasskeyChallenge(ctx, id)
+		assert.True(t, errors.Is(err, ErrNotFound), "single use")
+
+		id, err = store.CreatePasskeyChallenge(ctx, "", []byte(`{}`), time.Now().Add(time.Minute))
+		assert.Nil(t, err, "sign in challenge")
+		challenge, _ = store.TakePasskeyChallenge(ctx, id)
+		assert.Equal(t, "", challenge.Username, "nobody yet")
+
+		stale, _ := store.CreatePasskeyChallenge(ctx, "", []byte(`{}`), time.Now().Add(-time.Minute))
+		_, err = store.TakePasskeyChallenge(ctx, stale)
+		assert.True(t, errors.Is(err, ErrNotFound), "expired")
+
+		store.CreatePasskeyChallenge(ctx, "", []byte(`{}`), time.Now().Add(-time.Minute))
+		live, _ := store.CreatePasskeyChallenge(ctx, "", []byte(`{}`), time.Now().Add(time.Minute))
+		purged, err := store.PurgeExpiredPasskeyChallenges(ctx)
+		assert.Nil(t, err, "purge")
+		assert.GreaterOrEqual(t, purged, 1, "expired challenge purged")
+		_, err = store.TakePasskeyChallenge(ctx, live)
+		assert.Nil(t, err, "live challenge survives the purge")
+
+		_, err = store.TakePasskeyChallenge(ctx, "not-a-uuid")
+		assert.True(t, errors.Is(err, ErrInvalidInput), "malformed id")
+	})
+}
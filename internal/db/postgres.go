@@ -0,0 +1,2593 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Store backed by PostgreSQL via lib/pq, the schema lives in internal/migrate/migrations
+type PostgresStore struct {
+	db *sql.DB
+
+	// used by the Hot sort, set before serving requests
+	Ranking RankingOptions
+}
+
+// wraps an existing pool, normally the one from GetDB()
+func NewPostgresStore(conn *sql.DB) *PostgresStore {
+	return &PostgresStore{db: conn, Ranking: DefaultRanking()}
+}
+
+// runs fn inside a transaction, rolling back if it returns an error
+// errors coming out of fn are wrapped under op
+func (s *PostgresStore) inTx(ctx context.Context, op string, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return wrapError(op, err)
+	}
+
+	return wrapError(op, tx.Commit())
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user User) error {
+	if user.Username == "" || user.Email == "" || user.Registered_ip == "" {
+		return newError("CreateUser", ErrInvalidInput, "username, email and registration IP are required")
+	}
+
+	query := `INSERT INTO users (username, email, registered_ip) VALUES ($1, $2, $3)`
+
+	_, err := s.db.ExecContext(ctx, query, user.Username, user.Email, user.Registered_ip)
+	if err != nil {
+		return wrapError("CreateUser", err)
+	}
+
+	log.Printf("[INFO] Create user %s with email %s from IP address %s\n", user.Username, user.Email, user.Registered_ip)
+	return nil
+}
+
+func (s *PostgresStore) UpsertUserMetadata(ctx context.Context, metadata UserMetadata) error {
+	// Validate input
+	if metadata.Username == "" {
+		return newError("UpsertUserMetadata", ErrInvalidInput, "please provide a username")
+	}
+
+	// Check if metadata exists for this username
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM bio WHERE username = $1)", metadata.Username).Scan(&exists)
+	if err != nil {
+		return wrapError("UpsertUserMetadata", err)
+	}
+
+	log.Printf("[INFO] Check that user %s exists in the database (%t)\n", metadata.Username, exists)
+
+	if exists {
+		// Update existing metadata
+		_, err = s.db.ExecContext(ctx,
+			"UPDATE bio SET full_name = $1, birthdate = $2, bio_text = $3 WHERE username = $4",
+			metadata.Full_name, metadata.Birthdate, metadata.Bio_text, metadata.Username,
+		)
+		if err != nil {
+			return wrapError("UpsertUserMetadata", err)
+		}
+		log.Printf("[INFO] User exists, updated user %s\n", metadata.Username)
+	} else {
+		// Insert new metadata
+		_, err = s.db.ExecContext(ctx,
+			"INSERT INTO bio (username, full_name, birthdate, bio_text) VALUES ($1, $2, $3, $4)",
+			metadata.Username, metadata.Full_name, metadata.Birthdate, metadata.Bio_text,
+		)
+		if err != nil {
+			return wrapError("UpsertUserMetadata", err)
+		}
+
+		log.Printf("[INFO] User did not exist, inserted user %s\n", metadata.Username)
+	}
+
+	return nil
+}
+
+// ErrNotFound if no user matches
+func (s *PostgresStore) SearchUser(ctx context.Context, user User) (CompleteUser, error) {
+	// two cases: search by username and search by email
+	if user.Email == "" && user.Username == "" {
+		return CompleteUser{}, newError("SearchUser", ErrInvalidInput, "to select a user, you must pass either an email or username")
+	}
+
+	// username VARCHAR(100) PRIMARY KEY,
+	// email VARCHAR(100) NOT NULL,
+	// created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	// registered_ip
+
+	qUsername := `
+	SELECT users.username, users.email, users.created_at, users.registered_ip,
+				COALESCE(SUM(submissions.score), 0) AS score
+	FROM users
+	LEFT JOIN submissions ON users.username = submissions.username
+	WHERE users.username = $1
+	GROUP BY users.username
+	LIMIT 1
+	`
+
+	qEmail := `
+	SELECT users.username, users.email, users.created_at, users.registered_ip,
+				COALESCE(SUM(submissions.score), 0) AS score
+	FROM users
+	LEFT JOIN submissions ON users.username = submissions.username
+	WHERE users.email = $1
+	GROUP BY users.username
+	LIMIT 1
+	`
+
+	var row *sql.Row
+
+	if user.Username != "" {
+		row = s.db.QueryRowContext(ctx, qUsername, user.Username)
+		log.Printf("[INFO] Queried user %s via username\n", user.Username)
+	} else {
+		row = s.db.QueryRowContext(ctx, qEmail, user.Email)
+		log.Printf("[INFO] Queried user email %s to get user\n", user.Email)
+	}
+
+	var tempUser = User{}
+	err := row.Scan(&tempUser.Username, &tempUser.Email, &tempUser.Created_at, &tempUser.Registered_ip, &tempUser.Score)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("[INFO] User query search did not result in any user(s)\n")
+		}
+		return CompleteUser{}, wrapError("SearchUser", err)
+	}
+
+	log.Printf("[INFO] User query search resulted in user %s created @ %s\n", tempUser.Username, tempUser.Created_at)
+
+	// now that we've got the user themselves, let's grab their metadata
+	var tempMetadata = UserMetadata{}
+	query := `
+	SELECT bio.username, bio.full_name, bio.birthdate, bio.bio_text,
+	CASE
+		WHEN admins.role = 'admin' THEN true
+		ELSE false
+	END AS isAdmin
+
+	FROM bio
+	LEFT JOIN admins ON bio.username = admins.username
+	WHERE bio.username = $1;
+	`
+	rows, err := s.db.QueryContext(ctx, query, tempUser.Username)
+	if err != nil {
+		return CompleteUser{}, wrapError("SearchUser", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := rows.Scan(&tempMetadata.Username, &tempMetadata.Full_name, &tempMetadata.Birthdate, &tempMetadata.Bio_text, &tempMetadata.IsAdmin)
+		if err != nil {
+			return CompleteUser{}, wrapError("SearchUser", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return CompleteUser{}, wrapError("SearchUser", err)
+	}
+
+	log.Printf("[INFO] User query metadata search success, admin status: %t\n", tempMetadata.IsAdmin)
+
+	return CompleteUser{User: tempUser, Metadata: tempMetadata}, nil
+}
+
+// ErrNotFound if there was no such user to delete
+func (s *PostgresStore) DeleteUser(ctx context.Context, user User) error {
+	// two cases: search by username and search by email
+	if user.Email == "" && user.Username == "" {
+		return newError("DeleteUser", ErrInvalidInput, "to delete a user, you must pass either an email or username")
+	}
+
+	return s.inTx(ctx, "DeleteUser", func(tx *sql.Tx) error {
+		username := user.Username
+		if username == "" {
+			if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE email = $1", user.Email).Scan(&username); err != nil {
+				return err
+			}
+		}
+
+		// the user's votes cascade away with them, take them off the counters first
+		if err := removeUserVotesFromCounters(ctx, tx, username); err != nil {
+			return err
+		}
+		if err := removeUserComments(ctx, tx, username); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE username = $1", username)
+		if err != nil {
+			return err
+		}
+
+		if user.Username != "" {
+			log.Printf("[INFO] Deleted user %s (via username)\n", user.Username)
+		} else {
+			log.Printf("[INFO] Deleted user via email, %s\n", user.Email)
+		}
+
+		// additional note: user bios are cascading, so Postgres will delete them automatically
+		return expectAffected("DeleteUser", res)
+	})
+}
+
+// validation for the correct user is done in the API business logic
+func (s *PostgresStore) DeleteSubmission(ctx context.Context, submission Submission) error {
+	if submission.Id == "" {
+		return newError("DeleteSubmission", ErrInvalidInput, "to delete a submission, you must pass a submission ID")
+	}
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM submissions WHERE id = $1", submission.Id)
+	if err != nil {
+		return wrapError("DeleteSubmission", err)
+	}
+
+	log.Printf("[INFO] Deleted submission %s", submission.Id)
+
+	return expectAffected("DeleteSubmission", res)
+}
+
+// ErrNotFound if no submission has the ID
+func (s *PostgresStore) SearchSubmission(ctx context.Context, stub Submission) (Submission, error) {
+	if stub.Id == "" {
+		return Submission{}, newError("SearchSubmission", ErrInvalidInput, "please use an ID when searching for a submission")
+	}
+
+	var tempBody sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, username, title, link, body, flagged, created_at, score, comment_count FROM submissions WHERE id = $1", stub.Id,
+	).Scan(&stub.Id, &stub.Username, &stub.Title, &stub.Link, &tempBody, &stub.Flagged, &stub.Created_at, &stub.Votes, &stub.CommentCount)
+	if err != nil {
+		return Submission{}, wrapError("SearchSubmission", err)
+	}
+
+	stub.Body = tempBody.String
+
+	log.Printf("[INFO] Succesful query for submission %s created at %s", stub.Id, stub.Created_at)
+
+	return stub, nil
+}
+
+func (s *PostgresStore) AllSubmissions(ctx context.Context, sort SortMethod, page Page) ([]Submission, string, error) {
+	after, err := decodeCursor("AllSubmissions", page.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("AllSubmissions")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{limit + 1}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// hot_rank and as_of only mean something for Hot, the other sorts select placeholders
+	rank := "0::float8"
+	asOf := "NULL::timestamp"
+	filter := ""
+	seek := ""
+
+	// determine how to do the sorting itself, plus where the page starts (keyset pagination)
+	var order string
+	switch sort {
+	case Latest:
+		order = "ORDER BY created_at DESC, id DESC"
+		if after != nil {
+			seek = "WHERE (created_at, id) < (" + arg(after.CreatedAt) + ", " + arg(after.Id) + ")"
+		}
+		log.Printf("[INFO] Attempting all submissions sort query for filter 'latest'\n")
+	case Oldest:
+		order = "ORDER BY created_at ASC, id ASC"
+		if after != nil {
+			seek = "WHERE (created_at, id) > (" + arg(after.CreatedAt) + ", " + arg(after.Id) + ")"
+		}
+		log.Printf("[INFO] Attempting all submissions sort query for filter 'oldest'\n")
+	case Best:
+		order = "ORDER BY score DESC, created_at DESC, id DESC"
+		if after != nil {
+			seek = "WHERE (score, created_at, id) < (" + arg(after.Score) + ", " + arg(after.CreatedAt) + ", " + arg(after.Id) + ")"
+		}
+		log.Printf("[INFO] Attempting all submissions sort query for filter 'best'\n")
+	case Hot:
+		// ranks are computed as of the first page's timestamp so they hold still from page to page,
+		// anything posted since then waits for the client to start again from the top
+		asOf = "LOCALTIMESTAMP"
+		if after != nil {
+			asOf = arg(after.AsOf) + "::timestamp"
+		}
+		filter = "WHERE created_at <= " + asOf
+
+		// same formula as HotRank in ranking.go
+		age := "GREATEST(EXTRACT(EPOCH FROM (" + asOf + " - created_at))::float8 / 3600, 0)"
+		decay := "POWER(" + age + " + 2, " + arg(s.Ranking.Gravity) + "::float8)"
+		penalty := "((CASE WHEN flagged THEN " + arg(s.Ranking.FlaggedPenalty) + "::float8 ELSE 1 END) * " +
+			"(CASE WHEN comment_count < " + arg(s.Ranking.LowCommentThreshold) + " THEN " + arg(s.Ranking.LowCommentPenalty) + "::float8 ELSE 1 END))"
+		rank = `CASE WHEN score < 0 AND ` + penalty + ` > 0
+					THEN score / ` + decay + ` / ` + penalty + `
+					ELSE score / ` + decay + ` * ` + penalty + `
+				END`
+
+		order = "ORDER BY hot_rank DESC, created_at DESC, id DESC"
+		if after != nil {
+			seek = "WHERE (hot_rank, created_at, id) < (" + arg(after.Rank) + ", " + arg(after.CreatedAt) + ", " + arg(after.Id) + ")"
+		}
+		log.Printf("[INFO] Attempting all submissions sort query for filter 'hot'\n")
+	default:
+		return nil, "", newError("AllSubmissions", ErrInvalidInput, "unknown sort method %q", sort)
+	}
+
+	// the inner query works out the rank so the outer one can seek and sort on it by name
+	query := `
+			SELECT id, username, title, link, body, created_at, flagged, score, comment_count, hot_rank, as_of
+			FROM (
+				SELECT id, username, title, link, body, created_at, flagged, score, comment_count,
+					` + rank + ` AS hot_rank,
+					` + asOf + ` AS as_of
+				FROM submissions
+				` + filter + `
+			) ranked
+			` + seek + `
+			` + order + `
+			LIMIT $1`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("AllSubmissions", err)
+	}
+	defer rows.Close()
+
+	log.Printf("[INFO] Queried all submissions with limit of %d (first page: %t)\n", limit, after == nil)
+
+	type rankedSubmission struct {
+		Submission
+		rank float64
+		asOf string
+	}
+
+	var ranked []rankedSubmission
+	for rows.Next() {
+		var tempBody, tempAsOf sql.NullString
+		var current rankedSubmission
+
+		if err := rows.Scan(&current.Id, &current.Username, &current.Title, &current.Link, &tempBody, &current.Created_at, &current.Flagged, &current.Votes, &current.CommentCount, &current.rank, &tempAsOf); err != nil {
+			return nil, "", wrapError("AllSubmissions", err)
+		}
+
+		current.Body = tempBody.String
+		current.asOf = tempAsOf.String
+
+		ranked = append(ranked, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("AllSubmissions", err)
+	}
+
+	ranked, next := paginate(ranked, limit, func(last rankedSubmission) cursor {
+		return cursor{Sort: sort, CreatedAt: last.Created_at, Id: last.Id, Score: last.Votes, Rank: last.rank, AsOf: last.asOf}
+	})
+
+	submissions := []Submission{}
+	for _, r := range ranked {
+		submissions = append(submissions, r.Submission)
+	}
+
+	log.Printf("[INFO] Query resulted in %d submissions\n", len(submissions))
+
+	return submissions, next, nil
+}
+
+func (s *PostgresStore) LatestUserComments(ctx context.Context, page Page, user User) ([]Comment, string, error) {
+	after, err := decodeCursor("LatestUserComments", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("LatestUserComments")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{user.Username, limit + 1}
+	seek := ""
+	if after != nil {
+		seek = "AND (created_at, id) < ($3, $4)"
+		args = append(args, after.CreatedAt, after.Id)
+	}
+
+	query := `
+		SELECT id, in_response_to, content, author, parent_comment, flagged, created_at
+		FROM comments
+		WHERE author = $1
+		` + seek + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("LatestUserComments", err)
+	}
+	defer rows.Close()
+
+	submissions := []Comment{}
+	for rows.Next() {
+		var current Comment
+		var pc sql.NullString
+
+		if err := rows.Scan(&current.Id, &current.InResponseTo, &current.Content, &current.Author, &pc, &current.Flagged, &current.CreatedAt); err != nil {
+			return nil, "", wrapError("LatestUserComments", err)
+		}
+
+		current.ParentComment = pc.String
+
+		submissions = append(submissions, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("LatestUserComments", err)
+	}
+
+	submissions, next := paginate(submissions, limit, func(last Comment) cursor {
+		return cursor{CreatedAt: last.CreatedAt, Id: last.Id}
+	})
+
+	log.Printf("[INFO] Latest user comments query for %s resulted in %d, using a limit of %d\n", user.Username, len(submissions), limit)
+
+	return submissions, next, nil
+}
+
+func (s *PostgresStore) LatestUserSubmissions(ctx context.Context, page Page, user User) ([]BasicSubmission, string, error) {
+	after, err := decodeCursor("LatestUserSubmissions", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("LatestUserSubmissions")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{user.Username, limit + 1}
+	seek := ""
+	if after != nil {
+		seek = "AND (created_at, id) < ($3, $4)"
+		args = append(args, after.CreatedAt, after.Id)
+	}
+
+	query := `
+		SELECT id, title, link, created_at
+		FROM submissions
+		WHERE username = $1
+		` + seek + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("LatestUserSubmissions", err)
+	}
+	defer rows.Close()
+
+	submissions := []BasicSubmission{}
+	for rows.Next() {
+		var current BasicSubmission
+
+		if err := rows.Scan(&current.Id, &current.Title, &current.Link, &current.Created_at); err != nil {
+			return nil, "", wrapError("LatestUserSubmissions", err)
+		}
+
+		submissions = append(submissions, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("LatestUserSubmissions", err)
+	}
+
+	submissions, next := paginate(submissions, limit, func(last BasicSubmission) cursor {
+		return cursor{CreatedAt: last.Created_at, Id: last.Id}
+	})
+
+	log.Printf("[INFO] Latest user submissions query for %s resulted in %d, using a limit of %d\n", user.Username, len(submissions), limit)
+
+	return submissions, next, nil
+}
+
+func (s *PostgresStore) CreateSubmission(ctx context.Context, submission Submission) (string, error) {
+	if submission.Username == "" || submission.Title == "" || submission.Link == "" {
+		return "", newError("CreateSubmission", ErrInvalidInput, "username, title and link are required")
+	}
+
+	query := `
+		INSERT INTO submissions (username, title, link, body, flagged)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;
+	`
+
+	var id string
+	err := s.db.QueryRowContext(ctx, query, submission.Username, submission.Title, submission.Link, submission.Body, submission.Flagged).Scan(&id)
+	if err != nil {
+		return "", wrapError("CreateSubmission", err)
+	}
+
+	log.Printf("[INFO] New submission authored by %s with ID %s created\n", submission.Username, id)
+
+	return id, nil
+}
+
+func (s *PostgresStore) UpdateSubmission(ctx context.Context, stub Submission) error {
+	if stub.Id == "" {
+		return newError("UpdateSubmission", ErrInvalidInput, "please use an ID when updating a submission")
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE submissions SET link = $1, title = $2, body = $3, flagged = $4 WHERE id = $5", stub.Link, stub.Title, stub.Body, stub.Flagged, stub.Id)
+	if err != nil {
+		return wrapError("UpdateSubmission", err)
+	}
+
+	log.Printf("[INFO] Updated submission %s\n", stub.Id)
+
+	return expectAffected("UpdateSubmission", res)
+}
+
+// Changed is true on success (new vote or flipped vote)
+// false when attempting to "double vote"
+func (s *PostgresStore) Vote(ctx context.Context, user User, submission Submission, isUpvote bool) (VoteResult, error) {
+	// check that we have a valid username + submission id combo
+	if user.Username == "" || submission.Id == "" {
+		return VoteResult{}, newError("Vote", ErrInvalidInput, "username or submission id is blank (required to vote on a submission)")
+	}
+
+	var result VoteResult
+	err := s.inTx(ctx, "Vote", func(tx *sql.Tx) error {
+		var err error
+		result, err = castVote(ctx, tx, submissionVoteTable, submission.Id, user.Username, isUpvote)
+		return err
+	})
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	voteType := "downvote"
+	if isUpvote {
+		voteType = "upvote"
+	}
+
+	if result.Changed {
+		log.Printf("[INFO] Recorded %s on post ID %s from %s, score is now %d\n", voteType, submission.Id, user.Username, result.Score)
+	} else {
+		log.Printf("[INFO] Double vote attempted by %s\n", user.Username)
+	}
+
+	return result, nil
+}
+
+// removes the user's vote on a submission
+// Changed is false if they hadn't voted on it, ErrNotFound if the submission doesn't exist
+func (s *PostgresStore) Unvote(ctx context.Context, user User, submission Submission) (VoteResult, error) {
+	if user.Username == "" || submission.Id == "" {
+		return VoteResult{}, newError("Unvote", ErrInvalidInput, "username or submission id is blank (required to remove a vote)")
+	}
+
+	var result VoteResult
+	err := s.inTx(ctx, "Unvote", func(tx *sql.Tx) error {
+		var err error
+		result, err = retractVote(ctx, tx, submissionVoteTable, submission.Id, user.Username)
+		return err
+	})
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	log.Printf("[INFO] Unvote on post ID %s from %s (removed: %t), score is now %d\n", submission.Id, user.Username, result.Changed, result.Score)
+
+	return result, nil
+}
+
+// Response meaning:
+// Boolean #1: did the user vote on the post?
+// Boolean #2: if so, did they upvote (true) or downvote (false)?
+func (s *PostgresStore) GetUserVote(ctx context.Context, user User, submission Submission) (bool, bool, error) {
+	if user.Username == "" {
+		return false, false, newError("GetUserVote", ErrInvalidInput, "missing username")
+	}
+
+	if submission.Id == "" {
+		return false, false, newError("GetUserVote", ErrInvalidInput, "missing submission ID")
+	}
+
+	var didUpvote bool
+	err := s.db.QueryRowContext(ctx, "SELECT positive FROM votes WHERE voter_username = $1 AND submission_id = $2", user.Username, submission.Id).Scan(&didUpvote)
+
+	if err == sql.ErrNoRows {
+		log.Printf("[INFO] No vote found for user %s on post %s\n", user.Username, submission.Id)
+		return false, false, nil
+	}
+
+	if err != nil {
+		return false, false, wrapError("GetUserVote", err)
+	}
+
+	var voteType string
+
+	// i am PRAYING the next golang update includes ternaries
+	if didUpvote {
+		voteType = "upvote"
+	} else {
+		voteType = "downvote"
+	}
+
+	log.Printf("[INFO] Search found a %s on post %s by user %s\n", voteType, submission.Id, user.Username)
+
+	return true, didUpvote, nil
+}
+
+// submissions the user has voted on, newest submission first
+func (s *PostgresStore) GetAllUserVotes(ctx context.Context, page Page, user User) ([]BasicSubmissionAndVote, string, error) {
+	if user.Username == "" {
+		return nil, "", newError("GetAllUserVotes", ErrInvalidInput, "user's username cannot be blank")
+	}
+
+	after, err := decodeCursor("GetAllUserVotes", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("GetAllUserVotes")
+	if err != nil {
+		return nil, "", err
+	}
+
+	args := []any{user.Username, limit + 1}
+	seek := ""
+	if after != nil {
+		seek = "AND (submissions.created_at, submissions.id) < ($3, $4)"
+		args = append(args, after.CreatedAt, after.Id)
+	}
+
+	// future: maybe instead of a string of IDs, use a string of submissions?
+	query := `
+		SELECT title, link, body, submissions.created_at, username, submission_id, positive
+		FROM votes
+		INNER JOIN submissions ON submission_id = submissions.id
+		WHERE voter_username = $1
+		` + seek + `
+		ORDER BY submissions.created_at DESC, submissions.id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("GetAllUserVotes", err)
+	}
+	defer rows.Close()
+
+	submissions := []BasicSubmissionAndVote{}
+	for rows.Next() {
+		var tempBody sql.NullString
+		var current BasicSubmissionAndVote
+
+		if err := rows.Scan(&current.Title, &current.Link, &tempBody, &current.Created_at, &current.Username, &current.Id, &current.IsUpvoted); err != nil {
+			return nil, "", wrapError("GetAllUserVotes", err)
+		}
+
+		current.Body = tempBody.String
+
+		submissions = append(submissions, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("GetAllUserVotes", err)
+	}
+
+	submissions, next := paginate(submissions, limit, func(last BasicSubmissionAndVote) cursor {
+		return cursor{CreatedAt: last.Created_at, Id: last.Id}
+	})
+
+	log.Printf("[INFO] Query for all user votes on user %s resulted in %d voted posts, w/ limit of %d\n", user.Username, len(submissions), limit)
+
+	return submissions, next, nil
+}
+
+// the token is only returned here, the database keeps a hash of it
+func (s *PostgresStore) CreateMagicLink(ctx context.Context, user User, expiresAt time.Time) (string, error) {
+	if user.Username == "" || user.Email == "" {
+		return "", newError("CreateMagicLink", ErrInvalidInput, "to create a magic link, user must have a username and email")
+	}
+
+	// first, delete all old magic links for given user
+	_, err := s.db.ExecContext(ctx, "DELETE FROM magic_links WHERE username = $1", user.Username)
+	if err != nil {
+		return "", wrapError("CreateMagicLink", err)
+	}
+
+	// next generate the secure token
+	var token string = SecureToken(100)
+	query := `
+		INSERT INTO magic_links (username, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err = s.db.ExecContext(ctx, query, user.Username, user.Email, hashToken(token), expiresAt.UTC())
+	if err != nil {
+		return "", wrapError("CreateMagicLink", err)
+	}
+
+	// given a string 's'
+	// len(s) --> returns the length of ASCII code
+	// len([]rune(s)) --> returns the length of char count in string
+
+	// []rune() returns an array of ascii values for each char in a string
+
+	log.Printf("[INFO] Magic link for username %s and email %s created, length %d", user.Username, user.Email, len([]rune(token)))
+
+	return token, nil
+}
+
+func (s *PostgresStore) DeleteMagicLink(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM magic_links WHERE token_hash = $1", hashToken(token))
+	if err != nil {
+		return wrapError("DeleteMagicLink", err)
+	}
+
+	log.Printf("[INFO] Magic link for of length %d deleted", len([]rune(token)))
+	return nil
+}
+
+// ErrNotFound if the token doesn't match a magic link, or it has expired
+// the link is deleted in the same statement that reads it, so two requests racing with one link can't both sign in
+func (s *PostgresStore) ValidateMagicLink(ctx context.Context, token string, ip string) (User, error) {
+	if token == "" {
+		return User{}, newError("ValidateMagicLink", ErrInvalidInput, "you must pass in a token to validate")
+	}
+
+	if ip == "" {
+		return User{}, newError("ValidateMagicLink", ErrInvalidInput, "registration IP address required")
+	}
+
+	var username string
+	var email string
+	var expired bool
+
+	err := s.db.QueryRowContext(ctx, "DELETE FROM magic_links WHERE token_hash = $1 RETURNING username, email, expires_at <= NOW()", hashToken(token)).Scan(&username, &email, &expired)
+	if err == sql.ErrNoRows {
+		log.Printf("[WARN] Magic link search found no user for token length %d\n", len([]rune(token)))
+	}
+	if err != nil {
+		return User{}, wrapError("ValidateMagicLink", err)
+	}
+
+	if expired {
+		log.Printf("[WARN] Magic link for user %s used after it expired\n", username)
+		return User{}, newError("ValidateMagicLink", ErrNotFound, "magic link has expired")
+	}
+
+	log.Printf("[INFO] Magic link search found user %s and email %s for token of length %d\n", username, email, len([]rune(token)))
+
+	// determine if we need to insert the user into the database or not
+	searched, err := s.SearchUser(ctx, User{Username: username})
+	if errors.Is(err, ErrNotFound) {
+		var toInsert User = User{Username: username, Email: email, Registered_ip: ip}
+		if err := s.CreateUser(ctx, toInsert); err != nil {
+			return User{}, wrapError("ValidateMagicLink", err)
+		}
+		log.Printf("[INFO] User %s registration via magic link completed\n", username)
+		return toInsert, nil
+	}
+	if err != nil {
+		return User{}, wrapError("ValidateMagicLink", err)
+	}
+
+	// the link went to whoever asked for it, which only proves they own the account if it's the account's address
+	if !strings.EqualFold(searched.User.Email, email) {
+		log.Printf("[WARN] Magic link for user %s was sent to an address the account doesn't have\n", username)
+		return User{}, newError("ValidateMagicLink", ErrConflict, "magic link was sent to a different address than the account's")
+	}
+
+	log.Printf("[INFO] User %s login via magic link completed\n", username)
+	return searched.User, nil
+}
+
+// deletes links that expired without being used, returns how many
+func (s *PostgresStore) PurgeExpiredMagicLinks(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM magic_links WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, wrapError("PurgeExpiredMagicLinks", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError("PurgeExpiredMagicLinks", err)
+	}
+	return int(n), nil
+}
+
+// ErrNotFound if no submission has the ID
+func (s *PostgresStore) CountVotes(ctx context.Context, post Submission) (VoteMetrics, error) {
+	if post.Id == "" {
+		return VoteMetrics{}, newError("CountVotes", ErrInvalidInput, "cannot query votes with a blank submission ID")
+	}
+
+	var metrics VoteMetrics
+	err := s.db.QueryRowContext(ctx, "SELECT upvotes, downvotes FROM submissions WHERE id = $1", post.Id).Scan(&metrics.Upvotes, &metrics.Downvotes)
+	if err != nil {
+		return VoteMetrics{}, wrapError("CountVotes", err)
+	}
+
+	log.Printf("[INFO] %d upvotes, %d downvotes counted for submission ID %s\n", metrics.Upvotes, metrics.Downvotes, post.Id)
+
+	return metrics, nil
+}
+
+// full text search over submissions and/or comments, see SearchQuery for the syntax
+// matches use the GIN indexes on search_vector (0003 migration), results come best first by SearchRank
+// (relevance blended with points and age), ties going to the newer match
+// like Hot, ranked as of the first page's timestamp so they hold still while paging, the cursor seeks on (rank, created_at, id)
+func (s *PostgresStore) Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, string, error) {
+	after, err := decodeCursor("Search", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	expr, domain, err := query.validate("Search")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("Search")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{expr.tsquery(), limit + 1}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	asOf := "LOCALTIMESTAMP"
+	if after != nil {
+		asOf = arg(after.AsOf) + "::timestamp"
+	}
+
+	// same formula as SearchRank in search.go
+	rank := func(table string) string {
+		age := "GREATEST(EXTRACT(EPOCH FROM (" + asOf + " - " + table + ".created_at))::float8 / 3600, 0)"
+		return "ts_rank_cd(" + table + ".search_vector, q.query, 32) * (1 + LN(1 + GREATEST(" + table + ".score, 0))) / (1 + " + age + " / 720)"
+	}
+
+	// filters shared by both halves, author column differs
+	filters := func(table string, author string) string {
+		where := table + ".search_vector @@ q.query AND NOT " + table + ".flagged AND " + table + ".created_at <= " + asOf
+		if query.Author != "" {
+			where += " AND " + table + "." + author + " = " + arg(query.Author)
+		}
+		if !query.After.IsZero() {
+			where += " AND " + table + ".created_at >= " + arg(formatTime(query.After)) + "::timestamp"
+		}
+		if !query.Before.IsZero() {
+			where += " AND " + table + ".created_at < " + arg(formatTime(query.Before)) + "::timestamp"
+		}
+		return where
+	}
+
+	// both halves select the same columns so they can be UNIONed, whatever doesn't apply is NULL
+	var halves []string
+	if query.includes(SearchSubmissions) {
+		where := filters("s", "username")
+		if domain != "" {
+			d := arg(domain)
+			where += " AND (s.domain = " + d + " OR right(s.domain, length(" + d + ") + 1) = '.' || " + d + ")"
+		}
+		halves = append(halves, `
+			SELECT 'submission' AS type, s.id, s.username AS author, s.title, s.link, s.body AS text,
+				NULL::uuid AS in_response_to, NULL::uuid AS parent_comment, s.created_at, s.score, s.upvotes, s.downvotes, s.comment_count,
+				`+rank("s")+` AS rank
+			FROM submissions s, q
+			WHERE `+where)
+	}
+	if query.includes(SearchComments) && domain == "" {
+		halves = append(halves, `
+			SELECT 'comment' AS type, c.id, c.author, NULL AS title, NULL AS link, c.content AS text,
+				c.in_response_to, c.parent_comment, c.created_at, c.score, c.upvotes, c.downvotes, c.comment_count,
+				`+rank("c")+` AS rank
+			FROM comments c, q
+			WHERE `+filters("c", "author"))
+	}
+
+	seek := ""
+	if after != nil {
+		seek = "WHERE (rank, created_at, id) < (" + arg(after.Rank) + ", " + arg(after.CreatedAt) + ", " + arg(after.Id) + ")"
+	}
+
+	// headlines are only worked out for the page being returned, they're the expensive part
+	// the text is HTML escaped before ts_headline adds its <mark> tags
+	escape := func(col string) string {
+		return "replace(replace(replace(" + col + ", '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+	}
+	q := `
+		WITH q AS (SELECT to_tsquery('english', $1) AS query),
+		hits AS (` + strings.Join(halves, "\n\t\t\tUNION ALL\n") + `
+		),
+		page AS (
+			SELECT * FROM hits
+			` + seek + `
+			ORDER BY rank DESC, created_at DESC, id DESC
+			LIMIT $2
+		)
+		SELECT page.type, page.id, page.author, page.title, page.link, page.text, page.in_response_to, page.parent_comment,
+			page.created_at, page.score, page.upvotes, page.downvotes, page.comment_count, page.rank, ` + asOf + `,
+			COALESCE(ts_headline('english', ` + escape("page.title") + `, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'), ''),
+			COALESCE(ts_headline('english', ` + escape("page.text") + `, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2'), '')
+		FROM page, q
+		ORDER BY page.rank DESC, page.created_at DESC, page.id DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, "", wrapError("Search", err)
+	}
+	defer rows.Close()
+
+	type rankedResult struct {
+		SearchResult
+		createdAt string
+		id        string
+		asOf      string
+	}
+
+	var results []rankedResult
+	for rows.Next() {
+		var current rankedResult
+		var author string
+		var title, link, text, inResponseTo, parentComment sql.NullString
+		var score, upvotes, downvotes, count int
+
+		err := rows.Scan(&current.Type, &current.id, &author, &title, &link, &text, &inResponseTo, &parentComment,
+			&current.createdAt, &score, &upvotes, &downvotes, &count, &current.Rank, &current.asOf, &current.Title, &current.Snippet)
+		if err != nil {
+			return nil, "", wrapError("Search", err)
+		}
+
+		if current.Type == SearchSubmissions {
+			current.Submission = &Submission{Id: current.id, Username: author, Title: title.String, Link: link.String, Body: text.String, Created_at: current.createdAt, Votes: score, CommentCount: count}
+		} else {
+			current.Comment = &Comment{Id: current.id, InResponseTo: inResponseTo.String, Content: text.String, Author: author, ParentComment: parentComment.String, CreatedAt: current.createdAt, Upvotes: upvotes, Downvotes: downvotes, ReplyCount: count}
+		}
+
+		results = append(results, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("Search", err)
+	}
+
+	results, next := paginate(results, limit, func(last rankedResult) cursor {
+		return cursor{CreatedAt: last.createdAt, Id: last.id, Rank: last.Rank, AsOf: last.asOf}
+	})
+
+	log.Printf("[INFO] Search query returned %d results, with a limit of %d\n", len(results), limit)
+
+	searchResults := []SearchResult{}
+	for _, result := range results {
+		searchResults = append(searchResults, result.SearchResult)
+	}
+
+	return searchResults, next, nil
+}
+
+// ATTN: consider caching this route, it's expensive
+func (s *PostgresStore) GetAdminMetrics(ctx context.Context) (AdminMetrics, error) {
+
+	// Last seven days, how many posts per day?
+	query := `
+		SELECT
+			days_between((NOW() - INTERVAL '1 day')::TIMESTAMP, NOW()::TIMESTAMP) as today,
+			days_between((NOW() - INTERVAL '2 days')::TIMESTAMP, (NOW() - INTERVAL '1 day')::TIMESTAMP) as todayMinusOne,
+			days_between((NOW() - INTERVAL '3 days')::TIMESTAMP, (NOW() - INTERVAL '2 days')::TIMESTAMP) as todayMinusTwo,
+			days_between((NOW() - INTERVAL '4 days')::TIMESTAMP, (NOW() - INTERVAL '3 days')::TIMESTAMP) as todayMinusThree,
+			days_between((NOW() - INTERVAL '5 days')::TIMESTAMP, (NOW() - INTERVAL '4 days')::TIMESTAMP) as todayMinusFour,
+			days_between((NOW() - INTERVAL '6 days')::TIMESTAMP, (NOW() - INTERVAL '5 days')::TIMESTAMP) as todayMinusFive,
+			days_between((NOW() - INTERVAL '7 days')::TIMESTAMP, (NOW() - INTERVAL '6 days')::TIMESTAMP) as todayMinusSix;
+	`
+	var today int
+	var todayMinusOne int
+	var todayMinusTwo int
+	var todayMinusThree int
+	var todayMinusFour int
+	var todayMinusFive int
+	var todayMinusSix int
+
+	err := s.db.QueryRowContext(ctx, query).Scan(&today, &todayMinusOne, &todayMinusTwo, &todayMinusThree, &todayMinusFour, &todayMinusFive, &todayMinusSix)
+	if err != nil {
+		return AdminMetrics{}, wrapError("GetAdminMetrics", err)
+	}
+
+	log.Printf("[INFO] Database made admin query for # of submissions over the last 7 days\n")
+
+	var totalPosts int
+	query = `
+		SELECT count(*)
+		FROM submissions
+	`
+	err = s.db.QueryRowContext(ctx, query).Scan(&totalPosts)
+	if err != nil {
+		return AdminMetrics{}, wrapError("GetAdminMetrics", err)
+	}
+
+	var totalUsers int
+	query = `
+		SELECT count(*)
+		FROM users
+	`
+	err = s.db.QueryRowContext(ctx, query).Scan(&totalUsers)
+	if err != nil {
+		return AdminMetrics{}, wrapError("GetAdminMetrics", err)
+	}
+
+	log.Printf("[INFO] Database made admin query for # of total users\n")
+
+	// goal of the actives users query: how many users have made a post/voted in the last week?
+	// when comments become avaialble, this should include comments?
+	var totalActiveUsers int
+	query = `
+		SELECT COUNT(DISTINCT username) AS active_users
+		FROM (
+			SELECT username FROM submissions
+			WHERE created_at BETWEEN (NOW() - INTERVAL '7 days') AND NOW()
+
+			UNION
+
+			SELECT voter_username AS username FROM votes
+			WHERE ts BETWEEN (NOW() - INTERVAL '7 days') AND NOW()
+		) AS active;
+	`
+	err = s.db.QueryRowContext(ctx, query).Scan(&totalActiveUsers)
+	if err != nil {
+		return AdminMetrics{}, wrapError("GetAdminMetrics", err)
+	}
+
+	log.Printf("[INFO] Database made admin query for # of active users over the last 7 days\n")
+
+	return AdminMetrics{
+		TodayPosts:              today,
+		TodayMinusOnePosts:      todayMinusOne,
+		TodayMinusTwoPosts:      todayMinusTwo,
+		TodayMinusThreePosts:    todayMinusThree,
+		TodayMinusFourPosts:     todayMinusFour,
+		TodayMinusFivePosts:     todayMinusFive,
+		TodayMinusSixPosts:      todayMinusSix,
+		TotalAllTimeSubmissions: totalPosts,
+		TotalAllTimeUsers:       totalUsers,
+		TotalActiveUsers:        totalActiveUsers,
+	}, nil
+}
+
+func (s *PostgresStore) CheckAdminStatus(ctx context.Context, user User) (bool, error) {
+	if user.Username == "" {
+		return false, newError("CheckAdminStatus", ErrInvalidInput, "unable to check admin status of user with blank username")
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM admins WHERE username = $1 AND role = 'admin'
+		);
+		`
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, user.Username).Scan(&exists)
+	if err != nil {
+		return false, wrapError("CheckAdminStatus", err)
+	}
+
+	return exists, nil
+}
+
+func (s *PostgresStore) GetRole(ctx context.Context, user User) (Role, error) {
+	if user.Username == "" {
+		return "", newError("GetRole", ErrInvalidInput, "unable to look up the role of a user with blank username")
+	}
+
+	var role Role
+	err := s.db.QueryRowContext(ctx, `SELECT role FROM admins WHERE username = $1;`, user.Username).Scan(&role)
+	if err == sql.ErrNoRows {
+		// not staff, which is almost everyone
+		return RoleUser, nil
+	}
+	if err != nil {
+		return "", wrapError("GetRole", err)
+	}
+
+	return role, nil
+}
+
+func (s *PostgresStore) InsertNewComment(ctx context.Context, comment Comment) (string, error) {
+	// bare minimum requirements for a new comment
+	if comment.InResponseTo == "" || comment.Author == "" || comment.Content == "" {
+		return "", newError("InsertNewComment", ErrInvalidInput, "attempted to insert new comment without one or more of the following: InResponseTo, Author, Content")
+	}
+
+	var id string
+
+	err := s.inTx(ctx, "InsertNewComment", func(tx *sql.Tx) error {
+		if comment.ParentComment != "" {
+			query := `
+				INSERT INTO comments (in_response_to, content, author, parent_comment, flagged)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id;
+			`
+
+			if err := tx.QueryRowContext(ctx, query, comment.InResponseTo, comment.Content, comment.Author, comment.ParentComment, false).Scan(&id); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, "UPDATE comments SET comment_count = comment_count + 1 WHERE id = $1", comment.ParentComment); err != nil {
+				return err
+			}
+
+			log.Printf("[INFO] Database made comment insertion in response to %s WITH a parent comment\n", comment.InResponseTo)
+		} else {
+			query := `
+				INSERT INTO comments (in_response_to, content, author, flagged)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id;
+			`
+
+			if err := tx.QueryRowContext(ctx, query, comment.InResponseTo, comment.Content, comment.Author, false).Scan(&id); err != nil {
+				return err
+			}
+
+			log.Printf("[INFO] Database made comment insertion in response to %s WITHOUT a parent comment\n", comment.InResponseTo)
+		}
+
+		_, err := tx.ExecContext(ctx, "UPDATE submissions SET comment_count = comment_count + 1 WHERE id = $1", comment.InResponseTo)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// get the comments on a post, plus if the user has voted on the comments
+func (s *PostgresStore) GetCommentsOnSubmission(ctx context.Context, submission Submission, contextUser User) ([]Comment, error) {
+	if submission.Id == "" {
+		return nil, newError("GetCommentsOnSubmission", ErrInvalidInput, "please use an ID when searching for a submission's comments")
+	}
+
+	query := `
+		SELECT
+			c.id,
+			c.in_response_to,
+			c.content,
+			c.author,
+			c.parent_comment,
+			c.flagged,
+			c.created_at,
+			c.upvotes,
+			c.downvotes,
+			c.comment_count,
+
+			-- only joins the viewer's own vote, so no vote (or no viewer) means FALSE for both
+			COALESCE(cv.positive = TRUE, FALSE) AS has_upvoted,
+			COALESCE(cv.positive = FALSE, FALSE) AS has_downvoted
+		FROM comments c
+		LEFT JOIN comment_votes cv ON c.id = cv.comment_id AND cv.voter_username = $2
+		WHERE c.in_response_to = $1
+		ORDER BY c.created_at DESC;
+	`
+
+	// no limits/offset here at the moment, do this in a future update
+	rows, err := s.db.QueryContext(ctx, query, submission.Id, contextUser.Username)
+	if err != nil {
+		return nil, wrapError("GetCommentsOnSubmission", err)
+	}
+	defer rows.Close()
+
+	var commentHolder []Comment
+
+	for rows.Next() {
+		// the following fields may be NULL:
+		var parentComment sql.NullString
+
+		var tempComment Comment
+		err := rows.Scan(&tempComment.Id, &tempComment.InResponseTo, &tempComment.Content, &tempComment.Author, &parentComment, &tempComment.Flagged, &tempComment.CreatedAt, &tempComment.Upvotes, &tempComment.Downvotes, &tempComment.ReplyCount, &tempComment.HasUpvoted, &tempComment.HasDownvoted)
+		if err != nil {
+			return nil, wrapError("GetCommentsOnSubmission", err)
+		}
+
+		tempComment.ParentComment = parentComment.String
+
+		commentHolder = append(commentHolder, tempComment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("GetCommentsOnSubmission", err)
+	}
+
+	return commentHolder, nil
+}
+
+// threaded version of GetCommentsOnSubmission, replies are nested under their parent and sorted by score
+// the recursive part walks down from the starting point (top level, or the comment in the cursor) to MaxDepth,
+// only taking the best Page.Limit + 1 replies of each comment as it goes (+1 so buildTree can tell whether there are more),
+// so a big thread costs what's shown rather than the whole subtree (0012 migration has the indexes for it)
+func (s *PostgresStore) CommentTree(ctx context.Context, submission Submission, contextUser User, opts TreeOptions) ([]CommentNode, string, error) {
+	if submission.Id == "" {
+		return nil, "", newError("CommentTree", ErrInvalidInput, "please use an ID when searching for a submission's comments")
+	}
+
+	limit, err := opts.validate("CommentTree")
+	if err != nil {
+		return nil, "", err
+	}
+
+	from, err := decodeThreadCursor("CommentTree", opts.Page.Cursor, submission.Id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	args := []any{submission.Id, sql.NullString{String: from.Parent, Valid: from.Parent != ""}, opts.MaxDepth, contextUser.Username, limit + 1}
+
+	seek := ""
+	if from.Id != "" {
+		seek = "AND (c.score, c.created_at, c.id) < ($6, $7::timestamp, $8::uuid)"
+		args = append(args, from.Score, from.CreatedAt, from.Id)
+	}
+
+	query := `
+		WITH RECURSIVE thread AS (
+			(
+				SELECT c.id, 1 AS depth
+				FROM comments c
+				WHERE c.in_response_to = $1
+					AND c.parent_comment IS NOT DISTINCT FROM $2::uuid
+					` + seek + `
+				ORDER BY c.score DESC, c.created_at DESC, c.id DESC
+				LIMIT $5
+			)
+
+			UNION ALL
+
+			SELECT reply.id, t.depth + 1
+			FROM thread t
+			CROSS JOIN LATERAL (
+				SELECT c.id
+				FROM comments c
+				WHERE c.parent_comment = t.id
+				ORDER BY c.score DESC, c.created_at DESC, c.id DESC
+				LIMIT $5
+			) reply
+			WHERE t.depth < $3
+		)
+		SELECT
+			c.id,
+			c.in_response_to,
+			c.content,
+			c.author,
+			c.parent_comment,
+			c.flagged,
+			c.created_at,
+			c.upvotes,
+			c.downvotes,
+			c.comment_count,
+			COALESCE(cv.positive = TRUE, FALSE) AS has_upvoted,
+			COALESCE(cv.positive = FALSE, FALSE) AS has_downvoted
+		FROM thread t
+		JOIN comments c ON c.id = t.id
+		LEFT JOIN comment_votes cv ON c.id = cv.comment_id AND cv.voter_username = $4
+		ORDER BY c.score DESC, c.created_at DESC, c.id DESC;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("CommentTree", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var parentComment sql.NullString
+
+		var tempComment Comment
+		err := rows.Scan(&tempComment.Id, &tempComment.InResponseTo, &tempComment.Content, &tempComment.Author, &parentComment, &tempComment.Flagged, &tempComment.CreatedAt, &tempComment.Upvotes, &tempComment.Downvotes, &tempComment.ReplyCount, &tempComment.HasUpvoted, &tempComment.HasDownvoted)
+		if err != nil {
+			return nil, "", wrapError("CommentTree", err)
+		}
+
+		tempComment.ParentComment = parentComment.String
+		comments = append(comments, tempComment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("CommentTree", err)
+	}
+
+	tree, more := buildTree(comments, from, limit, opts.MaxDepth)
+	return tree, more, nil
+}
+
+// ErrNotFound if there was no such comment to delete
+func (s *PostgresStore) DeleteComment(ctx context.Context, comment Comment) error {
+	if comment.Id == "" {
+		return newError("DeleteComment", ErrInvalidInput, "please provide a comment ID to delete a comment")
+	}
+
+	return s.inTx(ctx, "DeleteComment", func(tx *sql.Tx) error {
+		var submissionId string
+		var parentComment sql.NullString
+		err := tx.QueryRowContext(ctx, "DELETE FROM comments WHERE id = $1 RETURNING in_response_to, parent_comment", comment.Id).Scan(&submissionId, &parentComment)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE submissions SET comment_count = comment_count - 1 WHERE id = $1", submissionId); err != nil {
+			return err
+		}
+
+		if parentComment.Valid {
+			if _, err := tx.ExecContext(ctx, "UPDATE comments SET comment_count = comment_count - 1 WHERE id = $1", parentComment.String); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("[INFO] Deleted comment ID %s\n", comment.Id)
+		return nil
+	})
+}
+
+func (s *PostgresStore) VoteOnComment(ctx context.Context, user User, comment Comment, isUpvote bool) (VoteResult, error) {
+	// check that we have a valid username + comment id combo
+	if user.Username == "" || comment.Id == "" {
+		return VoteResult{}, newError("VoteOnComment", ErrInvalidInput, "username or comment id is blank (required to vote on a comment)")
+	}
+
+	var result VoteResult
+	err := s.inTx(ctx, "VoteOnComment", func(tx *sql.Tx) error {
+		var err error
+		result, err = castVote(ctx, tx, commentVoteTable, comment.Id, user.Username, isUpvote)
+		return err
+	})
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	voteType := "downvote"
+	if isUpvote {
+		voteType = "upvote"
+	}
+
+	if result.Changed {
+		log.Printf("[INFO] Recorded %s on comment ID %s from %s, score is now %d\n", voteType, comment.Id, user.Username, result.Score)
+	} else {
+		log.Printf("[INFO] Double vote attempted on comment by %s\n", user.Username)
+	}
+
+	return result, nil
+}
+
+// same as Unvote, for comments
+func (s *PostgresStore) UnvoteComment(ctx context.Context, user User, comment Comment) (VoteResult, error) {
+	if user.Username == "" || comment.Id == "" {
+		return VoteResult{}, newError("UnvoteComment", ErrInvalidInput, "username or comment id is blank (required to remove a vote)")
+	}
+
+	var result VoteResult
+	err := s.inTx(ctx, "UnvoteComment", func(tx *sql.Tx) error {
+		var err error
+		result, err = retractVote(ctx, tx, commentVoteTable, comment.Id, user.Username)
+		return err
+	})
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	log.Printf("[INFO] Unvote on comment ID %s from %s (removed: %t), score is now %d\n", comment.Id, user.Username, result.Changed, result.Score)
+
+	return result, nil
+}
+
+const apiKeyColumns = `id, username, name, prefix, scopes, created_at, expires_at, last_used`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var scopes []string
+	var expiresAt, lastUsed sql.NullString
+	if err := row.Scan(&key.Id, &key.Username, &key.Name, &key.Prefix, pq.Array(&scopes), &key.CreatedAt, &expiresAt, &lastUsed); err != nil {
+		return APIKey{}, err
+	}
+
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, Scope(scope))
+	}
+	key.ExpiresAt = expiresAt.String
+	key.LastUsed = lastUsed.String
+	return key, nil
+}
+
+// inserts a fresh key inside tx, the plaintext is only ever returned from here
+func insertAPIKey(ctx context.Context, tx *sql.Tx, username string, name string, scopes []string, expiresAt any) (APIKey, string, error) {
+	token, prefix, hash := newAPIKey()
+
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO api_keys (username, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+apiKeyColumns,
+		username, name, prefix, hash, pq.Array(scopes), expiresAt,
+	)
+	key, err := scanAPIKey(row)
+	return key, token, err
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, user User, opts APIKeyOptions) (APIKey, string, error) {
+	if user.Username == "" {
+		return APIKey{}, "", newError("CreateAPIKey", ErrInvalidInput, "cannot create API key for a blank user")
+	}
+	if err := opts.validate("CreateAPIKey", time.Now().UTC()); err != nil {
+		return APIKey{}, "", err
+	}
+
+	var scopes []string
+	for _, scope := range opts.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	var expiresAt any
+	if !opts.ExpiresAt.IsZero() {
+		expiresAt = opts.ExpiresAt.UTC()
+	}
+
+	var key APIKey
+	var token string
+	err := s.inTx(ctx, "CreateAPIKey", func(tx *sql.Tx) error {
+		// locking the user makes two creates at once count each other, and ErrNotFound falls out for a missing user
+		var exists int
+		if err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, user.Username).Scan(&exists); err != nil {
+			return err
+		}
+
+		var active int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM api_keys WHERE username = $1 AND revoked_at IS NULL`, user.Username).Scan(&active); err != nil {
+			return err
+		}
+		if active >= maxAPIKeys {
+			return newError("CreateAPIKey", ErrConflict, "user %s already has %d API keys, revoke one first", user.Username, maxAPIKeys)
+		}
+
+		var err error
+		key, token, err = insertAPIKey(ctx, tx, user.Username, opts.Name, scopes, expiresAt)
+		return err
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	log.Printf("[INFO] Created API key %s (%s) for user %s\n", key.Prefix, key.Name, user.Username)
+	return key, token, nil
+}
+
+// ErrNotFound for unknown, revoked and expired keys alike, otherwise the key's last_used is bumped to now
+func (s *PostgresStore) ValidateAPIKey(ctx context.Context, token string) (APIKey, error) {
+	if token == "" {
+		return APIKey{}, newError("ValidateAPIKey", ErrInvalidInput, "cannot validate blank API key")
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE api_keys SET last_used = NOW()
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING `+apiKeyColumns, hashToken(token))
+
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("[WARN] API key of length %d did not match any live key\n", len(token))
+		}
+		return APIKey{}, wrapError("ValidateAPIKey", err)
+	}
+
+	return key, nil
+}
+
+// live keys only (expired ones included, revoked ones not), newest first
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, user User) ([]APIKey, error) {
+	if user.Username == "" {
+		return nil, newError("ListAPIKeys", ErrInvalidInput, "cannot list API keys of a blank user")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE username = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC, id DESC`, user.Username)
+	if err != nil {
+		return nil, wrapError("ListAPIKeys", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, wrapError("ListAPIKeys", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, wrapError("ListAPIKeys", rows.Err())
+}
+
+// revokes the key and issues a new one with the same name, scopes and expiry
+// ErrNotFound unless the key is the user's and neither revoked nor expired, an expired one would hand back a dead key
+func (s *PostgresStore) RotateAPIKey(ctx context.Context, user User, id string) (APIKey, string, error) {
+	if user.Username == "" || id == "" {
+		return APIKey{}, "", newError("RotateAPIKey", ErrInvalidInput, "username and key id are required")
+	}
+
+	var key APIKey
+	var token string
+	err := s.inTx(ctx, "RotateAPIKey", func(tx *sql.Tx) error {
+		var name string
+		var scopes []string
+		var expiresAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `
+			UPDATE api_keys SET revoked_at = NOW()
+			WHERE id = $1 AND username = $2 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+			RETURNING name, scopes, expires_at`, id, user.Username,
+		).Scan(&name, pq.Array(&scopes), &expiresAt)
+		if err != nil {
+			return err
+		}
+
+		var expires any
+		if expiresAt.Valid {
+			expires = expiresAt.Time
+		}
+		key, token, err = insertAPIKey(ctx, tx, user.Username, name, scopes, expires)
+		return err
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	log.Printf("[INFO] Rotated API key %s for user %s, new key is %s\n", id, user.Username, key.Id)
+	return key, token, nil
+}
+
+// ErrNotFound unless the key is the user's and not already revoked
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, user User, id string) error {
+	if user.Username == "" || id == "" {
+		return newError("RevokeAPIKey", ErrInvalidInput, "username and key id are required")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND username = $2 AND revoked_at IS NULL`, id, user.Username)
+	if err != nil {
+		return wrapError("RevokeAPIKey", err)
+	}
+
+	return expectAffected("RevokeAPIKey", res)
+}
+
+const sessionColumns = `id, username, ip, user_agent, created_at, last_seen, expires_at`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var session Session
+	err := row.Scan(&session.Id, &session.Username, &session.Ip, &session.UserAgent, &session.CreatedAt, &session.LastSeen, &session.ExpiresAt)
+	return session, err
+}
+
+func (s *PostgresStore) CreateSession(ctx context.Context, user User, ip string, userAgent string, expiresAt time.Time) (Session, string, error) {
+	if user.Username == "" || ip == "" {
+		return Session{}, "", newError("CreateSession", ErrInvalidInput, "username and IP are required")
+	}
+
+	// ErrNotFound falls straight through for a non-existant user
+	if _, err := s.SearchUser(ctx, user); err != nil {
+		return Session{}, "", wrapError("CreateSession", err)
+	}
+
+	token, hash := newRefreshToken()
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO sessions (username, refresh_hash, ip, user_agent, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING `+sessionColumns,
+		user.Username, hash, ip, userAgent, expiresAt.UTC(),
+	)
+	session, err := scanSession(row)
+	if err != nil {
+		return Session{}, "", wrapError("CreateSession", err)
+	}
+
+	log.Printf("[INFO] Started session %s for user %s\n", session.Id, user.Username)
+	return session, token, nil
+}
+
+// swaps the refresh token for a new one, ErrNotFound for unknown, expired and revoked tokens
+// a token that was already swapped out means it was copied, so the session is revoked
+func (s *PostgresStore) RefreshSession(ctx context.Context, refreshToken string, ip string, userAgent string, expiresAt time.Time) (Session, string, error) {
+	if refreshToken == "" {
+		return Session{}, "", newError("RefreshSession", ErrInvalidInput, "cannot refresh with a blank token")
+	}
+
+	hash := hashToken(refreshToken)
+	token, newHash := newRefreshToken()
+
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE sessions SET refresh_hash = $2, previous_hash = refresh_hash, ip = $3, user_agent = $4, last_seen = NOW(), expires_at = $5
+		WHERE refresh_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING `+sessionColumns, hash, newHash, ip, userAgent, expiresAt.UTC())
+	session, err := scanSession(row)
+	if err == nil {
+		return session, token, nil
+	}
+	if err != sql.ErrNoRows {
+		return Session{}, "", wrapError("RefreshSession", err)
+	}
+
+	// not current, but if it's the one before then someone else already refreshed with it
+	var reused string
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE previous_hash = $1 AND revoked_at IS NULL
+		RETURNING id`, hash).Scan(&reused)
+	if err == nil {
+		log.Printf("[WARN] Refresh token for session %s was used twice, revoked the session\n", reused)
+		return Session{}, "", newError("RefreshSession", ErrNotFound, "refresh token was already used, session %s revoked", reused)
+	}
+	if err != sql.ErrNoRows {
+		return Session{}, "", wrapError("RefreshSession", err)
+	}
+
+	return Session{}, "", newError("RefreshSession", ErrNotFound, "no such session")
+}
+
+// ErrNotFound unless the session is live, otherwise last_seen is bumped to now
+func (s *PostgresStore) TouchSession(ctx context.Context, id string, ip string) (Session, error) {
+	if id == "" {
+		return Session{}, newError("TouchSession", ErrInvalidInput, "blank session id")
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE sessions SET last_seen = NOW(), ip = COALESCE(NULLIF($2, ''), ip)
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING `+sessionColumns, id, ip)
+	session, err := scanSession(row)
+	if err != nil {
+		return Session{}, wrapError("TouchSession", err)
+	}
+
+	return session, nil
+}
+
+// live sessions, most recently seen first
+func (s *PostgresStore) ListSessions(ctx context.Context, user User) ([]Session, error) {
+	if user.Username == "" {
+		return nil, newError("ListSessions", ErrInvalidInput, "cannot list sessions of a blank user")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE username = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen DESC, id DESC`, user.Username)
+	if err != nil {
+		return nil, wrapError("ListSessions", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, wrapError("ListSessions", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, wrapError("ListSessions", rows.Err())
+}
+
+// ErrNotFound unless the session is the user's and still live
+func (s *PostgresStore) RevokeSession(ctx context.Context, user User, id string) error {
+	if user.Username == "" || id == "" {
+		return newError("RevokeSession", ErrInvalidInput, "username and session id are required")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND username = $2 AND revoked_at IS NULL AND expires_at > NOW()`, id, user.Username)
+	if err != nil {
+		return wrapError("RevokeSession", err)
+	}
+
+	return expectAffected("RevokeSession", res)
+}
+
+// every live session the user has, returns how many there were
+func (s *PostgresStore) RevokeAllSessions(ctx context.Context, user User) (int, error) {
+	if user.Username == "" {
+		return 0, newError("RevokeAllSessions", ErrInvalidInput, "blank username")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE username = $1 AND revoked_at IS NULL AND expires_at > NOW()`, user.Username)
+	if err != nil {
+		return 0, wrapError("RevokeAllSessions", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError("RevokeAllSessions", err)
+	}
+
+	log.Printf("[INFO] Revoked %d sessions for user %s\n", n, user.Username)
+	return int(n), nil
+}
+
+// ErrNotFound if no comment has the ID
+func (s *PostgresStore) SearchComment(ctx context.Context, comment Comment) (Comment, error) {
+	if comment.Id == "" {
+		return Comment{}, newError("SearchComment", ErrInvalidInput, "please use an ID when searching for a comment")
+	}
+
+	var parentComment sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, in_response_to, content, author, parent_comment, flagged, created_at FROM comments WHERE id = $1", comment.Id,
+	).Scan(&comment.Id, &comment.InResponseTo, &comment.Content, &comment.Author, &parentComment, &comment.Flagged, &comment.CreatedAt)
+	if err != nil {
+		return Comment{}, wrapError("SearchComment", err)
+	}
+
+	comment.ParentComment = parentComment.String
+
+	log.Printf("[INFO] Successful query for comment %s created at %s", comment.Id, comment.CreatedAt)
+
+	return comment, nil
+}
+
+func (s *PostgresStore) markSubmissionAsFlagged(ctx context.Context, submission Submission) error {
+	if submission.Id == "" {
+		return newError("markSubmissionAsFlagged", ErrInvalidInput, "cannot flag submission without an ID")
+	}
+
+	_, err := s.db.ExecContext(ctx, "UPDATE submissions SET flagged = true WHERE id = $1", submission.Id)
+	if err != nil {
+		return wrapError("markSubmissionAsFlagged", err)
+	}
+
+	log.Printf("[INFO] Flagged submission %s\n", submission.Id)
+	return nil
+}
+
+func (s *PostgresStore) markCommentAsFlagged(ctx context.Context, comment Comment) error {
+	if comment.Id == "" {
+		return newError("markCommentAsFlagged", ErrInvalidInput, "cannot flag comment without an ID")
+	}
+
+	_, err := s.db.ExecContext(ctx, "UPDATE comments SET flagged = true WHERE id = $1", comment.Id)
+	if err != nil {
+		return wrapError("markCommentAsFlagged", err)
+	}
+
+	log.Printf("[INFO] Flagged comment %s\n", comment.Id)
+	return nil
+}
+
+func (s *PostgresStore) hasUserReported(ctx context.Context, id string, user User) (bool, error) {
+	if id == "" || user.Username == "" {
+		return false, newError("hasUserReported", ErrInvalidInput, "cannot check report status for blank ID or blank username")
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM reports WHERE reporter = $1 AND target_id = $2)", user.Username, id).Scan(&exists)
+	if err != nil {
+		return false, wrapError("hasUserReported", err)
+	}
+
+	return exists, nil
+}
+
+// returns -> (total reporting weight, has been flagged following this report, error if present)
+func (s *PostgresStore) ReportSubmission(ctx context.Context, user User, submission Submission) (float64, bool, error) {
+	// check that submission exists
+	squery, err := s.SearchSubmission(ctx, submission)
+	if err != nil {
+		return 0.0, false, wrapError("ReportSubmission", err)
+	}
+
+	// check that submission hasn't already been flagged
+	if squery.Flagged {
+		return 0.0, false, newError("ReportSubmission", ErrConflict, "submission %s is already flagged, cannot report", submission.Id)
+	}
+
+	// check that the user hasn't already reported
+	reported, err := s.hasUserReported(ctx, submission.Id, user)
+	if err != nil {
+		return 0.0, false, wrapError("ReportSubmission", err)
+	}
+	if reported {
+		return 0.0, false, newError("ReportSubmission", ErrConflict, "unable to flag, user has already flagged")
+	}
+
+	weight, err := s.calculateReportWeight(ctx, user)
+	if err != nil {
+		return 0.0, false, wrapError("ReportSubmission", err)
+	}
+
+	// insert the report
+	query := `INSERT INTO reports (reporter, target_type, target_id, target_user, rweight) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = s.db.ExecContext(ctx, query, user.Username, "submission", submission.Id, squery.Username, weight)
+	if err != nil {
+		return 0.0, false, wrapError("ReportSubmission", err)
+	}
+
+	// now check if the total weight is enough for a flagging
+	totalWeight, err := s.getTotalReportsWeight(ctx, submission.Id)
+	if err != nil {
+		return 0.0, false, wrapError("ReportSubmission", err)
+	}
+
+	var wasFlagged bool = false
+	if totalWeight >= 1.0 {
+		if err := s.markSubmissionAsFlagged(ctx, submission); err != nil {
+			return 0.0, false, wrapError("ReportSubmission", err)
+		}
+		wasFlagged = true
+	}
+
+	return totalWeight, wasFlagged, nil
+}
+
+// returns -> (total reporting weight, has been flagged following this report, error if present)
+func (s *PostgresStore) ReportComment(ctx context.Context, comment Comment, user User) (float64, bool, error) {
+	// check that comment exists
+	query, err := s.SearchComment(ctx, comment)
+	if err != nil {
+		return 0.0, false, wrapError("ReportComment", err)
+	}
+
+	// check that comment hasn't already been flagged
+	if query.Flagged {
+		return 0.0, false, newError("ReportComment", ErrConflict, "comment %s is already flagged; cannot report", comment.Id)
+	}
+
+	// check that the user hasn't already reported
+	reported, err := s.hasUserReported(ctx, comment.Id, user)
+	if err != nil {
+		return 0.0, false, wrapError("ReportComment", err)
+	}
+	if reported {
+		return 0.0, false, newError("ReportComment", ErrConflict, "unable to flag, user has already flagged")
+	}
+
+	weight, err := s.calculateReportWeight(ctx, user)
+	if err != nil {
+		return 0.0, false, wrapError("ReportComment", err)
+	}
+
+	// insert the report
+	q := `INSERT INTO reports (reporter, target_type, target_id, target_user, rweight) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = s.db.ExecContext(ctx, q, user.Username, "comment", comment.Id, query.Author, weight)
+	if err != nil {
+		return 0.0, false, wrapError("ReportComment", err)
+	}
+
+	// now check if the total weight is enough for a flagging
+	totalWeight, err := s.getTotalReportsWeight(ctx, comment.Id)
+	if err != nil {
+		return 0.0, false, wrapError("ReportComment", err)
+	}
+
+	var wasFlagged bool = false
+	if totalWeight >= 1.0 {
+		if err := s.markCommentAsFlagged(ctx, comment); err != nil {
+			return 0.0, false, wrapError("ReportComment", err)
+		}
+		wasFlagged = true
+	}
+
+	return totalWeight, wasFlagged, nil
+}
+
+// calculates how much a user's report should count based off
+func (s *PostgresStore) calculateReportWeight(ctx context.Context, user User) (float64, error) {
+	var days int
+	err := s.db.QueryRowContext(ctx, "SELECT EXTRACT(DAY FROM age(NOW(), created_at)) AS days_old FROM users WHERE username=$1", user.Username).Scan(&days)
+	if err != nil {
+		return 0.0, wrapError("calculateReportWeight", err)
+	}
+
+	return reportWeightForAge(days), nil
+}
+
+// whole days since users.created_at, for GetReputation
+// age() would split it into years, months and days, and EXTRACT(DAY ...) of that is only the days part
+// calculateReportWeight still uses that, changing how reports are weighted is its own change
+const accountAgeDays = "EXTRACT(DAY FROM NOW() - users.created_at)::int"
+
+func (s *PostgresStore) GetReputation(ctx context.Context, user User) (Reputation, error) {
+	if user.Username == "" {
+		return Reputation{}, newError("GetReputation", ErrInvalidInput, "username cannot be blank when checking reputation")
+	}
+
+	query := `
+		SELECT ` + accountAgeDays + `,
+			COALESCE((SELECT SUM(score) FROM submissions WHERE username = users.username), 0),
+			COALESCE((SELECT SUM(rweight) FROM reports WHERE target_user = users.username), 0.0),
+			(SELECT COUNT(*) FROM submissions WHERE username = users.username AND flagged)
+				+ (SELECT COUNT(*) FROM comments WHERE author = users.username AND flagged)
+		FROM users
+		WHERE users.username = $1
+	`
+
+	var reputation Reputation
+	err := s.db.QueryRowContext(ctx, query, user.Username).Scan(&reputation.AgeDays, &reputation.Karma, &reputation.ReportWeight, &reputation.Flagged)
+	if err != nil {
+		return Reputation{}, wrapError("GetReputation", err)
+	}
+
+	return reputation, nil
+}
+
+func (s *PostgresStore) getTotalReportsWeight(ctx context.Context, id string) (float64, error) {
+
+	query := `
+		SELECT COALESCE(SUM(rweight), 0.0)
+		FROM reports
+		WHERE target_id = $1
+	`
+
+	var weight float64
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&weight)
+	if err != nil {
+		return 0.0, wrapError("getTotalReportsWeight", err)
+	}
+
+	return weight, nil
+}
+
+func (s *PostgresStore) SelectAllReportsFromUser(ctx context.Context, page Page, user User) ([]Report, string, error) {
+	if user.Username == "" {
+		return nil, "", newError("SelectAllReportsFromUser", ErrInvalidInput, "username cannot be blank when selecting reports from user")
+	}
+
+	after, err := decodeCursor("SelectAllReportsFromUser", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("SelectAllReportsFromUser")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{user.Username, limit + 1}
+	seek := ""
+	if after != nil {
+		seek = "AND (created_at, id) < ($3, $4::integer)"
+		args = append(args, after.CreatedAt, after.Id)
+	}
+
+	query := `
+		SELECT id, reporter, target_type, target_id, target_user, rweight, created_at
+		FROM reports
+		WHERE reporter = $1
+		` + seek + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("SelectAllReportsFromUser", err)
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var current Report
+
+		if err := rows.Scan(&current.Id, &current.Reporter, &current.Target_type, &current.Target_id, &current.Target_user, &current.Target_weight, &current.Created_at); err != nil {
+			return nil, "", wrapError("SelectAllReportsFromUser", err)
+		}
+
+		reports = append(reports, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("SelectAllReportsFromUser", err)
+	}
+
+	reports, next := paginate(reports, limit, func(last Report) cursor {
+		return cursor{CreatedAt: last.Created_at, Id: last.Id}
+	})
+
+	log.Printf("[INFO] Reports query for user %s resulted in %d reports, using a limit of %d\n", user.Username, len(reports), limit)
+
+	return reports, next, nil
+}
+
+func (s *PostgresStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	if err := checkAuditEvent("RecordAudit", entry.Event); err != nil {
+		return err
+	}
+
+	// no metadata is stored as NULL rather than an empty object
+	var metadata []byte
+	if len(entry.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(entry.Metadata); err != nil {
+			return &Error{Op: "RecordAudit", Kind: ErrInvalidInput, Err: err}
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO audit_log (username, event_type, metadata, ip) VALUES (NULLIF($1, ''), $2, $3, $4)",
+		entry.Username, entry.Event, metadata, entry.Ip)
+	if err != nil {
+		return wrapError("RecordAudit", err)
+	}
+
+	return nil
+}
+
+// newest first
+func (s *PostgresStore) AuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, string, error) {
+	if err := filter.validate("AuditLog"); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodeCursor("AuditLog", page.Cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err := page.size("AuditLog")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []any{limit + 1}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"TRUE"}
+	if filter.Username != "" {
+		where = append(where, "username = "+arg(filter.Username))
+	}
+	if filter.Event != "" {
+		where = append(where, "event_type = "+arg(filter.Event))
+	}
+	if !filter.After.IsZero() {
+		where = append(where, "ts >= "+arg(formatTime(filter.After))+"::timestamp")
+	}
+	if !filter.Before.IsZero() {
+		where = append(where, "ts < "+arg(formatTime(filter.Before))+"::timestamp")
+	}
+	if after != nil {
+		where = append(where, "(ts, id) < ("+arg(after.CreatedAt)+"::timestamp, "+arg(after.Id)+"::bigint)")
+	}
+
+	query := `
+		SELECT id, COALESCE(username, ''), event_type, metadata, ip, ts
+		FROM audit_log
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ts DESC, id DESC
+		LIMIT $1
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", wrapError("AuditLog", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var current AuditEntry
+		var metadata []byte
+
+		if err := rows.Scan(&current.Id, &current.Username, &current.Event, &metadata, &current.Ip, &current.Timestamp); err != nil {
+			return nil, "", wrapError("AuditLog", err)
+		}
+
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &current.Metadata); err != nil {
+				return nil, "", wrapError("AuditLog", err)
+			}
+		}
+
+		entries = append(entries, current)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", wrapError("AuditLog", err)
+	}
+
+	entries, next := paginate(entries, limit, func(last AuditEntry) cursor {
+		return cursor{CreatedAt: last.Timestamp, Id: last.Id}
+	})
+
+	return entries, next, nil
+}
+
+// the user's passkey handle, made the first time it's asked for
+// ErrNotFound for a user that doesn't exist
+func (s *PostgresStore) PasskeyHandle(ctx context.Context, user User) ([]byte, error) {
+	if user.Username == "" {
+		return nil, newError("PasskeyHandle", ErrInvalidInput, "cannot get the passkey handle of a blank user")
+	}
+
+	var handle []byte
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE users SET passkey_handle = COALESCE(passkey_handle, $2)
+		WHERE username = $1
+		RETURNING passkey_handle`, user.Username, newPasskeyHandle()).Scan(&handle)
+	if err != nil {
+		return nil, wrapError("PasskeyHandle", err)
+	}
+
+	return handle, nil
+}
+
+// username behind a passkey handle, ErrNotFound if nobody has it
+func (s *PostgresStore) PasskeyOwner(ctx context.Context, handle []byte) (string, error) {
+	if len(handle) == 0 {
+		return "", newError("PasskeyOwner", ErrInvalidInput, "blank passkey handle")
+	}
+
+	var username string
+	if err := s.db.QueryRowContext(ctx, `SELECT username FROM users WHERE passkey_handle = $1`, handle).Scan(&username); err != nil {
+		return "", wrapError("PasskeyOwner", err)
+	}
+
+	return username, nil
+}
+
+const passkeyColumns = `id, username, name, credential_id, credential, created_at, last_used`
+
+func scanPasskey(row interface{ Scan(...any) error }) (Passkey, error) {
+	var passkey Passkey
+	var lastUsed sql.NullString
+	if err := row.Scan(&passkey.Id, &passkey.Username, &passkey.Name, &passkey.CredentialId, &passkey.Credential, &passkey.CreatedAt, &lastUsed); err != nil {
+		return Passkey{}, err
+	}
+
+	passkey.LastUsed = lastUsed.String
+	return passkey, nil
+}
+
+// ErrConflict if the credential is already registered, or the user has maxPasskeys already
+func (s *PostgresStore) CreatePasskey(ctx context.Context, user User, name string, credentialId []byte, credential []byte) (Passkey, error) {
+	if user.Username == "" || len(credentialId) == 0 || len(credential) == 0 {
+		return Passkey{}, newError("CreatePasskey", ErrInvalidInput, "username, credential id and credential are required")
+	}
+	if !validPasskeyName(name) {
+		return Passkey{}, newError("CreatePasskey", ErrInvalidInput, "passkey name must be between 1 and 100 characters")
+	}
+
+	var passkey Passkey
+	err := s.inTx(ctx, "CreatePasskey", func(tx *sql.Tx) error {
+		// same as CreateAPIKey, the lock makes two at once count each other
+		var exists int
+		if err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, user.Username).Scan(&exists); err != nil {
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM passkeys WHERE username = $1`, user.Username).Scan(&count); err != nil {
+			return err
+		}
+		if count >= maxPasskeys {
+			return newError("CreatePasskey", ErrConflict, "user %s already has %d passkeys, remove one first", user.Username, maxPasskeys)
+		}
+
+		var err error
+		passkey, err = scanPasskey(tx.QueryRowContext(ctx,
+			`INSERT INTO passkeys (username, name, credential_id, credential) VALUES ($1, $2, $3, $4) RETURNING `+passkeyColumns,
+			user.Username, name, credentialId, credential,
+		))
+		return err
+	})
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	log.Printf("[INFO] Added passkey %s for user %s\n", passkey.Id, user.Username)
+	return passkey, nil
+}
+
+// saves the credential as it is after signing in with it (the sign count moves) and marks it used
+// ErrNotFound if the credential isn't registered
+func (s *PostgresStore) UsePasskey(ctx context.Context, credentialId []byte, credential []byte) (Passkey, error) {
+	if len(credentialId) == 0 || len(credential) == 0 {
+		return Passkey{}, newError("UsePasskey", ErrInvalidInput, "credential id and credential are required")
+	}
+
+	passkey, err := scanPasskey(s.db.QueryRowContext(ctx, `
+		UPDATE passkeys SET credential = $2, last_used = NOW()
+		WHERE credential_id = $1
+		RETURNING `+passkeyColumns, credentialId, credential))
+	if err != nil {
+		return Passkey{}, wrapError("UsePasskey", err)
+	}
+
+	return passkey, nil
+}
+
+// oldest first
+func (s *PostgresStore) ListPasskeys(ctx context.Context, user User) ([]Passkey, error) {
+	if user.Username == "" {
+		return nil, newError("ListPasskeys", ErrInvalidInput, "cannot list passkeys of a blank user")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+passkeyColumns+` FROM passkeys
+		WHERE username = $1
+		ORDER BY created_at, id`, user.Username)
+	if err != nil {
+		return nil, wrapError("ListPasskeys", err)
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, wrapError("ListPasskeys", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	return passkeys, wrapError("ListPasskeys", rows.Err())
+}
+
+// ErrNotFound unless the passkey is the user's
+func (s *PostgresStore) DeletePasskey(ctx context.Context, user User, id string) error {
+	if user.Username == "" || id == "" {
+		return newError("DeletePasskey", ErrInvalidInput, "username and passkey id are required")
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM passkeys WHERE id = $1 AND username = $2`, id, user.Username)
+	if err != nil {
+		return wrapError("DeletePasskey", err)
+	}
+
+	return expectAffected("DeletePasskey", res)
+}
+
+// username is "" for a sign in
+func (s *PostgresStore) CreatePasskeyChallenge(ctx context.Context, username string, data []byte, expiresAt time.Time) (string, error) {
+	if len(data) == 0 {
+		return "", newError("CreatePasskeyChallenge", ErrInvalidInput, "challenge data is required")
+	}
+
+	var id string
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO passkey_challenges (username, data, expires_at) VALUES (NULLIF($1, ''), $2, $3) RETURNING id`,
+		username, data, expiresAt.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return "", wrapError("CreatePasskeyChallenge", err)
+	}
+
+	return id, nil
+}
+
+// each challenge can only be taken once, ErrNotFound if it's unknown, already taken or expired
+func (s *PostgresStore) TakePasskeyChallenge(ctx context.Context, id string) (PasskeyChallenge, error) {
+	if id == "" {
+		return PasskeyChallenge{}, newError("TakePasskeyChallenge", ErrInvalidInput, "blank challenge id")
+	}
+
+	challenge := PasskeyChallenge{Id: id}
+	var username sql.NullString
+	var expired bool
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM passkey_challenges WHERE id = $1
+		RETURNING username, data, expires_at <= NOW()`, id).Scan(&username, &challenge.Data, &expired)
+	if err != nil {
+		return PasskeyChallenge{}, wrapError("TakePasskeyChallenge", err)
+	}
+	if expired {
+		return PasskeyChallenge{}, newError("TakePasskeyChallenge", ErrNotFound, "passkey challenge has expired")
+	}
+
+	challenge.Username = username.String
+	return challenge, nil
+}
+
+// deletes ceremonies that were started and never finished, returns how many
+func (s *PostgresStore) PurgeExpiredPasskeyChallenges(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM passkey_challenges WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, wrapError("PurgeExpiredPasskeyChallenges", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError("PurgeExpiredPasskeyChallenges", err)
+	}
+	return int(n), nil
+}
+
+// ErrNotFound if the user has never set up two-factor
+func (s *PostgresStore) GetTwoFactor(ctx context.Context, user User) (TwoFactor, error) {
+	if user.Username == "" {
+		return TwoFactor{}, newError("GetTwoFactor", ErrInvalidInput, "cannot look up two-factor for a blank user")
+	}
+
+	var twoFactor TwoFactor
+	var enabledAt sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT secret, enabled_at, (SELECT COUNT(*) FROM recovery_codes WHERE username = $1)
+		FROM totp_secrets WHERE username = $1`, user.Username).Scan(&twoFactor.Secret, &enabledAt, &twoFactor.RecoveryCodesLeft)
+	if err != nil {
+		return TwoFactor{}, wrapError("GetTwoFactor", err)
+	}
+
+	twoFactor.Enabled = enabledAt.Valid
+	twoFactor.EnabledAt = enabledAt.String
+	return twoFactor, nil
+}
+
+// saves a new secret that isn't used until EnableTOTP, setting up again replaces one that was never enabled
+// ErrConflict if two-factor is already on
+func (s *PostgresStore) SetupTOTP(ctx context.Context, user User, secret string) error {
+	if user.Username == "" || secret == "" {
+		return newError("SetupTOTP", ErrInvalidInput, "username and secret are required")
+	}
+
+	var ok int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO totp_secrets (username, secret) VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE totp_secrets.enabled_at IS NULL
+		RETURNING 1`, user.Username, secret).Scan(&ok)
+	if err == sql.ErrNoRows {
+		return newError("SetupTOTP", ErrConflict, "two-factor is already on for %s", user.Username)
+	}
+	if err != nil {
+		return wrapError("SetupTOTP", err)
+	}
+
+	return nil
+}
+
+// turns two-factor on once the user has typed in a code from step, and hands back their recovery codes
+// ErrNotFound if SetupTOTP hasn't been called, ErrConflict if it's already on
+func (s *PostgresStore) EnableTOTP(ctx context.Context, user User, step int64) ([]string, error) {
+	if user.Username == "" {
+		return nil, newError("EnableTOTP", ErrInvalidInput, "cannot enable two-factor for a blank user")
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err := s.inTx(ctx, "EnableTOTP", func(tx *sql.Tx) error {
+		var enabled bool
+		if err := tx.QueryRowContext(ctx, `SELECT enabled_at IS NOT NULL FROM totp_secrets WHERE username = $1 FOR UPDATE`, user.Username).Scan(&enabled); err != nil {
+			return err
+		}
+		if enabled {
+			return newError("EnableTOTP", ErrConflict, "two-factor is already on for %s", user.Username)
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE totp_secrets SET enabled_at = NOW(), last_step = $2 WHERE username = $1`, user.Username, step); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, user.Username, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] Two-factor turned on for user %s\n", user.Username)
+	return codes, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, username string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE username = $1`, username); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (username, code_hash) VALUES ($1, $2)`, username, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records that a code from step has been used, so it can't be used again
+// ErrConflict if step (or a later one) has been used already, or two-factor isn't on
+func (s *PostgresStore) UseTOTPStep(ctx context.Context, user User, step int64) error {
+	if user.Username == "" {
+		return newError("UseTOTPStep", ErrInvalidInput, "cannot use a code for a blank user")
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE totp_secrets SET last_step = $2
+		WHERE username = $1 AND enabled_at IS NOT NULL AND last_step < $2`, user.Username, step)
+	if err != nil {
+		return wrapError("UseTOTPStep", err)
+	}
+
+	if err := expectAffected("UseTOTPStep", res); err != nil {
+		return newError("UseTOTPStep", ErrConflict, "code has already been used")
+	}
+	return nil
+}
+
+// turns two-factor off and throws away the recovery codes, ErrNotFound if it was never set up
+func (s *PostgresStore) DisableTOTP(ctx context.Context, user User) error {
+	if user.Username == "" {
+		return newError("DisableTOTP", ErrInvalidInput, "cannot disable two-factor for a blank user")
+	}
+
+	err := s.inTx(ctx, "DisableTOTP", func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE username = $1`, user.Username); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM totp_secrets WHERE username = $1`, user.Username)
+		if err != nil {
+			return err
+		}
+		return expectAffected("DisableTOTP", res)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Two-factor turned off for user %s\n", user.Username)
+	return nil
+}
+
+// uses up one of the user's recovery codes, ErrNotFound if it isn't one of theirs or it's been used
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, user User, code string) error {
+	if user.Username == "" || code == "" {
+		return newError("UseRecoveryCode", ErrInvalidInput, "username and code are required")
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM recovery_codes WHERE username = $1 AND code_hash = $2`, user.Username, hashRecoveryCode(code))
+	if err != nil {
+		return wrapError("UseRecoveryCode", err)
+	}
+
+	return expectAffected("UseRecoveryCode", res)
+}
+
+// a new set of recovery codes, the old ones stop working, ErrNotFound unless two-factor is on
+func (s *PostgresStore) ReplaceRecoveryCodes(ctx context.Context, user User) ([]string, error) {
+	if user.Username == "" {
+		return nil, newError("ReplaceRecoveryCodes", ErrInvalidInput, "cannot replace recovery codes for a blank user")
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err := s.inTx(ctx, "ReplaceRecoveryCodes", func(tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRowContext(ctx, `SELECT 1 FROM totp_secrets WHERE username = $1 AND enabled_at IS NOT NULL FOR UPDATE`, user.Username).Scan(&exists); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, user.Username, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// a sign in waiting on a second factor, the token goes back to the client and is only stored hashed
+func (s *PostgresStore) CreateTwoFactorChallenge(ctx context.Context, user User, expiresAt time.Time) (string, error) {
+	if user.Username == "" {
+		return "", newError("CreateTwoFactorChallenge", ErrInvalidInput, "cannot create a two-factor challenge for a blank user")
+	}
+
+	token, hash := newRefreshToken()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO two_factor_challenges (token_hash, username, expires_at) VALUES ($1, $2, $3)`,
+		hash, user.Username, expiresAt.UTC(),
+	)
+	if err != nil {
+		return "", wrapError("CreateTwoFactorChallenge", err)
+	}
+
+	return token, nil
+}
+
+// who a challenge is for, ErrNotFound if it's unknown, finished, expired or out of attempts
+func (s *PostgresStore) GetTwoFactorChallenge(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", newError("GetTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	var username string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT username FROM two_factor_challenges WHERE token_hash = $1 AND expires_at > NOW()`,
+		hashToken(token),
+	).Scan(&username)
+	if err != nil {
+		return "", wrapError("GetTwoFactorChallenge", err)
+	}
+
+	return username, nil
+}
+
+// counts a wrong code against the challenge and returns how many tries are left, at 0 it's gone
+// ErrNotFound if it's unknown or expired
+func (s *PostgresStore) FailTwoFactorChallenge(ctx context.Context, token string) (int, error) {
+	if token == "" {
+		return 0, newError("FailTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	var attempts int
+	err := s.inTx(ctx, "FailTwoFactorChallenge", func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			UPDATE two_factor_challenges SET attempts = attempts + 1
+			WHERE token_hash = $1 AND expires_at > NOW()
+			RETURNING attempts`, hashToken(token)).Scan(&attempts)
+		if err != nil {
+			return err
+		}
+
+		if attempts >= maxTwoFactorAttempts {
+			_, err = tx.ExecContext(ctx, `DELETE FROM two_factor_challenges WHERE token_hash = $1`, hashToken(token))
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return max(maxTwoFactorAttempts-attempts, 0), nil
+}
+
+// ends the challenge once the second factor checks out and returns who it was for, a challenge only finishes once
+// ErrNotFound if it's unknown, already finished or expired
+func (s *PostgresStore) FinishTwoFactorChallenge(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", newError("FinishTwoFactorChallenge", ErrInvalidInput, "blank two-factor token")
+	}
+
+	var username string
+	var expired bool
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM two_factor_challenges WHERE token_hash = $1 RETURNING username, expires_at <= NOW()`,
+		hashToken(token),
+	).Scan(&username, &expired)
+	if err != nil {
+		return "", wrapError("FinishTwoFactorChallenge", err)
+	}
+	if expired {
+		return "", newError("FinishTwoFactorChallenge", ErrNotFound, "two-factor challenge has expired")
+	}
+
+	return username, nil
+}
+
+// deletes sign ins that never got their second factor, returns how many
+func (s *PostgresStore) PurgeExpiredTwoFactorChallenges(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM two_factor_challenges WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, wrapError("PurgeExpiredTwoFactorChallenges", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError("PurgeExpiredTwoFactorChallenges", err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"math"
+	"time"
+)
+
+// knobs for the Hot sort, which follows the Hacker News formula:
+//
+//	rank = points / (age in hours + 2) ^ gravity
+//
+// then multiplied by a penalty (0..1) for flagged posts and posts with few comments
+type RankingOptions struct {
+	Gravity             float64 // higher = old posts fall off the front page faster, HN uses 1.8
+	FlaggedPenalty      float64 // multiplier for flagged posts
+	LowCommentPenalty   float64 // multiplier for posts with fewer than LowCommentThreshold comments
+	LowCommentThreshold int
+}
+
+func DefaultRanking() RankingOptions {
+	return RankingOptions{
+		Gravity:             1.8,
+		FlaggedPenalty:      0.1,
+		LowCommentPenalty:   0.8,
+		LowCommentThreshold: 2,
+	}
+}
+
+// Go version of the ORDER BY expression in PostgresStore.AllSubmissions, keep them in sync
+//
+// points is the net vote score, posts start at 0 since the submitter doesn't get an automatic upvote
+func HotRank(points int, age time.Duration, comments int, flagged bool, opts RankingOptions) float64 {
+	hours := math.Max(age.Hours(), 0)
+	rank := float64(points) / math.Pow(hours+2, opts.Gravity)
+
+	penalty := 1.0
+	if flagged {
+		penalty *= opts.FlaggedPenalty
+	}
+	if comments < opts.LowCommentThreshold {
+		penalty *= opts.LowCommentPenalty
+	}
+
+	// a penalty should always push a post down, so for negative ranks divide instead
+	if rank < 0 && penalty > 0 {
+		return rank / penalty
+	}
+	return rank * penalty
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotRank(t *testing.T) {
+	opts := DefaultRanking()
+
+	fresh := HotRank(5, time.Hour, 10, false, opts)
+	stale := HotRank(5, 48*time.Hour, 10, false, opts)
+	assert.Greater(t, fresh, stale, "same points, older post ranks lower")
+
+	assert.Less(t, HotRank(5, time.Hour, 10, true, opts), fresh, "flagged post ranks lower")
+	assert.Less(t, HotRank(5, time.Hour, 0, false, opts), fresh, "post without comments ranks lower")
+
+	buried := HotRank(-5, time.Hour, 10, false, opts)
+	assert.Less(t, HotRank(-5, time.Hour, 10, true, opts), buried, "penalty pushes negative posts further down")
+
+	opts.Gravity = 0
+	assert.Equal(t, HotRank(5, 48*time.Hour, 10, false, opts), HotRank(5, time.Hour, 10, false, opts), "no gravity, no decay")
+}
+
+func TestHotSort(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	start := time.Now().UTC()
+
+	store.CreateUser(ctx, User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"})
+	var voters []User
+	for i := 0; i < 20; i++ {
+		voter := User{Username: fmt.Sprintf("voter%d", i), Email: fmt.Sprintf("voter%d@example.com", i), Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, voter)
+		voters = append(voters, voter)
+	}
+
+	// two year old post with lots of votes vs. an hour old post with a few
+	store.now = func() time.Time { return start.Add(-2 * 365 * 24 * time.Hour) }
+	old, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Old news", Link: "https://example.com/old"})
+	store.now = func() time.Time { return start.Add(-time.Hour) }
+	recent, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Fresh news", Link: "https://example.com/new"})
+	store.now = func() time.Time { return start }
+
+	for i, voter := range voters {
+		store.Vote(ctx, voter, Submission{Id: old}, true)
+		if i < 3 {
+			store.Vote(ctx, voter, Submission{Id: recent}, true)
+		}
+	}
+
+	best, _, err := store.AllSubmissions(ctx, Best, Page{Limit: 10})
+	assert.Nil(t, err, "best sort")
+	assert.Equal(t, old, best[0].Id, "best sort ignores age")
+
+	hot, _, err := store.AllSubmissions(ctx, Hot, Page{Limit: 10})
+	assert.Nil(t, err, "hot sort")
+	assert.Equal(t, recent, hot[0].Id, "hot sort prefers the recent post")
+	assert.Equal(t, 3, hot[0].Votes, "hot sort still reports net votes")
+
+	// flagging the recent post should knock it below the old one
+	store.UpdateSubmission(ctx, Submission{Id: recent, Title: "Fresh news", Link: "https://example.com/new", Flagged: true})
+	store.Ranking.FlaggedPenalty = 0
+	hot, _, _ = store.AllSubmissions(ctx, Hot, Page{Limit: 10})
+	assert.Equal(t, old, hot[0].Id, "flagged post drops")
+}
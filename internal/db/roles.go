@@ -0,0 +1,23 @@
+package db
+
+// what a user is allowed to do beyond their own content, stored in the admins table (0005 migration)
+type Role string
+
+const (
+	RoleUser      Role = "user"      // not in the admins table
+	RoleModerator Role = "moderator" // can delete anyone's submissions and comments
+	RoleAdmin     Role = "admin"     // everything, including the admin console
+)
+
+// each role can do everything the ones below it can
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// true if r is min or above it, an unknown role is never enough
+func (r Role) AtLeast(min Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[min]
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, RoleAdmin.AtLeast(RoleModerator), "admin can moderate")
+	assert.True(t, RoleModerator.AtLeast(RoleModerator), "moderator can moderate")
+	assert.False(t, RoleModerator.AtLeast(RoleAdmin), "moderator isn't an admin")
+	assert.False(t, RoleUser.AtLeast(RoleModerator), "user can't moderate")
+	assert.False(t, Role("owner").AtLeast(RoleUser), "unknown role")
+}
+
+// granting roles is done by hand in Postgres, so this one only runs against the memory store
+func TestGetRole(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.AddAdmin("alice", "")
+	store.AddStaff("bob", RoleModerator, "")
+
+	for username, want := range map[string]Role{"alice": RoleAdmin, "bob": RoleModerator, "carol": RoleUser} {
+		role, err := store.GetRole(ctx, User{Username: username})
+		assert.Nil(t, err, username)
+		assert.Equal(t, want, role, username)
+
+		isAdmin, _ := store.CheckAdminStatus(ctx, User{Username: username})
+		assert.Equal(t, want == RoleAdmin, isAdmin, "only admins pass CheckAdminStatus (%s)", username)
+	}
+
+	store.AddStaff("bob", RoleUser, "")
+	role, _ := store.GetRole(ctx, User{Username: "bob"})
+	assert.Equal(t, RoleUser, role, "demoted")
+}
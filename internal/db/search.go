@@ -0,0 +1,314 @@
+package db
+
+import (
+	"math"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type SearchType string
+
+const (
+	SearchAll         SearchType = "all"
+	SearchSubmissions SearchType = "submission"
+	SearchComments    SearchType = "comment"
+)
+
+// what Store.Search looks for, everything except Text is optional
+//
+// Text is web search syntax:
+//
+//	rust async        both words (stemmed, so "runs" finds "running")
+//	"rust async"      the exact phrase
+//	rust OR go        either one
+//	-java             anything without java
+//	asyn*             words starting with asyn
+type SearchQuery struct {
+	Text   string
+	Type   SearchType // "" is the same as SearchAll
+	Author string
+	Domain string    // submissions linking to this host or one of its subdomains, rules out comments
+	After  time.Time // created at or after, zero = no lower bound
+	Before time.Time // created before, zero = no upper bound
+}
+
+// one hit, either Submission or Comment is set depending on Type
+type SearchResult struct {
+	Type       SearchType
+	Submission *Submission
+	Comment    *Comment
+	Rank       float64 // what results are sorted by, see SearchRank
+	Title      string  // submission title with matches wrapped in <mark></mark>, HTML escaped
+	Snippet    string  // best matching bit of the body/comment, same treatment as Title
+}
+
+// how the relevance from the text match gets mixed with votes and age
+// Go version of the rank expression in PostgresStore.Search, keep them in sync
+//
+// relevance is 0..1, each doubling of (points + 1) adds about 0.7x, and a month old post counts half as much as a new one
+func SearchRank(relevance float64, points int, age time.Duration) float64 {
+	hours := math.Max(age.Hours(), 0)
+	return relevance * (1 + math.Log1p(math.Max(float64(points), 0))) / (1 + hours/(24*30))
+}
+
+// one search term, a single word or a quoted phrase
+type searchTerm struct {
+	words  []string // lowercase, letters and digits only, more than one = phrase
+	prefix bool     // last word is a prefix (word*)
+	negate bool     // -word
+}
+
+// the parsed query, terms inside a group are ANDed and the groups are ORed
+type searchExpr [][]searchTerm
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func parseSearch(text string) searchExpr {
+	var expr searchExpr
+	var group []searchTerm
+
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		var term searchTerm
+		if strings.HasPrefix(rest, "-") {
+			term.negate = true
+			rest = rest[1:]
+		}
+
+		var token string
+		if strings.HasPrefix(rest, `"`) {
+			// quoted phrase, an unclosed quote runs to the end
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				token, rest = rest[1:], ""
+			} else {
+				token, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			token, rest = rest[:end], rest[end:]
+
+			if token == "OR" && !term.negate {
+				if len(group) > 0 {
+					expr = append(expr, group)
+					group = nil
+				}
+				rest = strings.TrimSpace(rest)
+				continue
+			}
+			term.prefix = strings.HasSuffix(token, "*")
+		}
+		rest = strings.TrimSpace(rest)
+
+		term.words = searchWords(token)
+		if len(term.words) > 0 {
+			group = append(group, term)
+		}
+	}
+
+	if len(group) > 0 {
+		expr = append(expr, group)
+	}
+	return expr
+}
+
+// Postgres to_tsquery syntax, the words are letters and digits only so nothing needs quoting
+func (e searchExpr) tsquery() string {
+	var groups []string
+	for _, group := range e {
+		var terms []string
+		for _, term := range group {
+			text := strings.Join(term.words, " <-> ")
+			if term.prefix {
+				text += ":*"
+			}
+			if term.negate {
+				text = "!(" + text + ")"
+			}
+			terms = append(terms, text)
+		}
+		groups = append(groups, "("+strings.Join(terms, " & ")+")")
+	}
+	return strings.Join(groups, " | ")
+}
+
+// checks the query, returns the parsed text and the normalized domain
+func (q SearchQuery) validate(op string) (searchExpr, string, error) {
+	expr := parseSearch(q.Text)
+	if len(expr) == 0 {
+		return nil, "", newError(op, ErrInvalidInput, "nothing to search for")
+	}
+
+	switch q.Type {
+	case "", SearchAll, SearchSubmissions, SearchComments:
+	default:
+		return nil, "", newError(op, ErrInvalidInput, "unknown search type %q", q.Type)
+	}
+
+	domain := normalizeDomain(q.Domain)
+	if domain != "" && q.Type == SearchComments {
+		return nil, "", newError(op, ErrInvalidInput, "comments can't be filtered by domain")
+	}
+
+	if !q.After.IsZero() && !q.Before.IsZero() && !q.After.Before(q.Before) {
+		return nil, "", newError(op, ErrInvalidInput, "empty date range")
+	}
+
+	return expr, domain, nil
+}
+
+func (q SearchQuery) includes(t SearchType) bool {
+	return q.Type == "" || q.Type == SearchAll || q.Type == t
+}
+
+// "https://www.Example.com/page" -> "example.com", same as the domain column in the 0003 migration
+func normalizeDomain(link string) string {
+	link = strings.TrimSpace(strings.ToLower(link))
+	if link == "" {
+		return ""
+	}
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(parsed.Hostname(), "www.")
+}
+
+// rough in-memory stand-in for @@ and ts_rank_cd, no stemming or stop words
+// fields are the weighted parts of the document (title 1.0, body 0.4 like setweight A/B)
+// returns 0..1, or false if the document doesn't match
+func (e searchExpr) relevance(fields map[float64][]string) (float64, bool) {
+	matched := false
+	score := 0.0
+
+	for _, group := range e {
+		groupScore, ok := 0.0, true
+		for _, term := range group {
+			hits := 0.0
+			for weight, words := range fields {
+				hits += weight * float64(term.count(words))
+			}
+			if term.negate == (hits > 0) {
+				ok = false
+				break
+			}
+			groupScore += hits
+		}
+		if ok {
+			matched = true
+			score += groupScore
+		}
+	}
+
+	// same shape as ts_rank_cd normalization 32
+	return score / (score + 1), matched
+}
+
+func (t searchTerm) count(doc []string) int {
+	n := 0
+	for i := 0; i+len(t.words) <= len(doc); i++ {
+		if t.matchesAt(doc, i) {
+			n++
+		}
+	}
+	return n
+}
+
+func (t searchTerm) matchesAt(doc []string, i int) bool {
+	for j, word := range t.words {
+		if j == len(t.words)-1 && t.prefix {
+			if !strings.HasPrefix(doc[i+j], word) {
+				return false
+			}
+		} else if doc[i+j] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// in-memory stand-in for ts_headline: escapes the text and wraps words from the query in <mark></mark>
+// maxWords > 0 trims the text to that many words, starting a little before the first match
+func (e searchExpr) highlight(text string, maxWords int) string {
+	type span struct {
+		start, end int
+		word       bool
+	}
+
+	// split into alternating word / non-word spans so the original punctuation survives
+	var spans []span
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if len(spans) == 0 || spans[len(spans)-1].word != isWord {
+			if len(spans) > 0 {
+				spans[len(spans)-1].end = i
+			}
+			spans = append(spans, span{start: i, word: isWord})
+		}
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+	spans[len(spans)-1].end = len(text)
+
+	hit := func(word string) bool {
+		word = strings.ToLower(word)
+		for _, group := range e {
+			for _, term := range group {
+				if term.negate {
+					continue
+				}
+				for j, w := range term.words {
+					if w == word || (term.prefix && j == len(term.words)-1 && strings.HasPrefix(word, w)) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	from, to := 0, len(spans)
+	if maxWords > 0 {
+		words := 0
+		for i, sp := range spans {
+			if sp.word && hit(text[sp.start:sp.end]) {
+				from = max(i-10, 0) // ~5 words of context, each word comes with a separator
+				break
+			}
+		}
+		for i := from; i < len(spans); i++ {
+			if spans[i].word {
+				words++
+			}
+			if words > maxWords {
+				to = i
+				break
+			}
+		}
+	}
+
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	var out strings.Builder
+	for _, sp := range spans[from:to] {
+		chunk := escaper.Replace(text[sp.start:sp.end])
+		if sp.word && hit(text[sp.start:sp.end]) {
+			chunk = "<mark>" + chunk + "</mark>"
+		}
+		out.WriteString(chunk)
+	}
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearch(t *testing.T) {
+	assert.Equal(t, "(rust & async)", parseSearch("Rust async").tsquery(), "words are ANDed")
+	assert.Equal(t, "(rust <-> async)", parseSearch(`"rust, async"`).tsquery(), "quoted phrase")
+	assert.Equal(t, "(rust) | (go)", parseSearch("rust OR go").tsquery(), "OR starts a new group")
+	assert.Equal(t, "(rust & !(java)) | (asyn:*)", parseSearch("rust -java OR asyn*").tsquery(), "negation and prefix")
+	assert.Equal(t, "(drop & table)", parseSearch("drop'); table |").tsquery(), "operators in the input are dropped")
+	assert.Empty(t, parseSearch(`!!! "" OR`), "nothing searchable")
+
+	expr := parseSearch("go*")
+	assert.Equal(t, "Let's <mark>Go</mark> &lt;now&gt; &amp; <mark>gofmt</mark>", expr.highlight("Let's Go <now> & gofmt", 0), "escaped and marked")
+	assert.Equal(t, "example.com", normalizeDomain("https://www.Example.com:8080/page"), "host only")
+}
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		var anna User = User{Username: "anna", Email: "anna@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		store.CreateUser(ctx, anna)
+
+		compiler, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Writing a compiler in Go", Link: "https://blog.example.com/compiler"})
+		mention, _ := store.CreateSubmission(ctx, Submission{Username: "anna", Title: "Weekly links", Link: "https://other.org/links", Body: "includes a post about a compiler"})
+		store.CreateSubmission(ctx, Submission{Username: "anna", Title: "Something else entirely", Link: "https://example.com"})
+		commentId, _ := store.InsertNewComment(ctx, Comment{InResponseTo: compiler, Content: "Great compiler <b>writeup</b>", Author: "anna"})
+
+		results, next, err := store.Search(ctx, SearchQuery{Text: "compiler", Type: SearchSubmissions}, Page{Limit: 10})
+		assert.Nil(t, err, "search")
+		assert.Equal(t, "", next, "one page")
+		assert.Equal(t, 2, len(results), "title and body matches")
+		assert.Equal(t, compiler, results[0].Submission.Id, "title match ranks first")
+		assert.Contains(t, results[0].Title, "<mark>compiler</mark>", "title highlighted")
+		assert.Contains(t, results[1].Snippet, "<mark>compiler</mark>", "body snippet highlighted")
+
+		// votes push the body match above the title match
+		store.Vote(ctx, james, Submission{Id: mention}, true)
+		store.Vote(ctx, anna, Submission{Id: mention}, true)
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compiler", Type: SearchSubmissions}, Page{Limit: 10})
+		assert.Equal(t, mention, results[0].Submission.Id, "score counts")
+
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compiler"}, Page{Limit: 10})
+		assert.Equal(t, 3, len(results), "submissions and comments")
+
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compiler", Type: SearchComments}, Page{Limit: 10})
+		assert.Equal(t, 1, len(results), "comments only")
+		assert.Equal(t, commentId, results[0].Comment.Id, "the comment")
+		assert.NotContains(t, results[0].Snippet, "<b>", "comment text escaped")
+
+		results, _, _ = store.Search(ctx, SearchQuery{Text: `"writing a compiler"`}, Page{Limit: 10})
+		assert.Equal(t, 1, len(results), "phrase")
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compil*", Author: "anna", Type: SearchSubmissions}, Page{Limit: 10})
+		assert.Equal(t, 1, len(results), "prefix plus author")
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compiler", Domain: "example.com"}, Page{Limit: 10})
+		assert.Equal(t, 1, len(results), "subdomain matches, comments dropped")
+		results, _, _ = store.Search(ctx, SearchQuery{Text: "compiler", Before: time.Now().Add(-time.Hour)}, Page{Limit: 10})
+		assert.Equal(t, 0, len(results), "date range")
+
+		// walking a page at a time gets everything once
+		seen := map[string]bool{}
+		page := Page{Limit: 1}
+		for {
+			results, next, err = store.Search(ctx, SearchQuery{Text: "compiler"}, page)
+			assert.Nil(t, err, "page")
+			for _, r := range results {
+				if r.Submission != nil {
+					seen[r.Submission.Id] = true
+				} else {
+					seen[r.Comment.Id] = true
+				}
+			}
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		assert.Equal(t, 3, len(seen), "paged through every hit")
+
+		_, _, err = store.Search(ctx, SearchQuery{Text: "!!!"}, Page{Limit: 10})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "empty query")
+		_, _, err = store.Search(ctx, SearchQuery{Text: "compiler", Type: SearchComments, Domain: "example.com"}, Page{Limit: 10})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "domain filter on comments")
+	})
+}
@@ -0,0 +1,18 @@
+package db
+
+// one signed in device (0008 migration), the refresh token itself is never stored, only a hash of it
+type Session struct {
+	Id        string
+	Username  string
+	Ip        string // as of the last refresh or request
+	UserAgent string // as of the last refresh
+	CreatedAt string
+	LastSeen  string
+	ExpiresAt string // refreshing pushes this back
+}
+
+// a fresh refresh token and what's stored of it
+func newRefreshToken() (token string, hash string) {
+	token = SecureToken(64)
+	return token, hashToken(token)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		james := User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		defer store.DeleteUser(ctx, james)
+		expires := time.Now().Add(time.Hour)
+
+		_, _, err := store.CreateSession(ctx, User{Username: "nobody"}, "127.0.0.1", "test", expires)
+		assert.True(t, errors.Is(err, ErrNotFound), "no such user")
+
+		laptop, refresh, err := store.CreateSession(ctx, james, "127.0.0.1", "laptop", expires)
+		assert.Nil(t, err, "create")
+		assert.Equal(t, "laptop", laptop.UserAgent, "user agent kept")
+		phone, _, err := store.CreateSession(ctx, james, "127.0.0.2", "phone", expires)
+		assert.Nil(t, err, "second device")
+
+		touched, err := store.TouchSession(ctx, laptop.Id, "127.0.0.3")
+		assert.Nil(t, err, "touch")
+		assert.Equal(t, "127.0.0.3", touched.Ip, "ip follows the device")
+
+		sessions, err := store.ListSessions(ctx, james)
+		assert.Nil(t, err, "list")
+		assert.Equal(t, 2, len(sessions), "both devices")
+		assert.Equal(t, laptop.Id, sessions[0].Id, "most recently seen first")
+
+		refreshed, rotated, err := store.RefreshSession(ctx, refresh, "127.0.0.1", "laptop 2", expires)
+		assert.Nil(t, err, "refresh")
+		assert.Equal(t, laptop.Id, refreshed.Id, "same session")
+		assert.NotEqual(t, refresh, rotated, "new refresh token")
+		assert.Equal(t, "laptop 2", refreshed.UserAgent, "user agent updated")
+
+		// the old token showing up again means it was copied: the session goes
+		_, _, err = store.RefreshSession(ctx, refresh, "10.0.0.1", "thief", expires)
+		assert.True(t, errors.Is(err, ErrNotFound), "reused refresh token")
+		_, _, err = store.RefreshSession(ctx, rotated, "127.0.0.1", "laptop", expires)
+		assert.True(t, errors.Is(err, ErrNotFound), "session killed by the reuse")
+		_, err = store.TouchSession(ctx, laptop.Id, "")
+		assert.True(t, errors.Is(err, ErrNotFound), "revoked session can't be used")
+
+		_, _, err = store.RefreshSession(ctx, "nonsense", "127.0.0.1", "laptop", expires)
+		assert.True(t, errors.Is(err, ErrNotFound), "unknown token")
+
+		assert.True(t, errors.Is(store.RevokeSession(ctx, User{Username: "bob"}, phone.Id), ErrNotFound), "someone else's session")
+		assert.Nil(t, store.RevokeSession(ctx, james, phone.Id), "revoke")
+		assert.True(t, errors.Is(store.RevokeSession(ctx, james, phone.Id), ErrNotFound), "revoke twice")
+
+		store.CreateSession(ctx, james, "127.0.0.1", "a", expires)
+		store.CreateSession(ctx, james, "127.0.0.1", "b", expires)
+		n, err := store.RevokeAllSessions(ctx, james)
+		assert.Nil(t, err, "revoke all")
+		assert.Equal(t, 2, n, "only the live ones")
+		sessions, _ = store.ListSessions(ctx, james)
+		assert.Equal(t, 0, len(sessions), "nothing left")
+	})
+}
+
+// Postgres uses its own clock, so expiry is only checked against the memory store
+func TestSessionExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.CreateUser(ctx, User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"})
+
+	session, refresh, err := store.CreateSession(ctx, User{Username: "james"}, "127.0.0.1", "laptop", time.Now().Add(time.Hour))
+	assert.Nil(t, err, "create")
+
+	store.now = func() time.Time { return time.Now().UTC().Add(2 * time.Hour) }
+	_, err = store.TouchSession(ctx, session.Id, "")
+	assert.True(t, errors.Is(err, ErrNotFound), "expired session")
+	_, _, err = store.RefreshSession(ctx, refresh, "127.0.0.1", "laptop", time.Now().Add(3*time.Hour))
+	assert.True(t, errors.Is(err, ErrNotFound), "expired refresh token")
+}
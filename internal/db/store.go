@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store is everything the rest of the app needs from persistent storage
+// PostgresStore is what runs in production, MemoryStore backs unit tests and local hacking
+//
+// every method returns an *Error on failure, see errors.go for the kinds
+// list methods take a Page and return the cursor for the next one ("" on the last page)
+type Store interface {
+	// users
+	CreateUser(ctx context.Context, user User) error
+	UpsertUserMetadata(ctx context.Context, metadata UserMetadata) error
+	SearchUser(ctx context.Context, user User) (CompleteUser, error)
+	DeleteUser(ctx context.Context, user User) error
+	CheckAdminStatus(ctx context.Context, user User) (bool, error)
+	GetRole(ctx context.Context, user User) (Role, error)
+
+	// submissions
+	CreateSubmission(ctx context.Context, submission Submission) (string, error)
+	SearchSubmission(ctx context.Context, stub Submission) (Submission, error)
+	UpdateSubmission(ctx context.Context, stub Submission) error
+	DeleteSubmission(ctx context.Context, submission Submission) error
+	AllSubmissions(ctx context.Context, sort SortMethod, page Page) ([]Submission, string, error)
+	LatestUserSubmissions(ctx context.Context, page Page, user User) ([]BasicSubmission, string, error)
+
+	// votes
+	Vote(ctx context.Context, user User, submission Submission, isUpvote bool) (VoteResult, error)
+	Unvote(ctx context.Context, user User, submission Submission) (VoteResult, error)
+	GetUserVote(ctx context.Context, user User, submission Submission) (bool, bool, error)
+	GetAllUserVotes(ctx context.Context, page Page, user User) ([]BasicSubmissionAndVote, string, error)
+	CountVotes(ctx context.Context, post Submission) (VoteMetrics, error)
+	VoteOnComment(ctx context.Context, user User, comment Comment, isUpvote bool) (VoteResult, error)
+	UnvoteComment(ctx context.Context, user User, comment Comment) (VoteResult, error)
+
+	// comments
+	InsertNewComment(ctx context.Context, comment Comment) (string, error)
+	GetCommentsOnSubmission(ctx context.Context, submission Submission, contextUser User) ([]Comment, error)
+	CommentTree(ctx context.Context, submission Submission, contextUser User, opts TreeOptions) ([]CommentNode, string, error)
+	SearchComment(ctx context.Context, comment Comment) (Comment, error)
+	DeleteComment(ctx context.Context, comment Comment) error
+	LatestUserComments(ctx context.Context, page Page, user User) ([]Comment, string, error)
+
+	// search
+	Search(ctx context.Context, query SearchQuery, page Page) ([]SearchResult, string, error)
+
+	// reports
+	ReportSubmission(ctx context.Context, user User, submission Submission) (float64, bool, error)
+	ReportComment(ctx context.Context, comment Comment, user User) (float64, bool, error)
+	SelectAllReportsFromUser(ctx context.Context, page Page, user User) ([]Report, string, error)
+	GetReputation(ctx context.Context, user User) (Reputation, error)
+
+	// magic links
+	CreateMagicLink(ctx context.Context, user User, expiresAt time.Time) (string, error)
+	DeleteMagicLink(ctx context.Context, token string) error
+	ValidateMagicLink(ctx context.Context, token string, ip string) (User, error)
+	PurgeExpiredMagicLinks(ctx context.Context) (int, error)
+
+	// api keys
+	CreateAPIKey(ctx context.Context, user User, opts APIKeyOptions) (APIKey, string, error)
+	ValidateAPIKey(ctx context.Context, token string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, user User) ([]APIKey, error)
+	RotateAPIKey(ctx context.Context, user User, id string) (APIKey, string, error)
+	RevokeAPIKey(ctx context.Context, user User, id string) error
+
+	// sessions
+	CreateSession(ctx context.Context, user User, ip string, userAgent string, expiresAt time.Time) (Session, string, error)
+	RefreshSession(ctx context.Context, refreshToken string, ip string, userAgent string, expiresAt time.Time) (Session, string, error)
+	TouchSession(ctx context.Context, id string, ip string) (Session, error)
+	ListSessions(ctx context.Context, user User) ([]Session, error)
+	RevokeSession(ctx context.Context, user User, id string) error
+	RevokeAllSessions(ctx context.Context, user User) (int, error)
+
+	// passkeys
+	PasskeyHandle(ctx context.Context, user User) ([]byte, error)
+	PasskeyOwner(ctx context.Context, handle []byte) (string, error)
+	CreatePasskey(ctx context.Context, user User, name string, credentialId []byte, credential []byte) (Passkey, error)
+	UsePasskey(ctx context.Context, credentialId []byte, credential []byte) (Passkey, error)
+	ListPasskeys(ctx context.Context, user User) ([]Passkey, error)
+	DeletePasskey(ctx context.Context, user User, id string) error
+	CreatePasskeyChallenge(ctx context.Context, username string, data []byte, expiresAt time.Time) (string, error)
+	TakePasskeyChallenge(ctx context.Context, id string) (PasskeyChallenge, error)
+	PurgeExpiredPasskeyChallenges(ctx context.Context) (int, error)
+
+	// two-factor
+	GetTwoFactor(ctx context.Context, user User) (TwoFactor, error)
+	SetupTOTP(ctx context.Context, user User, secret string) error
+	EnableTOTP(ctx context.Context, user User, step int64) ([]string, error)
+	UseTOTPStep(ctx context.Context, user User, step int64) error
+	DisableTOTP(ctx context.Context, user User) error
+	UseRecoveryCode(ctx context.Context, user User, code string) error
+	ReplaceRecoveryCodes(ctx context.Context, user User) ([]string, error)
+	CreateTwoFactorChallenge(ctx context.Context, user User, expiresAt time.Time) (string, error)
+	GetTwoFactorChallenge(ctx context.Context, token string) (string, error)
+	FailTwoFactorChallenge(ctx context.Context, token string) (int, error)
+	FinishTwoFactorChallenge(ctx context.Context, token string) (string, error)
+	PurgeExpiredTwoFactorChallenges(ctx context.Context) (int, error)
+
+	// admin
+	GetAdminMetrics(ctx context.Context) (AdminMetrics, error)
+
+	// audit log
+	RecordAudit(ctx context.Context, entry AuditEntry) error
+	AuditLog(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, string, error)
+}
+
+// both implementations must keep up with the interface
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// report weight chart (see internal/migrate/migrations), shared by every Store so they agree
+// on when a post gets flagged
+func reportWeightForAge(days int) float64 {
+	switch {
+	case days > 0 && days <= 1:
+		return 0.1
+	case days > 1 && days <= 7:
+		return 0.25
+	case days > 7 && days <= 28:
+		return 0.33
+	case days > 28:
+		return 0.5
+	default:
+		return 0.0
+	}
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// how much of a comment thread CommentTree returns
+// Page.Limit -> replies per comment (and top level comments), Page.Cursor -> a More token from a previous tree
+// MaxDepth -> levels of replies below the starting point, deeper threads get a More token instead
+type TreeOptions struct {
+	Page     Page
+	MaxDepth int
+}
+
+// a comment and the first Page.Limit of its replies, best first
+type CommentNode struct {
+	Comment
+	Score   int
+	Replies []CommentNode
+	More    string // pass back as the cursor to load the rest of the replies, "" when they're all here
+}
+
+// "load more replies" position, like cursor but it also says whose replies
+// no Id means start from the first reply (the thread was cut off by MaxDepth rather than Page.Limit)
+type threadCursor struct {
+	Submission string `json:"s"`
+	Parent     string `json:"p,omitempty"` // "" for top level comments
+	Score      int    `json:"v,omitempty"`
+	CreatedAt  string `json:"c,omitempty"`
+	Id         string `json:"i,omitempty"` // last reply already sent
+}
+
+func (c threadCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// "" starts at the top of the submission's thread
+func decodeThreadCursor(op string, encoded string, submission string) (threadCursor, error) {
+	if encoded == "" {
+		return threadCursor{Submission: submission}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return threadCursor{}, newError(op, ErrInvalidInput, "malformed cursor")
+	}
+
+	var c threadCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return threadCursor{}, newError(op, ErrInvalidInput, "malformed cursor")
+	}
+
+	if c.Submission != submission {
+		return threadCursor{}, newError(op, ErrInvalidInput, "cursor is for a different submission")
+	}
+
+	return c, nil
+}
+
+// checks the options shared by both stores, returns the page size
+func (opts TreeOptions) validate(op string) (int, error) {
+	limit, err := opts.Page.size(op)
+	if err != nil {
+		return 0, err
+	}
+	if opts.MaxDepth <= 0 {
+		return 0, newError(op, ErrInvalidInput, "max depth must be > 0, got %d", opts.MaxDepth)
+	}
+	return limit, nil
+}
+
+// nests a flat list of comments, which has to be sorted best first and start at from.Parent
+// replies past the limit or below maxDepth are left out and the parent gets a More token for them
+// returns the top level plus the More token for it
+func buildTree(comments []Comment, from threadCursor, limit int, maxDepth int) ([]CommentNode, string) {
+	children := map[string][]Comment{}
+	for _, c := range comments {
+		children[c.ParentComment] = append(children[c.ParentComment], c)
+	}
+
+	var level func(parent string, depth int) ([]CommentNode, string)
+	level = func(parent string, depth int) ([]CommentNode, string) {
+		replies := children[parent]
+		more := ""
+		if len(replies) > limit {
+			replies = replies[:limit]
+			last := replies[limit-1]
+			more = threadCursor{Submission: from.Submission, Parent: parent, Score: last.Upvotes - last.Downvotes, CreatedAt: last.CreatedAt, Id: last.Id}.encode()
+		}
+
+		nodes := []CommentNode{}
+		for _, c := range replies {
+			node := CommentNode{Comment: c, Score: c.Upvotes - c.Downvotes, Replies: []CommentNode{}}
+			if depth < maxDepth {
+				node.Replies, node.More = level(c.Id, depth+1)
+			} else if c.ReplyCount > 0 {
+				node.More = threadCursor{Submission: from.Submission, Parent: c.Id}.encode()
+			}
+			nodes = append(nodes, node)
+		}
+
+		return nodes, more
+	}
+
+	return level(from.Parent, 1)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommentTree(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		var james User = User{Username: "james", Email: "test@example.com", Registered_ip: "127.0.0.1"}
+		var anna User = User{Username: "anna", Email: "anna@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, james)
+		store.CreateUser(ctx, anna)
+		postId, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Discuss", Link: "https://example.com"})
+
+		// three top level comments, the middle one gets the most votes
+		var top []string
+		for i := 0; i < 3; i++ {
+			id, _ := store.InsertNewComment(ctx, Comment{InResponseTo: postId, Content: fmt.Sprintf("top %d", i), Author: "james"})
+			top = append(top, id)
+		}
+		store.VoteOnComment(ctx, james, Comment{Id: top[1]}, true)
+		store.VoteOnComment(ctx, anna, Comment{Id: top[1]}, true)
+		store.VoteOnComment(ctx, james, Comment{Id: top[2]}, true)
+
+		// a chain four replies deep under the best one
+		chain := []string{top[1]}
+		for i := 0; i < 4; i++ {
+			id, _ := store.InsertNewComment(ctx, Comment{InResponseTo: postId, ParentComment: chain[len(chain)-1], Content: fmt.Sprintf("reply %d", i), Author: "anna"})
+			chain = append(chain, id)
+		}
+
+		tree, more, err := store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Limit: 10}, MaxDepth: 10})
+		assert.Nil(t, err, "whole tree")
+		assert.Equal(t, "", more, "every top level comment fits")
+		assert.Equal(t, []string{top[1], top[2], top[0]}, []string{tree[0].Id, tree[1].Id, tree[2].Id}, "sorted by score")
+		assert.Equal(t, 2, tree[0].Score, "score filled in")
+		assert.True(t, tree[0].HasUpvoted, "viewer's vote reported")
+		assert.Equal(t, chain[4], tree[0].Replies[0].Replies[0].Replies[0].Replies[0].Id, "chain nested")
+
+		// depth limit: the second reply has more below it
+		tree, _, err = store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Limit: 10}, MaxDepth: 3})
+		assert.Nil(t, err, "shallow tree")
+		cut := tree[0].Replies[0].Replies[0]
+		assert.Equal(t, chain[2], cut.Id, "third level returned")
+		assert.Empty(t, cut.Replies, "fourth level left out")
+		assert.NotEqual(t, "", cut.More, "load more token for the rest")
+
+		rest, more, err := store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Cursor: cut.More, Limit: 10}, MaxDepth: 3})
+		assert.Nil(t, err, "load more replies")
+		assert.Equal(t, "", more, "nothing else")
+		assert.Equal(t, chain[3], rest[0].Id, "continues where the tree stopped")
+		assert.Equal(t, chain[4], rest[0].Replies[0].Id, "and keeps going down")
+
+		// width limit: top level comes two at a time
+		tree, more, _ = store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Limit: 2}, MaxDepth: 1})
+		assert.Equal(t, 2, len(tree), "page of top level comments")
+		assert.NotEqual(t, "", tree[0].More, "replies left for later")
+		rest, more, _ = store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Cursor: more, Limit: 2}, MaxDepth: 1})
+		assert.Equal(t, 1, len(rest), "last top level comment")
+		assert.Equal(t, top[0], rest[0].Id, "lowest score last")
+		assert.Equal(t, "", more, "no more top level comments")
+
+		otherId, _ := store.CreateSubmission(ctx, Submission{Username: "james", Title: "Elsewhere", Link: "https://example.com"})
+		_, _, err = store.CommentTree(ctx, Submission{Id: otherId}, james, TreeOptions{Page: Page{Cursor: cut.More, Limit: 10}, MaxDepth: 3})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "cursor from another submission")
+		_, _, err = store.CommentTree(ctx, Submission{Id: postId}, james, TreeOptions{Page: Page{Limit: 10}})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "max depth required")
+	})
+}
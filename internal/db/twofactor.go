@@ -0,0 +1,35 @@
+package db
+
+import "strings"
+
+// a user's authenticator app (0011 migration), they only have one
+// the secret has to be kept as is to work out codes, so unlike tokens it isn't hashed
+type TwoFactor struct {
+	Enabled           bool   // false while it's been set up but no code has been confirmed yet
+	EnabledAt         string // "" until enabled
+	RecoveryCodesLeft int
+	Secret            string `json:"-"`
+}
+
+// how many recovery codes a user gets, each works once
+const recoveryCodeCount = 10
+
+// wrong codes allowed for one sign in before it has to start over with a new magic link
+const maxTwoFactorAttempts = 5
+
+// fresh recovery codes, formatted to be written down (abcde-12345), and the hashes that are stored
+func newRecoveryCodes() (codes []string, hashes []string) {
+	for range recoveryCodeCount {
+		code := strings.ToLower(SecureToken(10))
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// case, spaces and the dash don't matter when typing a code back in
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		dave := User{Username: "dave", Email: "dave@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, dave)
+		defer store.DeleteUser(ctx, dave)
+
+		_, err := store.GetTwoFactor(ctx, dave)
+		assert.True(t, errors.Is(err, ErrNotFound), "never set up")
+		_, err = store.EnableTOTP(ctx, dave, 1)
+		assert.True(t, errors.Is(err, ErrNotFound), "enable before setup")
+
+		assert.Nil(t, store.SetupTOTP(ctx, dave, "FIRSTSECRET"), "setup")
+		assert.Nil(t, store.SetupTOTP(ctx, dave, "SECONDSECRET"), "setting up again replaces it")
+		twoFactor, err := store.GetTwoFactor(ctx, dave)
+		assert.Nil(t, err, "get")
+		assert.False(t, twoFactor.Enabled, "not on until a code is confirmed")
+		assert.Equal(t, "SECONDSECRET", twoFactor.Secret, "newest secret")
+
+		codes, err := store.EnableTOTP(ctx, dave, 100)
+		assert.Nil(t, err, "enable")
+		assert.Equal(t, recoveryCodeCount, len(codes), "recovery codes")
+		assert.Equal(t, 11, len(codes[0]), "written as abcde-12345")
+		twoFactor, _ = store.GetTwoFactor(ctx, dave)
+		assert.True(t, twoFactor.Enabled, "on")
+		assert.NotEqual(t, "", twoFactor.EnabledAt, "enabled at")
+		assert.Equal(t, recoveryCodeCount, twoFactor.RecoveryCodesLeft, "codes left")
+
+		assert.True(t, errors.Is(store.SetupTOTP(ctx, dave, "THIRDSECRET"), ErrConflict), "can't replace a secret that's on")
+		_, err = store.EnableTOTP(ctx, dave, 101)
+		assert.True(t, errors.Is(err, ErrConflict), "already on")
+
+		// the step enabling it used can't be replayed
+		assert.True(t, errors.Is(store.UseTOTPStep(ctx, dave, 100), ErrConflict), "enabling code reused")
+		assert.Nil(t, store.UseTOTPStep(ctx, dave, 101), "next code")
+		assert.True(t, errors.Is(store.UseTOTPStep(ctx, dave, 101), ErrConflict), "same code twice")
+		assert.True(t, errors.Is(store.UseTOTPStep(ctx, dave, 99), ErrConflict), "older code")
+
+		assert.Nil(t, store.UseRecoveryCode(ctx, dave, strings.ToUpper(codes[0])), "recovery code, any case")
+		assert.True(t, errors.Is(store.UseRecoveryCode(ctx, dave, codes[0]), ErrNotFound), "only once")
+		assert.Nil(t, store.UseRecoveryCode(ctx, dave, strings.ReplaceAll(codes[1], "-", "")), "without the dash")
+		assert.True(t, errors.Is(store.UseRecoveryCode(ctx, dave, "nope"), ErrNotFound), "made up")
+		twoFactor, _ = store.GetTwoFactor(ctx, dave)
+		assert.Equal(t, recoveryCodeCount-2, twoFactor.RecoveryCodesLeft, "two used")
+
+		fresh, err := store.ReplaceRecoveryCodes(ctx, dave)
+		assert.Nil(t, err, "replace")
+		assert.True(t, errors.Is(store.UseRecoveryCode(ctx, dave, codes[2]), ErrNotFound), "old codes stop working")
+		assert.Nil(t, store.UseRecoveryCode(ctx, dave, fresh[0]), "new ones work")
+
+		assert.Nil(t, store.DisableTOTP(ctx, dave), "disable")
+		assert.True(t, errors.Is(store.DisableTOTP(ctx, dave), ErrNotFound), "already off")
+		_, err = store.ReplaceRecoveryCodes(ctx, dave)
+		assert.True(t, errors.Is(err, ErrNotFound), "no codes while it's off")
+		assert.True(t, errors.Is(store.UseRecoveryCode(ctx, dave, fresh[1]), ErrNotFound), "codes went with it")
+	})
+}
+
+func TestTwoFactorChallenges(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		dave := User{Username: "dave", Email: "dave@example.com", Registered_ip: "127.0.0.1"}
+		store.CreateUser(ctx, dave)
+		defer store.DeleteUser(ctx, dave)
+
+		token, err := store.CreateTwoFactorChallenge(ctx, dave, time.Now().Add(time.Minute))
+		assert.Nil(t, err, "create")
+		username, err := store.GetTwoFactorChallenge(ctx, token)
+		assert.Nil(t, err, "get")
+		assert.Equal(t, "dave", username, "who it's for")
+
+		left, err := store.FailTwoFactorChallenge(ctx, token)
+		assert.Nil(t, err, "wrong code")
+		assert.Equal(t, maxTwoFactorAttempts-1, left, "tries left")
+
+		username, err = store.FinishTwoFactorChallenge(ctx, token)
+		assert.Nil(t, err, "finish")
+		assert.Equal(t, "dave", username, "finished for")
+		_, err = store.FinishTwoFactorChallenge(ctx, token)
+		assert.True(t, errors.Is(err, ErrNotFound), "only finishes once")
+		_, err = store.GetTwoFactorChallenge(ctx, token)
+		assert.True(t, errors.Is(err, ErrNotFound), "gone once finished")
+
+		token, _ = store.CreateTwoFactorChallenge(ctx, dave, time.Now().Add(time.Minute))
+		for range maxTwoFactorAttempts {
+			left, err = store.FailTwoFactorChallenge(ctx, token)
+		}
+		assert.Nil(t, err, "last try")
+		assert.Equal(t, 0, left, "none left")
+		_, err = store.GetTwoFactorChallenge(ctx, token)
+		assert.True(t, errors.Is(err, ErrNotFound), "out of tries")
+
+		stale, _ := store.CreateTwoFactorChallenge(ctx, dave, time.Now().Add(-time.Minute))
+		_, err = store.GetTwoFactorChallenge(ctx, stale)
+		assert.True(t, errors.Is(err, ErrNotFound), "expired")
+		_, err = store.FinishTwoFactorChallenge(ctx, stale)
+		assert.True(t, errors.Is(err, ErrNotFound), "expired can't finish")
+
+		store.CreateTwoFactorChallenge(ctx, dave, time.Now().Add(-time.Minute))
+		live, _ := store.CreateTwoFactorChallenge(ctx, dave, time.Now().Add(time.Minute))
+		purged, err := store.PurgeExpiredTwoFactorChallenges(ctx)
+		assert.Nil(t, err, "purge")
+		assert.GreaterOrEqual(t, purged, 1, "expired challenge purged")
+		_, err = store.GetTwoFactorChallenge(ctx, live)
+		assert.Nil(t, err, "live challenge survives the purge")
+	})
+}
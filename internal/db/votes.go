@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// submissions and comments are voted on the same way, just through different tables
+type voteTable struct {
+	votes  string // table holding one row per (target, voter)
+	column string // column in votes pointing at the target
+	target string // table with the counters
+}
+
+var (
+	submissionVoteTable = voteTable{votes: "votes", column: "submission_id", target: "submissions"}
+	commentVoteTable    = voteTable{votes: "comment_votes", column: "comment_id", target: "comments"}
+)
+
+// counter deltas for adding (+1) or removing (-1) a single vote
+func voteDelta(isUpvote bool, direction int) (int, int) {
+	if isUpvote {
+		return direction, 0
+	}
+	return 0, direction
+}
+
+// inserts the vote, or flips an existing one, as a single statement so concurrent clicks
+// can't race each other into the UNIQUE constraint
+//
+// the WHERE on the conflict branch means a repeat of the same vote touches nothing and returns no row
+// otherwise xmax = 0 tells a fresh insert apart from an update
+func castVote(ctx context.Context, tx *sql.Tx, t voteTable, id string, username string, isUpvote bool) (VoteResult, error) {
+	query := `
+		INSERT INTO ` + t.votes + ` (` + t.column + `, voter_username, positive)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (` + t.column + `, voter_username) DO UPDATE SET positive = EXCLUDED.positive
+		WHERE ` + t.votes + `.positive <> EXCLUDED.positive
+		RETURNING (xmax = 0) AS inserted
+	`
+
+	var inserted bool
+	err := tx.QueryRowContext(ctx, query, id, username, isUpvote).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		// "can't vote twice"
+		score, err := currentScore(ctx, tx, t, id)
+		return VoteResult{Changed: false, Score: score}, err
+	}
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	up, down := voteDelta(isUpvote, 1)
+	if !inserted {
+		// flipped, so the old (opposite) vote comes off as well
+		oldUp, oldDown := voteDelta(!isUpvote, -1)
+		up, down = up+oldUp, down+oldDown
+	}
+
+	score, err := adjustVoteCounters(ctx, tx, t.target, id, up, down)
+	return VoteResult{Changed: true, Score: score}, err
+}
+
+// deletes the vote if there is one
+func retractVote(ctx context.Context, tx *sql.Tx, t voteTable, id string, username string) (VoteResult, error) {
+	var wasPositive bool
+	err := tx.QueryRowContext(ctx,
+		"DELETE FROM "+t.votes+" WHERE "+t.column+" = $1 AND voter_username = $2 RETURNING positive",
+		id, username,
+	).Scan(&wasPositive)
+	if err == sql.ErrNoRows {
+		// nothing to take back
+		score, err := currentScore(ctx, tx, t, id)
+		return VoteResult{Changed: false, Score: score}, err
+	}
+	if err != nil {
+		return VoteResult{}, err
+	}
+
+	up, down := voteDelta(wasPositive, -1)
+	score, err := adjustVoteCounters(ctx, tx, t.target, id, up, down)
+	return VoteResult{Changed: true, Score: score}, err
+}
+
+// sql.ErrNoRows (so ErrNotFound once wrapped) if the target doesn't exist
+func currentScore(ctx context.Context, tx *sql.Tx, t voteTable, id string) (int, error) {
+	var score int
+	err := tx.QueryRowContext(ctx, "SELECT score FROM "+t.target+" WHERE id = $1", id).Scan(&score)
+	return score, err
+}
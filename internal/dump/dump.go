@@ -1,6 +1,7 @@
 package dump
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
@@ -8,26 +9,76 @@ import (
 	"github.com/trentwiles/hackernews/internal/db"
 )
 
+// rows per query while exporting, the export keeps paging until it has everything
+const exportPageSize = 500
+
+// follows a list query's cursors until it runs out
+func collect[T any](fetch func(page db.Page) ([]T, string, error)) ([]T, error) {
+	var all []T
+	page := db.Page{Limit: exportPageSize}
+
+	for {
+		results, next, err := fetch(page)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, results...)
+		if next == "" {
+			return all, nil
+		}
+		page.Cursor = next
+	}
+}
+
 // returns folder path of data dump
-func DumpForUser(user db.User) string {
+func DumpForUser(ctx context.Context, store db.Store, user db.User) (string, error) {
 	// BEFORE RUNNING, we assume user exists and is authorized to access this data (that'll be handled via the API)
 	// included in a user dump:
 	// 1. user metadata
 	// 2. posts
 	// 3. comments
 	// 4. up/downvotes
-	db.UpdateSelectLimit(5000)
-	var userMeta db.CompleteUser = db.SearchUser(user)
-	var userSubmissions []db.BasicSubmission = db.LatestUserSubmissions(0, user) // pass 0 as offset, since we're working with a high limit
-	var userComments []db.Comment = db.LatestUserComments(0, user)
-	var userVotes []db.BasicSubmissionAndVote = db.GetAllUserVotes(user)
-	var userReports []db.Report = db.SelectAllReportsFromUser(0, user)
+	userMeta, err := store.SearchUser(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	userSubmissions, err := collect(func(page db.Page) ([]db.BasicSubmission, string, error) {
+		return store.LatestUserSubmissions(ctx, page, user)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	userComments, err := collect(func(page db.Page) ([]db.Comment, string, error) {
+		return store.LatestUserComments(ctx, page, user)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	userVotes, err := collect(func(page db.Page) ([]db.BasicSubmissionAndVote, string, error) {
+		return store.GetAllUserVotes(ctx, page, user)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	userReports, err := collect(func(page db.Page) ([]db.Report, string, error) {
+		return store.SelectAllReportsFromUser(ctx, page, user)
+	})
+	if err != nil {
+		return "", err
+	}
 
 	exportDir := "exports/" + user.Username
-	os.MkdirAll(exportDir, 0755)
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return "", err
+	}
 
-	if !writeJSONToFile(userMeta, exportDir+"/user.json") {
-		return ""
+	if err := writeJSONToFile(userMeta, exportDir+"/user.json"); err != nil {
+		return "", err
 	}
 
 	// why check the length?
@@ -37,62 +88,58 @@ func DumpForUser(user db.User) string {
 	// this length check will write '[]' instead, which makes more sense
 
 	if len(userSubmissions) == 0 {
-		if !writeJSONToFile([]string{}, exportDir+"/submissions.json") {
-			return ""
-		}
+		err = writeJSONToFile([]string{}, exportDir+"/submissions.json")
 	} else {
-		if !writeJSONToFile(userSubmissions, exportDir+"/submissions.json") {
-			return ""
-		}
+		err = writeJSONToFile(userSubmissions, exportDir+"/submissions.json")
+	}
+	if err != nil {
+		return "", err
 	}
 
 	if len(userComments) == 0 {
-		if !writeJSONToFile([]string{}, exportDir+"/comments.json") {
-			return ""
-		}
+		err = writeJSONToFile([]string{}, exportDir+"/comments.json")
 	} else {
-		if !writeJSONToFile(userComments, exportDir+"/comments.json") {
-			return ""
-		}
+		err = writeJSONToFile(userComments, exportDir+"/comments.json")
+	}
+	if err != nil {
+		return "", err
 	}
 
 	if len(userVotes) == 0 {
-		if !writeJSONToFile([]string{}, exportDir+"/votes.json") {
-			return ""
-		}
+		err = writeJSONToFile([]string{}, exportDir+"/votes.json")
 	} else {
-		if !writeJSONToFile(userVotes, exportDir+"/votes.json") {
-			return ""
-		}
+		err = writeJSONToFile(userVotes, exportDir+"/votes.json")
+	}
+	if err != nil {
+		return "", err
 	}
 
 	if len(userReports) == 0 {
-		if !writeJSONToFile([]string{}, exportDir+"/reports.json") {
-			return ""
-		}
+		err = writeJSONToFile([]string{}, exportDir+"/reports.json")
 	} else {
-		if !writeJSONToFile(userReports, exportDir+"/reports.json") {
-			return ""
-		}
+		err = writeJSONToFile(userReports, exportDir+"/reports.json")
+	}
+	if err != nil {
+		return "", err
 	}
 
-	return exportDir
+	return exportDir, nil
 }
 
-func writeJSONToFile(data interface{}, filepath string) bool {
+func writeJSONToFile(data interface{}, filepath string) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		log.Fatalln("[ERROR] Error marshaling JSON:", err)
-		return false
+		log.Println("[WARN] Error marshaling JSON:", err)
+		return err
 	}
 
 	err = os.WriteFile(filepath, jsonData, 0644)
 	if err != nil {
-		log.Fatalln("[ERROR] Error writing file:", err)
-		return false
+		log.Println("[WARN] Error writing file:", err)
+		return err
 	}
 
-	return true
+	return nil
 }
 
 func WipeExports() error {
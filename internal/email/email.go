@@ -2,107 +2,81 @@ package email
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html/template"
-	"log"
-	"net/smtp"
 	"strings"
+	"time"
 
-	"github.com/trentwiles/hackernews/internal/config"
+	"github.com/trentwiles/hackernews/internal/templates"
 )
 
-type Email struct {
-	To      string
-	Subject string
-	Message string
+// sends mail one way or another: SMTP in production, a maildir in development, Memory in tests
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
 }
 
-type MagicLinkEmail struct {
-	To    string
-	Token string
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	HTML    bool // Body is HTML rather than plain text
 }
 
-// unused testing function
-func SendEmail(email Email) {
-	config.LoadEnv()
-
-	from := config.GetEnv("EMAIL_USERNAME")     // <your_email>@gmail.com
-	password := config.GetEnv("EMAIL_PASSWORD") // google app password
-
-	toEmailAddress := email.To
-	to := []string{toEmailAddress}
-
-	host := config.GetEnv("EMAIL_HOST")
-	port := "587"
-	address := host + ":" + port
-
-	subject := email.Subject
-	body := email.Message
-
-	message := []byte("To: " + email.To + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-version: 1.0;\r\n" +
-		"Content-Type: text/plain; charset=\"UTF-8\";\r\n" +
-		"\r\n" +
-		body + "\r\n")
-
-	auth := smtp.PlainAuth("", from, password, host)
-
-	err := smtp.SendMail(address, auth, from, to, message)
-	if err != nil {
-		log.Fatal(err)
-	}
+// parsed once, it's embedded so it can't go missing at runtime
+var magicLinkTemplate = template.Must(template.ParseFS(templates.FS, "magic-link.html"))
 
-	// golang note: since message is a byte array, there's no need to convert to a rune
-	log.Printf("[INFO] Sent plaintext email to %s with msg length of %d\n", to, len(message))
+// what the emails say about the site, the frontend's VITE_SERVICE_NAME and VITE_FRONTEND_URL
+type Site struct {
+	Name string
+	URL  string // links in emails go here, no trailing slash
 }
 
-func SendEmailTemplate(magic MagicLinkEmail) {
-	config.LoadEnv()
-
-	from := config.GetEnv("EMAIL_USERNAME")     // <your_email>@gmail.com
-	password := config.GetEnv("EMAIL_PASSWORD") // google app password
-
-	toEmailAddress := magic.To
-	to := []string{toEmailAddress}
-
-	host := config.GetEnv("EMAIL_HOST")
-	port := "587"
-	address := host + ":" + port
-
-	// TEMPLATE HANDLING
-	tmpl := template.Must(template.ParseFiles("internal/templates/magic-link.html"))
+// the email with a sign in link for token
+func (s Site) MagicLink(to string, token string) (Message, error) {
 	var buf bytes.Buffer
-	tmpl.Execute(&buf, struct {
+	err := magicLinkTemplate.Execute(&buf, struct {
 		Token string
 		Title string
-		Url string
+		Url   string
 	}{
-		Token: magic.Token,
-		Title: config.GetEnv("VITE_SERVICE_NAME"),
-		Url: config.GetEnv("VITE_FRONTEND_URL"),
+		Token: token,
+		Title: s.Name,
+		Url:   s.URL,
 	})
-	// END TEMPLATE HANDLING
-
-	subject := "Magic Login Link | " + config.GetEnv("VITE_SERVICE_NAME")
-
-	// not that this would ever happen, but if someone tries to hijack the email
-	if strings.Contains(magic.To, "\n") || strings.Contains(magic.To, "\r") {
-		log.Fatal("[FATAL] Header injection attempt prevented")
+	if err != nil {
+		return Message{}, err
 	}
 
-	message := []byte("To: " + magic.To + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-version: 1.0;\r\n" +
-		"Content-Type: text/html; charset=\"UTF-8\";\r\n" +
-		"\r\n" +
-		buf.String() + "\r\n")
+	return Message{To: to, Subject: "Magic Login Link | " + s.Name, Body: buf.String(), HTML: true}, nil
+}
 
-	auth := smtp.PlainAuth("", from, password, host)
+// the message as it goes over the wire, with headers
+// refuses line breaks in the headers, which would let whoever wrote them add their own (header injection)
+func (m Message) format(from string, now time.Time) ([]byte, error) {
+	for _, header := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("line break in an email header")
+		}
+	}
 
-	err := smtp.SendMail(address, auth, from, to, message)
-	if err != nil {
-		log.Fatal(err)
+	contentType := "text/plain"
+	if m.HTML {
+		contentType = "text/html"
 	}
 
-	log.Printf("[INFO] Sent HTML email to %s with content length of %d\n", to, len(message))
+	var buf bytes.Buffer
+	if from != "" {
+		buf.WriteString("From: " + from + "\r\n")
+	}
+	buf.WriteString("To: " + m.To + "\r\n")
+	buf.WriteString("Subject: " + m.Subject + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: " + contentType + "; charset=\"UTF-8\"\r\n")
+	buf.WriteString("\r\n")
+	// net/smtp takes care of line endings and lone dots in the body
+	buf.WriteString(m.Body + "\r\n")
+
+	return buf.Bytes(), nil
 }
@@ -1,16 +1,126 @@
 package email
 
-
 import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
-	//"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestUserCreation(t *testing.T) {
-	SendEmail(Email{To: "me@trentwil.es", Subject: "Hey! this is a test", Message: "hi this is a test"})
+func TestMagicLink(t *testing.T) {
+	msg, err := Site{Name: "Hacker News", URL: "https://news.example.com"}.MagicLink("me@example.com", "123123123")
+	assert.Nil(t, err, "MagicLink")
+	assert.Equal(t, "me@example.com", msg.To, "to")
+	assert.Equal(t, "Magic Login Link | Hacker News", msg.Subject, "subject")
+	assert.True(t, msg.HTML, "html")
+	assert.Contains(t, msg.Body, `href="https://news.example.com/magic?token=123123123"`, "link")
 }
 
-func TestMagicLink(t *testing.T) {
-	SendEmailTemplate(MagicLinkEmail{To: "me@trentwil.es", Token: "123123123"})
-}
\ No newline at end of file
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	mailer := NewMemory()
+
+	assert.Nil(t, mailer.Send(ctx, Message{To: "me@example.com", Subject: "Hey! this is a test", Body: "hi this is a test"}), "send")
+	assert.NotNil(t, mailer.Send(ctx, Message{To: "me@example.com\r\nBcc: everyone@example.com", Subject: "injected"}), "header injection")
+	assert.Equal(t, []Message{{To: "me@example.com", Subject: "Hey! this is a test", Body: "hi this is a test"}}, mailer.Messages(), "captured")
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &Maildir{Dir: dir}
+
+	assert.Nil(t, mailer.Send(context.Background(), Message{To: "me@example.com", Subject: "Hey", Body: "hi"}), "send")
+
+	written, _ := os.ReadDir(filepath.Join(dir, "new"))
+	assert.Equal(t, 1, len(written), "one message in new")
+	left, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+	assert.Equal(t, 0, len(left), "nothing left in tmp")
+
+	data, _ := os.ReadFile(filepath.Join(dir, "new", written[0].Name()))
+	assert.Contains(t, string(data), "To: me@example.com\r\n", "headers")
+	assert.Contains(t, string(data), "\r\n\r\nhi\r\n", "body")
+}
+
+// a pretend SMTP server that accepts one message without auth or TLS and hands back what it was sent
+func testSMTPServer(t *testing.T, extensions ...string) (int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "listen")
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP test")
+
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				for _, ext := range extensions {
+					reply("250-" + ext)
+				}
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTP(t *testing.T) {
+	ctx := context.Background()
+
+	port, received := testSMTPServer(t)
+	mailer := &SMTP{Host: "127.0.0.1", Port: port, TLS: NoTLS, From: "noreply@example.com"}
+	assert.Nil(t, mailer.Send(ctx, Message{To: "me@example.com", Subject: "Hey", Body: "hi"}), "send without auth or TLS")
+
+	data := <-received
+	assert.Contains(t, data, "From: noreply@example.com\r\n", "from")
+	assert.Contains(t, data, "Subject: Hey\r\n", "subject")
+	assert.Contains(t, data, "Content-Type: text/plain", "plain text")
+
+	port, _ = testSMTPServer(t)
+	mailer = &SMTP{Host: "127.0.0.1", Port: port, TLS: StartTLS, From: "noreply@example.com"}
+	assert.NotNil(t, mailer.Send(ctx, Message{To: "me@example.com", Subject: "Hey", Body: "hi"}), "STARTTLS required but not offered")
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	port = listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	mailer = &SMTP{Host: "127.0.0.1", Port: port, TLS: NoTLS, From: "noreply@example.com"}
+	err := mailer.Send(ctx, Message{To: "me@example.com", Subject: "Hey", Body: "hi"})
+	assert.ErrorContains(t, err, "127.0.0.1:"+strconv.Itoa(port), "nothing listening, an error rather than exiting")
+}
@@ -0,0 +1,51 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writes every message to a maildir instead of sending it, for development
+// point a mail client at Dir, or just open the files in Dir/new
+type Maildir struct {
+	Dir string // tmp, new and cur are created inside it
+}
+
+func (m *Maildir) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := msg.format("", now)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+
+	// written to tmp then moved into new, so a reader never sees half a message
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.hackernews", now.UnixNano(), hex.EncodeToString(unique))
+
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(m.Dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Printf("[INFO] Wrote email to %s into %s\n", msg.To, filepath.Join(m.Dir, "new", name))
+	return nil
+}
@@ -0,0 +1,38 @@
+package email
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// keeps every message it's given, for tests to look at
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// fails like the others would on a message with a line break in its headers
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if _, err := msg.format("", time.Time{}); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// everything sent so far, oldest first
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,112 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// how the connection to the SMTP server is encrypted
+type TLSMode string
+
+const (
+	StartTLS    TLSMode = "starttls" // starts plain and upgrades, usually port 587
+	ImplicitTLS TLSMode = "tls"      // TLS from the first byte, usually port 465
+	NoTLS       TLSMode = "none"     // for a relay on the same machine, passwords aren't sent over it
+)
+
+// sends through an SMTP server, the connection lasts for one message
+type SMTP struct {
+	Host      string
+	Port      int
+	TLS       TLSMode
+	Username  string // signs in with PLAIN auth, "" for servers that don't need it
+	Password  string
+	From      string
+	TLSConfig *tls.Config // nil verifies the certificate against Host
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.format(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	if err := s.send(ctx, address, msg.To, data); err != nil {
+		return fmt.Errorf("sending email through %s: %w", address, err)
+	}
+
+	log.Printf("[INFO] Sent email to %s with content length of %d\n", msg.To, len(data))
+	return nil
+}
+
+func (s *SMTP) send(ctx context.Context, address string, to string, data []byte) error {
+	config := s.TLSConfig
+	if config == nil {
+		config = &tls.Config{ServerName: s.Host}
+	}
+
+	var conn net.Conn
+	var err error
+	if s.TLS == ImplicitTLS {
+		conn, err = (&tls.Dialer{Config: config}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	// the context only covers dialing, the deadline covers the conversation too
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.TLS == StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server doesn't offer STARTTLS")
+		}
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	}
+
+	// PlainAuth refuses to send the password unencrypted, unless the server is on localhost
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/dump"
+)
+
+// GET /adminMetrics
+// admins only
+func (h *Handlers) AdminMetrics(c *fiber.Ctx) error {
+	metrics, err := h.Store.GetAdminMetrics(c.UserContext())
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"metrics": metrics,
+	})
+}
+
+// GET /checkAdmin
+// role is "user", "moderator" or "admin", isAdmin is kept for the admin console
+func (h *Handlers) CheckAdmin(c *fiber.Ctx) error {
+	role, err := h.Role(c)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"isAdmin": role == db.RoleAdmin,
+		"role":    role,
+	})
+}
+
+// GET /auditLog
+// admins only, newest first
+func (h *Handlers) AuditLog(c *fiber.Ctx) error {
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filter := db.AuditFilter{Username: c.Query("username"), Event: db.AuditEvent(c.Query("event"))}
+	filter.After, filter.Before, err = timeRangeFromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	entries, next, err := h.Store.AuditLog(c.UserContext(), filter, page)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	// only the first page, otherwise paging through the log floods it with its own entries
+	if page.Cursor == "" {
+		h.recordAudit(c, Username(c), db.AdminAction, map[string]string{"action": "view audit log", "query": c.Context().QueryArgs().String()})
+	}
+
+	return pageResponse(c, entries, next)
+}
+
+// GET /clean
+// admins only, removes temporary files: the "exports" directory
+func (h *Handlers) Clean(c *fiber.Ctx) error {
+	wiped := dump.WipeExports() == nil
+	h.recordAudit(c, Username(c), db.AdminAction, map[string]string{"action": "clean exports", "success": strconv.FormatBool(wiped)})
+
+	return c.Status(fiber.StatusAccepted).JSON(
+		fiber.Map{
+			"status": wiped,
+		},
+	)
+}
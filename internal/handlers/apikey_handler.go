@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// what a key gets when nobody says otherwise, the same as the single key everyone used to have
+var defaultScopes = []db.Scope{db.ScopeRead, db.ScopeVote, db.ScopeSubmit, db.ScopeComment}
+
+// POST /apiKeys (and /generateKey, which is what the website has always called)
+// JWT only, a key can't mint more keys
+func (h *Handlers) GenerateKey(c *fiber.Ctx) error {
+	// here's the catch: to create an API key, you must already be authenticated,
+	// that is, you must click on the code in your email, then log in
+	// in the future, I'll consider developing a way to avoid email
+
+	username := Username(c)
+
+	// the body is optional, /generateKey never had one
+	var req APIKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "cannot parse JSON",
+			})
+		}
+	}
+
+	opts := db.APIKeyOptions{Name: req.Name, Scopes: req.Scopes}
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = defaultScopes
+	}
+	// RequireRole won't take an admin key from someone without two-factor, so don't hand out one that can't work
+	if (db.APIKey{Scopes: opts.Scopes}).HasScope(db.ScopeAdmin) {
+		twoFactor, err := h.Store.GetTwoFactor(c.UserContext(), db.User{Username: username})
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return dbErrorResponse(c, err)
+		}
+		if !twoFactor.Enabled {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "turn on two-factor authentication before creating a key with the admin scope",
+			})
+		}
+	}
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "'expiresAt' must be an RFC 3339 timestamp",
+			})
+		}
+		opts.ExpiresAt = expiresAt
+	}
+
+	key, token, err := h.Store.CreateAPIKey(c.UserContext(), db.User{Username: username}, opts)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.APIKeyEvent, map[string]string{"action": "create", "key": key.Id, "prefix": key.Prefix})
+
+	return c.JSON(fiber.Map{"username": username, "key": key, "apiKey": token, "comment": "Store this API key in a safe place, it won't be shown again."})
+}
+
+// GET /apiKeys
+// the signed in user's live keys, the keys themselves can't be shown since only hashes are kept
+func (h *Handlers) ListAPIKeys(c *fiber.Ctx) error {
+	keys, err := h.Store.ListAPIKeys(c.UserContext(), db.User{Username: Username(c)})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{"keys": keys})
+}
+
+// POST /apiKeys/rotate?id=<key id>
+// revokes the key and hands back a new one with the same name, scopes and expiry
+func (h *Handlers) RotateAPIKey(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing key `id`",
+		})
+	}
+
+	username := Username(c)
+	key, token, err := h.Store.RotateAPIKey(c.UserContext(), db.User{Username: username}, id)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.APIKeyEvent, map[string]string{"action": "rotate", "key": key.Id, "replaces": id, "prefix": key.Prefix})
+
+	return c.JSON(fiber.Map{"key": key, "apiKey": token, "comment": "Store this API key in a safe place, it won't be shown again."})
+}
+
+// DELETE /apiKeys?id=<key id>
+func (h *Handlers) RevokeAPIKey(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing key `id`",
+		})
+	}
+
+	username := Username(c)
+	if err := h.Store.RevokeAPIKey(c.UserContext(), db.User{Username: username}, id); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.APIKeyEvent, map[string]string{"action": "revoke", "key": id})
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// the requests that take a captchaToken, CaptchaTrust is keyed by these
+const (
+	CaptchaLogin   = "login"
+	CaptchaSubmit  = "submit"
+	CaptchaComment = "comment"
+)
+
+var captchaActions = []string{CaptchaLogin, CaptchaSubmit, CaptchaComment}
+
+// who's trusted enough to skip the captcha on an action, they have to meet every one of these
+// signing in is never skipped, nobody's signed in yet
+type TrustPolicy struct {
+	MinAgeDays      int     // days since they signed up
+	MinKarma        int     // score of their submissions
+	MaxReportWeight float64 // reports against their posts and comments, 1.0 is enough to flag a post
+	MaxFlagged      int     // their posts and comments that got flagged
+}
+
+func (p TrustPolicy) Trusts(reputation db.Reputation) bool {
+	return reputation.AgeDays >= p.MinAgeDays &&
+		reputation.Karma >= p.MinKarma &&
+		reputation.ReportWeight <= p.MaxReportWeight &&
+		reputation.Flagged <= p.MaxFlagged
+}
+
+// whether the signed in user has to solve a captcha for action
+// when their reputation can't be looked up they're asked for one rather than failing the request
+func (h *Handlers) captchaRequired(c *fiber.Ctx, action string) bool {
+	policy, ok := h.CaptchaTrust[action]
+	username := Username(c)
+	if !ok || username == "" {
+		return true
+	}
+
+	reputation, err := h.Store.GetReputation(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		log.Printf("[WARN] Unable to check %s's reputation, asking for a captcha: %s\n", username, err)
+		return true
+	}
+	return !policy.Trusts(reputation)
+}
+
+// GET /captcha
+// which actions the caller has to send a captchaToken for, so the frontend can leave the widget out of the rest
+func (h *Handlers) CaptchaStatus(c *fiber.Ctx) error {
+	required := fiber.Map{}
+	for _, action := range captchaActions {
+		required[action] = h.captchaRequired(c, action)
+	}
+
+	return c.JSON(fiber.Map{"required": required})
+}
+
+// GET /captcha/challenge
+// only when the server's captcha is proof-of-work (CAPTCHA_PROVIDER=pow), ask while signed in if the token is for /submit or /comment
+// find a nonce so sha256("<challenge>:<nonce>") starts with difficulty zero bits and send "<challenge>:<nonce>" as captchaToken
+func (h *Handlers) CaptchaChallenge(c *fiber.Ctx) error {
+	if h.ProofOfWork == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "this server's captcha isn't proof-of-work, use its captcha widget instead",
+		})
+	}
+
+	// new accounts get harder challenges, so it matters when they joined
+	username := Username(c)
+	var joined time.Time
+	if username != "" {
+		complete, err := h.Store.SearchUser(c.UserContext(), db.User{Username: username})
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+		if joined, err = time.Parse(time.RFC3339Nano, complete.User.Created_at); err != nil {
+			log.Printf("[WARN] Unreadable signup time %q for %s: %s\n", complete.User.Created_at, username, err)
+		}
+	}
+
+	// the client's address, from the proxy in front if there's a trusted one
+	challenge, err := h.ProofOfWork.Issue(username, c.IP(), joined)
+	if err != nil {
+		log.Printf("[WARN] Unable to issue a captcha challenge: %s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+
+	return c.JSON(challenge)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// POST /comment
+// POST /api/v1/comment?parent=123123123
+func (h *Handlers) CreateComment(c *fiber.Ctx) error {
+	parent := c.Query("parent")
+
+	username := Username(c)
+
+	var req CommentCreationRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	// VALIDATE CAPTCHA TOKEN
+	if !h.captchaPassed(c, CaptchaComment, req.CaptchaToken) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid captcha response, try again later",
+		})
+	}
+	// END VALIDATE CAPTCHA TOKEN
+
+	var yourComment db.Comment = db.Comment{InResponseTo: req.InResponseTo, Content: req.Content, Author: username}
+	if parent != "" {
+		yourComment.ParentComment = parent
+	}
+
+	yourComment.Flagged = false
+
+	fmt.Printf("yourComment (full debug): %+v\n", yourComment)
+
+	commentId, err := h.Store.InsertNewComment(c.UserContext(), yourComment)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.CommentEvent, map[string]string{"comment": commentId, "submission": req.InResponseTo})
+
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"commentID": commentId,
+	})
+}
+
+// GET /commentTree
+// nested version of /comments, ?limit= is replies per comment and ?cursor= takes a "More" token
+func (h *Handlers) CommentTree(c *fiber.Ctx) error {
+	parent := c.Query("id")         // submissionID
+	username := c.Query("username") // has comment been upvoted by ...
+
+	if parent == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing submission `id`",
+		})
+	}
+
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	depth := c.QueryInt("depth", defaultTreeDepth)
+	if depth < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "'depth' must be a positive integer"})
+	}
+
+	tree, more, err := h.Store.CommentTree(c.UserContext(), db.Submission{Id: parent}, db.User{Username: username}, db.TreeOptions{Page: page, MaxDepth: min(depth, maxTreeDepth)})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return pageResponse(c, tree, more)
+}
+
+// GET /comments
+func (h *Handlers) Comments(c *fiber.Ctx) error {
+	parent := c.Query("id")         // submissionID
+	username := c.Query("username") // has comment been upvoted by ...
+
+	if parent == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing submission `id`",
+		})
+	}
+
+	msg := ``
+
+	if username == "" {
+		msg = "`username` parameter is NULL, meaning that hasUpvoted and hasDownvoted will always be false"
+	}
+
+	comments, err := h.Store.GetCommentsOnSubmission(c.UserContext(), db.Submission{Id: parent}, db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	if msg != "" {
+		return c.JSON(fiber.Map{
+			"notice":   msg,
+			"comments": comments,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"comments": comments,
+	})
+}
+
+// DELETE /comment
+// your own comments, or anyone's for moderators
+func (h *Handlers) DeleteComment(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing comment `id`",
+		})
+	}
+
+	username := Username(c)
+
+	comment, err := h.Store.SearchComment(c.UserContext(), db.Comment{Id: id})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	moderating, ok, err := h.checkCanRemove(c, comment.Author, comment.Flagged)
+	if !ok {
+		return err
+	}
+
+	if err := h.Store.DeleteComment(c.UserContext(), comment); err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	metadata := map[string]string{"comment": id}
+	if moderating {
+		metadata["author"] = comment.Author
+	}
+	h.recordAudit(c, username, db.DeleteEvent, metadata)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Deleted comment " + id,
+	})
+}
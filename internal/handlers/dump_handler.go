@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/dump"
+)
+
+// POST /dump
+func (h *Handlers) CreateDump(c *fiber.Ctx) error {
+	username := Username(c)
+
+	// future: add ratelimit/cooldown period
+
+	dumpLocation, err := dump.DumpForUser(c.UserContext(), h.Store, db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	// for the user example, the dump would be stored at exports\example\
+
+	cmd := exec.Command("zip", "-r", "exports/"+username+".zip", dumpLocation)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		log.Println("[WARN] Error running zip on dump:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "internal zipping error, please contact site administrator if you see this message",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// GET /dump
+func (h *Handlers) GetDump(c *fiber.Ctx) error {
+	// checks if the current logged in user has an available dump
+	// the website opens this as a plain link, so it can't set headers and sends the JWT as ?authToken= instead
+	var username string
+	if hasScope(c, db.ScopeRead) {
+		username = Username(c)
+	}
+
+	if username == "" {
+		auth := c.Query("authToken")
+
+		if auth == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(BasicResponse{Message: "missing authorization token", Status: fiber.StatusUnauthorized})
+		}
+
+		var err error
+		username, _, err = h.sessionUser(c, auth)
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+
+		if username == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "not signed in", Status: fiber.StatusUnauthorized})
+		}
+	}
+
+	_, err := os.Stat("exports/" + username + ".zip")
+	if err != nil {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"success": false,
+			"message": "Data dump was either already downloaded or never created",
+		})
+	}
+
+	return c.SendFile("exports/" + username + ".zip")
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/captcha"
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/email"
+)
+
+// every route handler hangs off this, see internal/routes for which path goes where
+type Handlers struct {
+	Store            db.Store
+	Paging           Paging
+	TokenExpiresIn   int                    // minutes, for the access JWTs handed out after a magic link or a refresh
+	SessionExpiresIn time.Duration          // how long a refresh token lasts, each refresh starts it over
+	APIKeyLimit      RateLimit              // applies to requests signed in with an API key, per user
+	MagicLinkTTL     time.Duration          // how long an emailed sign in link works for
+	LoginIPLimit     RateLimit              // magic links one IP can ask for
+	LoginEmailLimit  RateLimit              // magic links that can be sent to one address
+	TrustedProxies   []string               // reverse proxies (addresses or CIDR ranges) whose ProxyHeader c.IP() believes
+	ProxyHeader      string                 // where those proxies put the client's address
+	WebAuthn         *webauthn.WebAuthn     // passkeys, nil when WEBAUTHN_RP_ID isn't set
+	Captcha          captcha.Verifier       // checks captchaToken on /login, /submit and /comment
+	ProofOfWork      *captcha.ProofOfWork   // hands out GET /captcha/challenge, nil unless it's also Captcha
+	CaptchaTrust     map[string]TrustPolicy // by action, users these trust skip the captcha, actions without one always ask
+	Mailer           email.Mailer           // sends the magic links
+	Site             email.Site             // what those emails call the site and link to
+}
+
+// page sizes for the list endpoints
+type Paging struct {
+	DefaultLimit int // used when the client doesn't pass ?limit=
+	MaxLimit     int // bigger requests are clamped to this
+}
+
+// levels of replies /commentTree returns, anything deeper is behind a "load more" token
+const (
+	defaultTreeDepth = 5
+	maxTreeDepth     = 10
+)
+
+// maps an error from the db package onto an HTTP status, so a bad ID is a 400/404 rather than a crash
+func dbErrorResponse(c *fiber.Ctx, err error) error {
+	var status int
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, db.ErrInvalidInput):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, db.ErrConflict):
+		status = fiber.StatusConflict
+	case errors.Is(err, db.ErrUnavailable):
+		status = fiber.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		status = fiber.StatusRequestTimeout
+	default:
+		log.Printf("[WARN] Unhandled database error on %s %s: %s\n", c.Method(), c.Path(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "internal server error",
+		})
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// reads ?cursor= and ?limit= for the list endpoints
+// no ?limit= means the server default, anything over the maximum is clamped to it
+func (p Paging) FromQuery(c *fiber.Ctx) (db.Page, error) {
+	page := db.Page{Cursor: c.Query("cursor"), Limit: p.DefaultLimit}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("'limit' must be a positive integer")
+		}
+		page.Limit = min(n, p.MaxLimit)
+	}
+
+	return page, nil
+}
+
+// reads ?after= and ?before=, each either a plain date (midnight UTC) or a full timestamp
+// zero times for whichever is missing
+func timeRangeFromQuery(c *fiber.Ctx) (time.Time, time.Time, error) {
+	var bounds [2]time.Time
+	for i, key := range []string{"after", "before"} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, value); err != nil {
+				return time.Time{}, time.Time{}, fmt.Errorf("'%s' must be a date (2006-01-02) or an RFC 3339 timestamp", key)
+			}
+		}
+		bounds[i] = t
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// every list endpoint responds with {results, nextCursor}, nextCursor is null on the last page
+// pass it back as ?cursor= to get the next page
+func pageResponse(c *fiber.Ctx, results any, next string) error {
+	var nextCursor any
+	if next != "" {
+		nextCursor = next
+	}
+
+	return c.JSON(fiber.Map{
+		"results":    results,
+		"nextCursor": nextCursor,
+	})
+}
+
+// "upvote", "downvote" or "unvote", for the audit log
+func voteAction(req VoteRequest) string {
+	if req.Action == "unvote" {
+		return "unvote"
+	}
+	if req.Upvote {
+		return "upvote"
+	}
+	return "downvote"
+}
+
+// whether the signed in user may delete a post or comment by author, when ok is false the
+// response has already been sent and the handler should just return err
+// owners can delete their own unless it's flagged, moderators and admins can delete anything
+// moderating is true when it isn't the user's own content, which an API key needs the admin scope for like any other staff action
+func (h *Handlers) checkCanRemove(c *fiber.Ctx, author string, flagged bool) (moderating bool, ok bool, err error) {
+	role, err := h.Role(c)
+	if err != nil {
+		return false, false, dbErrorResponse(c, err)
+	}
+	if role.AtLeast(db.RoleModerator) {
+		if author == Username(c) {
+			return false, true, nil
+		}
+		if hasScope(c, db.ScopeAdmin) {
+			if ok, err := h.checkKeyTwoFactor(c); !ok {
+				return false, false, err
+			}
+			return true, true, nil
+		}
+	}
+
+	if author != Username(c) {
+		return false, false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You do not own this post, and therefore cannot delete it",
+		})
+	}
+
+	// prevent a flagged post from being deleted (can't let people destroy the evidence!)
+	if flagged {
+		return false, false, c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error": "This post is currently under review, and cannot be deleted during this process",
+		})
+	}
+
+	return false, true, nil
+}
+
+// whether the request can go ahead with token as its captcha for action, users CaptchaTrust trusts don't need one
+// nothing else passes without a Captcha set
+func (h *Handlers) captchaPassed(c *fiber.Ctx, action string, token string) bool {
+	if !h.captchaRequired(c, action) {
+		return true
+	}
+	if h.Captcha == nil || token == "" {
+		return false
+	}
+
+	ok, err := h.Captcha.Verify(captcha.WithSubject(c.UserContext(), Username(c)), token)
+	if err != nil {
+		log.Printf("[WARN] Unable to check captcha on %s: %s\n", c.Path(), err)
+		return false
+	}
+	return ok
+}
+
+// writes an audit log entry, a failure is logged but never fails the request that triggered it
+func (h *Handlers) recordAudit(c *fiber.Ctx, username string, event db.AuditEvent, metadata map[string]string) {
+	entry := db.AuditEntry{Username: username, Event: event, Metadata: metadata, Ip: c.IP()}
+	if err := h.Store.RecordAudit(c.UserContext(), entry); err != nil {
+		log.Printf("[WARN] Unable to record %s audit event for %q: %s\n", event, username, err)
+	}
+}
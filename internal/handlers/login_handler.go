@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/utils"
+)
+
+// the LoginRequest CheckLogin let through, in fiber.Ctx locals for Login
+const loginLocal = "login"
+
+// the address a checked /login is sending to, lowercased, "" before CheckLogin has let it through
+// the per address limit counts this, so requests that never pass the captcha can't use up someone's budget
+func LoginEmail(c *fiber.Ctx) string {
+	req, _ := c.Locals(loginLocal).(LoginRequest)
+	return strings.ToLower(req.Email)
+}
+
+// POST /login, first half: the captcha and everything that can be checked before sending an email
+// goes on to the next handler (the per address limit, then Login) when it all checks out
+func (h *Handlers) CheckLogin(c *fiber.Ctx) error {
+	var req LoginRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.Email == "" || req.Username == "" || req.CaptchaToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "email, username, and captchaToken are required",
+		})
+	}
+
+	if !h.captchaPassed(c, CaptchaLogin, req.CaptchaToken) {
+		h.recordAudit(c, req.Username, db.FailedLogin, map[string]string{"reason": "captcha", "email": req.Email})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid captcha response, try again later",
+		})
+	}
+
+	// is the email address even valid?
+	if !utils.IsValidEmail(req.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email (failed regex)",
+		})
+	}
+
+	// are they both under 100 chars (limit as defined in postgres)
+	if len(req.Email) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email (over 100 chars)",
+		})
+	}
+
+	if len(req.Username) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid username (over 100 chars)",
+		})
+	}
+
+	// does username pass filter?
+	// has only letters, underscores, and numbers
+	// future: doesn't contain slurs/other forbidden words
+	if !utils.IsValidUsername(req.Username) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid username (must contain only letters, numbers, and underscores)",
+		})
+	}
+
+	// database calls:
+	// check if email exists in the database:
+	// 		exists?  => check if email/username combo matches, otherwise return error
+	//		doesn't? => send magic link email, once user has verified the magic link, marry them together in the database
+
+	databaseUser, err := h.Store.SearchUser(c.UserContext(), db.User{Email: req.Email})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return dbErrorResponse(c, err)
+	}
+
+	// case exists, but doesn't match
+	if databaseUser.User.Username != "" && databaseUser.User.Username != req.Username {
+		h.recordAudit(c, req.Username, db.FailedLogin, map[string]string{"reason": "email belongs to another username", "email": req.Email})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email with this username already exists, and the submitted email doesn't said username",
+		})
+	}
+
+	// the username is taken by an account with a different address, otherwise anyone could have a link for it sent to themselves
+	byUsername, err := h.Store.SearchUser(c.UserContext(), db.User{Username: req.Username})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return dbErrorResponse(c, err)
+	}
+	if byUsername.User.Username != "" && !strings.EqualFold(byUsername.User.Email, req.Email) {
+		h.recordAudit(c, req.Username, db.FailedLogin, map[string]string{"reason": "username belongs to another email", "email": req.Email})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "This username is registered to a different email address",
+		})
+	}
+
+	// case exists and does match
+	if databaseUser.User.Username == req.Username {
+		fmt.Printf("Attempted a sign in for %s\n", req.Username)
+	}
+
+	// email does not exist in the database
+	if databaseUser.User.Username == "" {
+		fmt.Printf("Email %s does not have a username tied to it in the database.\n", req.Email)
+	}
+
+	c.Locals(loginLocal, req)
+	return c.Next()
+}
+
+// POST /login, second half: emails the magic link for the request CheckLogin let through
+func (h *Handlers) Login(c *fiber.Ctx) error {
+	req, ok := c.Locals(loginLocal).(LoginRequest)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+
+	token, err := h.Store.CreateMagicLink(c.UserContext(), db.User{Username: req.Username, Email: req.Email}, time.Now().Add(h.MagicLinkTTL))
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	msg, err := h.Site.MagicLink(req.Email, token)
+	if err == nil {
+		err = h.Mailer.Send(c.UserContext(), msg)
+	}
+	if err != nil {
+		log.Printf("[WARN] Unable to email a magic link to %s: %s\n", req.Email, err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Unable to send the sign in email, try again later",
+		})
+	}
+	h.recordAudit(c, req.Username, db.SentEmail, map[string]string{"email": req.Email, "kind": "magic link"})
+
+	return c.JSON(fiber.Map{"message": "Emailed a magic link to " + req.Email})
+}
+
+// GET /magic
+// responds with tokens, or a twoFactorToken for /login/2fa when the user has two-factor on
+func (h *Handlers) Magic(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Please pass a valid token parameter"})
+	}
+
+	// verify token
+	user, err := h.Store.ValidateMagicLink(c.UserContext(), token, c.IP())
+	if errors.Is(err, db.ErrNotFound) {
+		h.recordAudit(c, "", db.FailedLogin, map[string]string{"reason": "unknown or expired magic link"})
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "Magic link was not found. Maybe it expired?"})
+	}
+	if errors.Is(err, db.ErrConflict) {
+		h.recordAudit(c, "", db.FailedLogin, map[string]string{"reason": "magic link sent to an address the account doesn't have"})
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "Magic link doesn't match this account", Status: fiber.StatusUnauthorized})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return h.signIn(c, user.Username, map[string]string{})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/jwt"
+)
+
+// keys in fiber.Ctx locals, set by Authenticate
+const (
+	usernameLocal   = "username"   // read it with Username(c)
+	authMethodLocal = "authMethod" // read it with Method(c)
+	roleLocal       = "role"       // read it with h.Role(c)
+	apiKeyLocal     = "apiKey"     // the db.APIKey the request came in with, if any
+	sessionLocal    = "session"    // read it with Session(c)
+)
+
+// how the caller proved who they are
+type AuthMethod string
+
+const (
+	AuthNone   AuthMethod = ""       // signed out
+	AuthJWT    AuthMethod = "jwt"    // "Authorization: Bearer <jwt>", what the website sends, tied to a session
+	AuthAPIKey AuthMethod = "apikey" // "Authorization: ApiKey <key>" or "X-API-Key: <key>", for bots
+)
+
+// requests per window for API key traffic, Max of 0 turns the limit off
+type RateLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+// the signed in user, "" if the caller didn't send a valid token or key
+func Username(c *fiber.Ctx) string {
+	username, _ := c.Locals(usernameLocal).(string)
+	return username
+}
+
+// session id of a request signed in with a JWT, "" otherwise
+func Session(c *fiber.Ctx) string {
+	session, _ := c.Locals(sessionLocal).(string)
+	return session
+}
+
+// how the signed in user authenticated, AuthNone if they didn't
+func Method(c *fiber.Ctx) AuthMethod {
+	method, _ := c.Locals(authMethodLocal).(AuthMethod)
+	return method
+}
+
+// works out who's calling, from a JWT or an API key, and puts it in locals for everything after it
+// it never rejects a request itself: a missing or bad credential just leaves the request signed out,
+// RequireAuth/RequireRole on the route decide whether that's allowed
+func (h *Handlers) Authenticate(c *fiber.Ctx) error {
+	scheme, credential, _ := strings.Cut(c.Get("Authorization"), " ")
+	if scheme == "" && c.Get("X-API-Key") != "" {
+		scheme, credential = "ApiKey", c.Get("X-API-Key")
+	}
+
+	switch scheme {
+	case "Bearer":
+		username, session, err := h.sessionUser(c, credential)
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+		if username != "" {
+			c.Locals(usernameLocal, username)
+			c.Locals(authMethodLocal, AuthJWT)
+			c.Locals(sessionLocal, session)
+		}
+	case "ApiKey":
+		key, err := h.Store.ValidateAPIKey(c.UserContext(), credential)
+		if err != nil && !errors.Is(err, db.ErrNotFound) && !errors.Is(err, db.ErrInvalidInput) {
+			return dbErrorResponse(c, err)
+		}
+		if err == nil {
+			c.Locals(usernameLocal, key.Username)
+			c.Locals(authMethodLocal, AuthAPIKey)
+			c.Locals(apiKeyLocal, key)
+		}
+	}
+
+	return c.Next()
+}
+
+// user and session id behind an access token, both "" if the token is bad or its session was revoked or expired
+// err is only for the database falling over
+func (h *Handlers) sessionUser(c *fiber.Ctx, token string) (string, string, error) {
+	claims, err := jwt.Verify(token, jwt.AccessToken)
+	if err != nil || claims.Session == "" {
+		// tokens that aren't tied to a session can't be revoked, so they aren't accepted either
+		return "", "", nil
+	}
+
+	if _, err := h.Store.TouchSession(c.UserContext(), claims.Session, c.IP()); err != nil {
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrInvalidInput) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	return claims.Username(), claims.Session, nil
+}
+
+// rejects requests that Authenticate couldn't put a user on, any API key will do whatever its scopes
+func (h *Handlers) RequireAuth(c *fiber.Ctx) error {
+	if Username(c) == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "not signed in", Status: fiber.StatusUnauthorized})
+	}
+
+	return c.Next()
+}
+
+// signed in with a JWT, for things an API key shouldn't be able to do whatever its scopes, like minting more keys
+func (h *Handlers) RequireJWT(c *fiber.Ctx) error {
+	if Username(c) == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "not signed in", Status: fiber.StatusUnauthorized})
+	}
+	if Method(c) != AuthJWT {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API keys can't be used here, sign in on the website"})
+	}
+
+	return c.Next()
+}
+
+// RequireAuth, plus an API key needs scope (a JWT has every scope)
+func (h *Handlers) RequireScope(scope db.Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Username(c) == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "not signed in", Status: fiber.StatusUnauthorized})
+		}
+		if !hasScope(c, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this API key doesn't have the " + string(scope) + " scope"})
+		}
+
+		return c.Next()
+	}
+}
+
+// true unless the request came in with an API key that lacks scope
+func hasScope(c *fiber.Ctx, scope db.Scope) bool {
+	key, ok := c.Locals(apiKeyLocal).(db.APIKey)
+	return !ok || key.HasScope(scope)
+}
+
+// RequireScope(db.ScopeAdmin), plus the user needs at least min in the admins table (see db.Role)
+// and, with an API key, two-factor turned on
+func (h *Handlers) RequireRole(min db.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Username(c) == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "not signed in", Status: fiber.StatusUnauthorized})
+		}
+		if !hasScope(c, db.ScopeAdmin) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this API key doesn't have the admin scope"})
+		}
+
+		role, err := h.Role(c)
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+		if !role.AtLeast(min) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": string(min) + "s only"})
+		}
+
+		if ok, err := h.checkKeyTwoFactor(c); !ok {
+			return err
+		}
+
+		return c.Next()
+	}
+}
+
+// whether the request can do staff things, when ok is false the response has already been sent
+// a JWT only comes after the second factor, but a key skips it
+// so staff keys stop working while two-factor is off, even keys made before it was turned off
+func (h *Handlers) checkKeyTwoFactor(c *fiber.Ctx) (ok bool, err error) {
+	if Method(c) != AuthAPIKey {
+		return true, nil
+	}
+
+	twoFactor, err := h.Store.GetTwoFactor(c.UserContext(), db.User{Username: Username(c)})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return false, dbErrorResponse(c, err)
+	}
+	if !twoFactor.Enabled {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "turn on two-factor authentication to use an API key with the admin scope"})
+	}
+	return true, nil
+}
+
+// RequireRole(db.RoleAdmin)
+func (h *Handlers) RequireAdmin(c *fiber.Ctx) error {
+	return h.RequireRole(db.RoleAdmin)(c)
+}
+
+// role of the signed in user, db.RoleUser when signed out
+// looked up once per request, RequireRole already has it so handlers behind it don't hit the database again
+func (h *Handlers) Role(c *fiber.Ctx) (db.Role, error) {
+	if role, ok := c.Locals(roleLocal).(db.Role); ok {
+		return role, nil
+	}
+
+	username := Username(c)
+	if username == "" {
+		return db.RoleUser, nil
+	}
+
+	role, err := h.Store.GetRole(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return "", err
+	}
+	c.Locals(roleLocal, role)
+	return role, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/html"
+)
+
+// GET /status
+func (h *Handlers) Status(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"message": "Healthy", "status": 200})
+}
+
+// GET /fetchWebsiteTitle
+func (h *Handlers) FetchWebsiteTitle(c *fiber.Ctx) error {
+	url := c.Query("url")
+	if url == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Please pass a url parameter",
+		})
+	}
+
+	// make the request "object"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// set headers
+	req.Header.Set("User-Agent", "HackerNewsClone (+https://github.com/trentwiles/hackernews)")
+
+	// send the request object we just made
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	defer resp.Body.Close()
+
+	tokenizer := html.NewTokenizer(resp.Body)
+
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			log.Println("no title found/blocked")
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   true,
+				"message": "unable to fetch title for provided URL",
+			})
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "title" {
+				tokenizer.Next()
+				return c.JSON(fiber.Map{
+					"title": string(tokenizer.Text()),
+				})
+			}
+		}
+	}
+
+}
+
+// GET /urlCheck
+func (h *Handlers) URLCheck(c *fiber.Ctx) error {
+	q := c.Query("q")
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Please pass a `q` parameter",
+		})
+	}
+
+	// logic to inspect to the URL..
+	// Google Safebrowsing? VirusTotal? sounds like a future problem
+	num := rand.Intn(2)
+
+	return c.JSON(fiber.Map{
+		"status":          200,
+		"passed":          num == 1, // true = no malware, false = malware
+		"isAuthenticated": Username(c) != "",
+	})
+}
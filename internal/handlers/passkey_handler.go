@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// how long between asking the browser for a passkey and it answering, the prompt itself times out sooner
+const passkeyChallengeTTL = 5 * time.Minute
+
+// a user as go-webauthn sees them: a random handle rather than the username, so the authenticator never
+// stores anything that identifies them to another site, plus every passkey they've registered
+type passkeyUser struct {
+	handle      []byte
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (u passkeyUser) WebAuthnID() []byte                         { return u.handle }
+func (u passkeyUser) WebAuthnName() string                       { return u.username }
+func (u passkeyUser) WebAuthnDisplayName() string                { return u.username }
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// the user with handle along with their passkeys, decoded from what CreatePasskey/UsePasskey saved
+func (h *Handlers) loadPasskeyUser(c *fiber.Ctx, username string, handle []byte) (passkeyUser, error) {
+	passkeys, err := h.Store.ListPasskeys(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	user := passkeyUser{handle: handle, username: username}
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(passkey.Credential, &credential); err != nil {
+			log.Printf("[WARN] Skipping unreadable passkey %s for %s: %s\n", passkey.Id, username, err)
+			continue
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, nil
+}
+
+// saves a ceremony's session data until the browser answers, the id goes back to the client to pass along
+func (h *Handlers) savePasskeyChallenge(c *fiber.Ctx, username string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	return h.Store.CreatePasskeyChallenge(c.UserContext(), username, data, time.Now().Add(passkeyChallengeTTL))
+}
+
+// the session data saved by savePasskeyChallenge, it can only be taken once
+// ok is false when the response has already been sent
+func (h *Handlers) takePasskeyChallenge(c *fiber.Ctx, id string) (db.PasskeyChallenge, webauthn.SessionData, bool, error) {
+	var session webauthn.SessionData
+
+	challenge, err := h.Store.TakePasskeyChallenge(c.UserContext(), id)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrInvalidInput) {
+		return challenge, session, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "passkey challenge is unknown or expired, start again",
+		})
+	}
+	if err != nil {
+		return challenge, session, false, dbErrorResponse(c, err)
+	}
+
+	if err := json.Unmarshal(challenge.Data, &session); err != nil {
+		log.Printf("[WARN] Unreadable passkey challenge %s: %s\n", id, err)
+		return challenge, session, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+	return challenge, session, true, nil
+}
+
+// passkeys need WEBAUTHN_RP_ID, without it these routes say so rather than failing halfway through
+func (h *Handlers) RequirePasskeys(c *fiber.Ctx) error {
+	if h.WebAuthn == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "passkeys aren't set up on this server",
+		})
+	}
+	return c.Next()
+}
+
+// POST /passkeys/register/begin
+// JWT only, you have to have signed in with a magic link once before adding a passkey
+// hand options to navigator.credentials.create() and send the result to /passkeys/register/finish with challengeId
+func (h *Handlers) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	username := Username(c)
+
+	handle, err := h.Store.PasskeyHandle(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	user, err := h.loadPasskeyUser(c, username, handle)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	// resident keys are what make it a passkey, signing in doesn't ask for a username first
+	options, session, err := h.WebAuthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		log.Printf("[WARN] Unable to start passkey registration for %s: %s\n", username, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to start passkey registration"})
+	}
+
+	challengeId, err := h.savePasskeyChallenge(c, username, session)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{"challengeId": challengeId, "options": options})
+}
+
+// POST /passkeys/register/finish
+func (h *Handlers) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	var req PasskeyRegisterRequest
+
+	if err := c.BodyParser(&req); err != nil || req.ChallengeId == "" || len(req.Credential) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challengeId and credential are required",
+		})
+	}
+	if req.Name == "" {
+		req.Name = "passkey"
+	}
+
+	username := Username(c)
+	challenge, session, ok, err := h.takePasskeyChallenge(c, req.ChallengeId)
+	if !ok {
+		return err
+	}
+	if challenge.Username != username {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "passkey challenge is unknown or expired, start again",
+		})
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse credential"})
+	}
+
+	handle, err := h.Store.PasskeyHandle(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	user, err := h.loadPasskeyUser(c, username, handle)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	credential, err := h.WebAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "passkey couldn't be verified"})
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		log.Printf("[WARN] Unable to encode passkey for %s: %s\n", username, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+
+	passkey, err := h.Store.CreatePasskey(c.UserContext(), db.User{Username: username}, req.Name, credential.ID, data)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.PasskeyEvent, map[string]string{"action": "add", "passkey": passkey.Id, "name": passkey.Name})
+
+	return c.JSON(fiber.Map{"passkey": passkey})
+}
+
+// POST /passkeys/login/begin
+// nobody's signed in yet, so the browser offers whichever passkeys it has for this site
+// hand options to navigator.credentials.get() and send the result to /passkeys/login/finish with challengeId
+func (h *Handlers) BeginPasskeyLogin(c *fiber.Ctx) error {
+	options, session, err := h.WebAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		log.Printf("[WARN] Unable to start passkey sign in: %s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to start passkey sign in"})
+	}
+
+	challengeId, err := h.savePasskeyChallenge(c, "", session)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{"challengeId": challengeId, "options": options})
+}
+
+// POST /passkeys/login/finish
+// responds like /magic, with an access token and a refresh token or a twoFactorToken
+func (h *Handlers) FinishPasskeyLogin(c *fiber.Ctx) error {
+	var req PasskeyLoginRequest
+
+	if err := c.BodyParser(&req); err != nil || req.ChallengeId == "" || len(req.Credential) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challengeId and credential are required",
+		})
+	}
+
+	challenge, session, ok, err := h.takePasskeyChallenge(c, req.ChallengeId)
+	if !ok {
+		return err
+	}
+	// a registration challenge can't be used to sign in
+	if challenge.Username != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "passkey challenge is unknown or expired, start again",
+		})
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse credential"})
+	}
+
+	found, credential, err := h.WebAuthn.ValidatePasskeyLogin(func(rawId, userHandle []byte) (webauthn.User, error) {
+		username, err := h.Store.PasskeyOwner(c.UserContext(), userHandle)
+		if err != nil {
+			return nil, err
+		}
+		return h.loadPasskeyUser(c, username, userHandle)
+	}, session, parsed)
+	if err != nil {
+		h.recordAudit(c, "", db.FailedLogin, map[string]string{"reason": "passkey", "method": "passkey"})
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "passkey couldn't be verified", Status: fiber.StatusUnauthorized})
+	}
+
+	username := found.WebAuthnName()
+
+	// the signature counter went backwards, so there may be two copies of the key
+	if credential.Authenticator.CloneWarning {
+		h.recordAudit(c, username, db.FailedLogin, map[string]string{"reason": "passkey may be cloned", "method": "passkey"})
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "passkey couldn't be verified", Status: fiber.StatusUnauthorized})
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		log.Printf("[WARN] Unable to encode passkey for %s: %s\n", username, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+	passkey, err := h.Store.UsePasskey(c.UserContext(), credential.ID, data)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return h.signIn(c, username, map[string]string{"method": "passkey", "passkey": passkey.Id})
+}
+
+// GET /passkeys
+// the signed in user's passkeys, oldest first
+func (h *Handlers) ListPasskeys(c *fiber.Ctx) error {
+	passkeys, err := h.Store.ListPasskeys(c.UserContext(), db.User{Username: Username(c)})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{"passkeys": passkeys})
+}
+
+// DELETE /passkeys?id=<passkey id>
+// magic links keep working, so removing the last passkey doesn't lock anybody out
+func (h *Handlers) DeletePasskey(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing passkey `id`",
+		})
+	}
+
+	username := Username(c)
+	if err := h.Store.DeletePasskey(c.UserContext(), db.User{Username: username}, id); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.PasskeyEvent, map[string]string{"action": "remove", "passkey": id})
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// POST /flag
+func (h *Handlers) Flag(c *fiber.Ctx) error {
+	username := Username(c)
+
+	var req FlagRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.Id == "" || req.Type == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "id and type parameters required to make a valid report",
+		})
+	}
+
+	var flagErr error
+	var weight float64
+	var isFlagged bool
+
+	switch req.Type {
+	case "comment":
+		weight, isFlagged, flagErr = h.Store.ReportComment(c.UserContext(), db.Comment{Id: req.Id}, db.User{Username: username})
+	case "submission":
+		weight, isFlagged, flagErr = h.Store.ReportSubmission(c.UserContext(), db.User{Username: username}, db.Submission{Id: req.Id})
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "type is invalid",
+		})
+	}
+
+	if flagErr != nil {
+		return dbErrorResponse(c, flagErr)
+	}
+	h.recordAudit(c, username, db.ReportEvent, map[string]string{req.Type: req.Id, "flagged": strconv.FormatBool(isFlagged)})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"currentWeight": weight,
+		"isFlagged":     isFlagged,
+	})
+}
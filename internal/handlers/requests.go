@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// JSON bodies the handlers accept, plus the plain {Message, Status} reply
+type BasicResponse struct {
+	Message string
+	Status  int
+}
+
+type LoginRequest struct {
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	CaptchaToken string `json:"captchaToken"`
+}
+
+type SubmissionRequest struct {
+	Link         string `json:"link"`
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	CaptchaToken string `json:"captchaToken"`
+}
+
+type SubmissionDeleteRequest struct {
+	Id string `json:"id"`
+}
+
+type CommentCreationRequest struct {
+	InResponseTo string `json:"inResponseTo"`
+	Content      string `json:"content"`
+	CaptchaToken string `json:"captchaToken"`
+}
+
+// username VARCHAR(100) PRIMARY KEY,
+// full_name VARCHAR(100),
+// birthdate DATE,
+// bio_text TEXT,
+
+type BioUpdateRequest struct {
+	FullName  string `json:"fullName"`  // full_name
+	Birthdate string `json:"birthdate"` // birthdate
+	BioText   string `json:"bioText"`   // bio_text
+}
+
+type VoteRequest struct {
+	Id     string `json:"id"`
+	Upvote bool   `json:"upvote"`
+	Action string `json:"action"` // "vote" (default) or "unvote" to take a vote back, Upvote is ignored for unvote
+}
+
+type FlagRequest struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`      // defaults to "default"
+	Scopes    []db.Scope `json:"scopes"`    // defaults to everything but admin
+	ExpiresAt string     `json:"expiresAt"` // RFC 3339, "" for a key that never expires
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// second half of adding a passkey, Credential is what navigator.credentials.create() resolved with
+type PasskeyRegisterRequest struct {
+	ChallengeId string          `json:"challengeId"`
+	Name        string          `json:"name"` // defaults to "passkey"
+	Credential  json.RawMessage `json:"credential"`
+}
+
+// second half of signing in with a passkey, Credential is what navigator.credentials.get() resolved with
+type PasskeyLoginRequest struct {
+	ChallengeId string          `json:"challengeId"`
+	Credential  json.RawMessage `json:"credential"`
+}
+
+// proves the authenticator app is at hand, one of the two is needed
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`         // six digits from the app
+	RecoveryCode string `json:"recoveryCode"` // one of the codes handed out when it was turned on, each works once
+}
+
+// second step of signing in, Token is the twoFactorToken from /magic or /passkeys/login/finish
+type TwoFactorLoginRequest struct {
+	Token string `json:"token"`
+	TwoFactorCodeRequest
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/jwt"
+)
+
+// a session as /sessions shows it, Current is the one making the request
+type sessionResponse struct {
+	db.Session
+	Current bool
+}
+
+// POST /refresh
+// trades a refresh token for a new access token and a new refresh token, the old refresh token stops working
+func (h *Handlers) Refresh(c *fiber.Ctx) error {
+	var req RefreshRequest
+
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "must pass a refreshToken",
+		})
+	}
+
+	session, refreshToken, err := h.Store.RefreshSession(c.UserContext(), req.RefreshToken, c.IP(), c.Get(fiber.HeaderUserAgent), time.Now().Add(h.SessionExpiresIn))
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "refresh token is invalid or expired, sign in again", Status: fiber.StatusUnauthorized})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	// staff who signed in before two-factor was required of them (or were made staff since) have to sign in again
+	twoFactor, mandatory, err := h.twoFactorFor(c.UserContext(), session.Username)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if mandatory && !twoFactor.Enabled {
+		if err := h.Store.RevokeSession(c.UserContext(), db.User{Username: session.Username}, session.Id); err != nil {
+			return dbErrorResponse(c, err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "staff accounts need two-factor, sign in again to set it up", Status: fiber.StatusUnauthorized})
+	}
+
+	return h.issueTokens(c, session, refreshToken, nil)
+}
+
+// responds with a fresh access token for session alongside its refresh token and anything in extra, for every sign in and /refresh
+// the token carries the user's role as it is now, the server itself always checks the database
+func (h *Handlers) issueTokens(c *fiber.Ctx, session db.Session, refreshToken string, extra fiber.Map) error {
+	role, err := h.Store.GetRole(c.UserContext(), db.User{Username: session.Username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	jwtToken, err := jwt.GenerateSessionJWT(session.Username, session.Id, string(role), h.TokenExpiresIn)
+	if err != nil {
+		log.Printf("[WARN] Failed to sign JWT for %s: %s\n", session.Username, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "unable to issue token"})
+	}
+
+	response := fiber.Map{"username": session.Username, "token": jwtToken, "refreshToken": refreshToken, "expiresIn": h.TokenExpiresIn * 60}
+	for key, value := range extra {
+		response[key] = value
+	}
+	return c.JSON(response)
+}
+
+// POST /logout
+// ends the session the request was made with, its access and refresh tokens stop working straight away
+func (h *Handlers) Logout(c *fiber.Ctx) error {
+	username := Username(c)
+
+	if err := h.Store.RevokeSession(c.UserContext(), db.User{Username: username}, Session(c)); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.Logout, map[string]string{"session": Session(c)})
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// POST /logoutAll
+// every device, including this one
+func (h *Handlers) LogoutAll(c *fiber.Ctx) error {
+	username := Username(c)
+
+	revoked, err := h.Store.RevokeAllSessions(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.Logout, map[string]string{"sessions": strconv.Itoa(revoked), "all": "true"})
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"revoked": revoked,
+	})
+}
+
+// GET /sessions
+// the signed in user's devices, most recently seen first
+func (h *Handlers) Sessions(c *fiber.Ctx) error {
+	sessions, err := h.Store.ListSessions(c.UserContext(), db.User{Username: Username(c)})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	results := []sessionResponse{}
+	for _, session := range sessions {
+		results = append(results, sessionResponse{Session: session, Current: session.Id == Session(c)})
+	}
+
+	return c.JSON(fiber.Map{"sessions": results})
+}
+
+// DELETE /sessions?id=<session id>
+// signs out one device
+func (h *Handlers) RevokeSession(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing session `id`",
+		})
+	}
+
+	username := Username(c)
+	if err := h.Store.RevokeSession(c.UserContext(), db.User{Username: username}, id); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.Logout, map[string]string{"session": id})
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// GET /.well-known/jwks.json
+// public keys our access tokens can be checked with, cached for a few minutes so rotating shows up quickly
+func (h *Handlers) JWKS(c *fiber.Ctx) error {
+	keyring, err := jwt.Default()
+	if err != nil {
+		log.Printf("[WARN] Unable to load JWT keys: %s\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "internal server error"})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(keyring.JWKS())
+}
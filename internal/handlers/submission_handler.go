@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/utils"
+)
+
+// POST /submit
+func (h *Handlers) Submit(c *fiber.Ctx) error {
+	var req SubmissionRequest
+
+	username := Username(c)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	// captchaToken is checked below, trusted users can leave it out
+	if req.Link == "" || req.Title == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing one or more of the following parameters: title, link",
+		})
+	}
+
+	// is the link valid (passes regex and length restriction?)
+	if !utils.IsValidURL(req.Link) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid URL (failed regex)",
+		})
+	}
+
+	if len(req.Link) > 255 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid URL (exceeds 255 char limit)",
+		})
+	}
+
+	if !h.captchaPassed(c, CaptchaSubmit, req.CaptchaToken) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid captcha response, try again later",
+		})
+	}
+
+	// passed all checks and restrictions now insert into database
+	id, err := h.Store.CreateSubmission(c.UserContext(), db.Submission{Title: req.Title, Username: username, Body: req.Body, Link: req.Link})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.Post, map[string]string{"submission": id})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id": id,
+	})
+}
+
+// GET /submission
+// aka get the metadata and votes on a post
+func (h *Handlers) GetSubmission(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Please pass an id parameter"})
+	}
+
+	queriedSubmission, err := h.Store.SearchSubmission(c.UserContext(), db.Submission{Id: id})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	votes, err := h.Store.CountVotes(c.UserContext(), db.Submission{Id: id})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"id": queriedSubmission.Id,
+		"metadata": fiber.Map{
+			"title":     queriedSubmission.Title,
+			"link":      queriedSubmission.Link,
+			"body":      queriedSubmission.Body,
+			"author":    queriedSubmission.Username,
+			"isFlagged": queriedSubmission.Flagged,
+			"createdAt": queriedSubmission.Created_at,
+		},
+		"votes": fiber.Map{
+			"upvotes":   votes.Upvotes,
+			"downvotes": votes.Downvotes,
+			"total":     votes.Upvotes - votes.Downvotes,
+		},
+	})
+}
+
+// GET /all
+// grab all the submissions to display on the front page
+func (h *Handlers) AllSubmissions(c *fiber.Ctx) error {
+	sortType := c.Query("sort")
+	if sortType == "" {
+		sortType = "hot"
+	}
+
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	var selection []db.Submission
+	var next string
+
+	switch sortType {
+	case "latest":
+		// ORDER BY created_time DESC
+		selection, next, err = h.Store.AllSubmissions(c.UserContext(), db.Latest, page)
+	case "best":
+		// net votes, all time
+		selection, next, err = h.Store.AllSubmissions(c.UserContext(), db.Best, page)
+	case "oldest":
+		// ORDER BY created_time ASC
+		selection, next, err = h.Store.AllSubmissions(c.UserContext(), db.Oldest, page)
+	case "hot", "ranked":
+		// votes decayed by age, HN style
+		selection, next, err = h.Store.AllSubmissions(c.UserContext(), db.Hot, page)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": "invalid sort filter",
+		})
+	}
+
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return pageResponse(c, selection, next)
+}
+
+// GET /userSubmissions
+func (h *Handlers) UserSubmissions(c *fiber.Ctx) error {
+	username := c.Query("username")
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Please pass a username parameter",
+		})
+	}
+
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   true,
+			"message": err.Error(),
+		})
+	}
+
+	var tempUser db.User = db.User{Username: username}
+
+	search, next, err := h.Store.LatestUserSubmissions(c.UserContext(), page, tempUser)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return pageResponse(c, search, next)
+}
+
+// DELETE /submission
+func (h *Handlers) DeleteSubmission(c *fiber.Ctx) error {
+	username := Username(c)
+
+	var req SubmissionDeleteRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.Id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "must pass a valid id parameter",
+		})
+	}
+
+	// first check if submission matches the requested username
+	query, err := h.Store.SearchSubmission(c.UserContext(), db.Submission{Id: req.Id})
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No such submission (has it already been deleted?)",
+		})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	moderating, ok, err := h.checkCanRemove(c, query.Username, query.Flagged)
+	if !ok {
+		return err
+	}
+
+	fmt.Printf("debug - deleted a post with id %s by user %s", req.Id, username)
+	if err := h.Store.DeleteSubmission(c.UserContext(), query); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	metadata := map[string]string{"submission": req.Id, "title": query.Title}
+	if moderating {
+		metadata["author"] = query.Username
+	}
+	h.recordAudit(c, username, db.DeleteEvent, metadata)
+
+	return c.JSON(fiber.Map{
+		"message": "OK",
+	})
+}
+
+// GET /searchSubmissions
+func (h *Handlers) SearchSubmissions(c *fiber.Ctx) error {
+	q := c.Query("q")
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Please pass a `q` parameter",
+		})
+	}
+
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	// submissions only unless asked otherwise, it's the searchSubmissions endpoint after all
+	query := db.SearchQuery{
+		Text:   q,
+		Type:   db.SearchType(c.Query("type", string(db.SearchSubmissions))),
+		Author: c.Query("author"),
+		Domain: c.Query("domain"),
+	}
+
+	query.After, query.Before, err = timeRangeFromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	results, next, err := h.Store.Search(c.UserContext(), query, page)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return pageResponse(c, results, next)
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/totp"
+)
+
+// how long between the magic link (or passkey) and typing in the code
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// what authenticator apps show the account under
+const totpIssuer = "Hacker News Clone"
+
+// a user's two-factor settings and whether they have to use it, staff always do
+func (h *Handlers) twoFactorFor(ctx context.Context, username string) (twoFactor db.TwoFactor, mandatory bool, err error) {
+	twoFactor, err = h.Store.GetTwoFactor(ctx, db.User{Username: username})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return twoFactor, false, err
+	}
+
+	role, err := h.Store.GetRole(ctx, db.User{Username: username})
+	if err != nil {
+		return twoFactor, false, err
+	}
+	return twoFactor, role.AtLeast(db.RoleModerator), nil
+}
+
+// the end of every sign in, once the magic link or passkey has checked out
+// responds with tokens, unless a second factor is needed first: then with a twoFactorToken for /login/2fa
+func (h *Handlers) signIn(c *fiber.Ctx, username string, audit map[string]string) error {
+	twoFactor, mandatory, err := h.twoFactorFor(c.UserContext(), username)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	if twoFactor.Enabled || mandatory {
+		token, err := h.Store.CreateTwoFactorChallenge(c.UserContext(), db.User{Username: username}, time.Now().Add(twoFactorChallengeTTL))
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+
+		// staff who haven't set it up yet do that now, through /login/2fa/setup
+		return c.JSON(fiber.Map{"username": username, "twoFactorRequired": true, "twoFactorToken": token, "setupRequired": !twoFactor.Enabled})
+	}
+
+	return h.startSession(c, username, audit, nil)
+}
+
+// starts a session for username, records the sign in and responds with its tokens plus anything in extra
+func (h *Handlers) startSession(c *fiber.Ctx, username string, audit map[string]string, extra fiber.Map) error {
+	session, refreshToken, err := h.Store.CreateSession(c.UserContext(), db.User{Username: username}, c.IP(), c.Get(fiber.HeaderUserAgent), time.Now().Add(h.SessionExpiresIn))
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	audit["session"] = session.Id
+	h.recordAudit(c, username, db.Login, audit)
+
+	return h.issueTokens(c, session, refreshToken, extra)
+}
+
+// checks a code from the app, or uses up a recovery code, for a user with two-factor on
+// ok is false for a wrong or reused code, how is "totp" or "recovery code" for the audit log
+func (h *Handlers) checkSecondFactor(c *fiber.Ctx, username string, twoFactor db.TwoFactor, req TwoFactorCodeRequest) (how string, ok bool, err error) {
+	user := db.User{Username: username}
+
+	if req.RecoveryCode != "" {
+		err := h.Store.UseRecoveryCode(c.UserContext(), user, req.RecoveryCode)
+		if errors.Is(err, db.ErrNotFound) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+
+		h.recordAudit(c, username, db.TwoFactorEvent, map[string]string{"action": "recovery code used"})
+		return "recovery code", true, nil
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+	if !ok {
+		return "", false, nil
+	}
+
+	// each code works once, so one read over someone's shoulder can't be used again
+	err = h.Store.UseTOTPStep(c.UserContext(), user, step)
+	if errors.Is(err, db.ErrConflict) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return "totp", true, nil
+}
+
+// saves a new secret for username and responds with it, for adding to an authenticator app
+func (h *Handlers) setupTOTP(c *fiber.Ctx, username string) error {
+	secret := totp.NewSecret()
+	if err := h.Store.SetupTOTP(c.UserContext(), db.User{Username: username}, secret); err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{"secret": secret, "uri": totp.URI(secret, totpIssuer, username)})
+}
+
+// POST /login/2fa
+// finishes a sign in that needs a second factor, responds like /magic
+// staff setting it up for the first time get their recovery codes here as well
+func (h *Handlers) TwoFactorLogin(c *fiber.Ctx) error {
+	var req TwoFactorLoginRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Token == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "token and either code or recoveryCode are required",
+		})
+	}
+
+	username, err := h.Store.GetTwoFactorChallenge(c.UserContext(), req.Token)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrInvalidInput) {
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "sign in has expired, use a new magic link", Status: fiber.StatusUnauthorized})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	twoFactor, _, err := h.twoFactorFor(c.UserContext(), username)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	var how string
+	var ok bool
+	var recoveryCodes []string
+	switch {
+	case twoFactor.Enabled:
+		how, ok, err = h.checkSecondFactor(c, username, twoFactor, req.TwoFactorCodeRequest)
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+	case twoFactor.Secret != "":
+		// the first code from a secret set up through /login/2fa/setup turns it on
+		step, valid := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+		if valid {
+			recoveryCodes, err = h.Store.EnableTOTP(c.UserContext(), db.User{Username: username}, step)
+			if err != nil {
+				return dbErrorResponse(c, err)
+			}
+			h.recordAudit(c, username, db.TwoFactorEvent, map[string]string{"action": "enable"})
+			how, ok = "totp", true
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "two-factor isn't set up yet, call /login/2fa/setup first",
+		})
+	}
+
+	if !ok {
+		left, err := h.Store.FailTwoFactorChallenge(c.UserContext(), req.Token)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return dbErrorResponse(c, err)
+		}
+		h.recordAudit(c, username, db.FailedLogin, map[string]string{"reason": "wrong two-factor code"})
+
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "wrong code", "attemptsLeft": left})
+	}
+
+	if _, err := h.Store.FinishTwoFactorChallenge(c.UserContext(), req.Token); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "sign in has expired, use a new magic link", Status: fiber.StatusUnauthorized})
+		}
+		return dbErrorResponse(c, err)
+	}
+
+	var extra fiber.Map
+	if recoveryCodes != nil {
+		extra = fiber.Map{"recoveryCodes": recoveryCodes}
+	}
+	return h.startSession(c, username, map[string]string{"twoFactor": how}, extra)
+}
+
+// POST /login/2fa/setup
+// body {"token": "<twoFactorToken>"}, for staff signing in without two-factor set up: responds with a secret
+// to add to an authenticator app, the first code from it goes to /login/2fa
+func (h *Handlers) TwoFactorLoginSetup(c *fiber.Ctx) error {
+	var req TwoFactorLoginRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "token is required",
+		})
+	}
+
+	username, err := h.Store.GetTwoFactorChallenge(c.UserContext(), req.Token)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrInvalidInput) {
+		return c.Status(fiber.StatusUnauthorized).JSON(BasicResponse{Message: "sign in has expired, use a new magic link", Status: fiber.StatusUnauthorized})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return h.setupTOTP(c, username)
+}
+
+// GET /2fa
+// whether two-factor is on for the signed in user, and whether they're allowed to turn it off
+func (h *Handlers) TwoFactorStatus(c *fiber.Ctx) error {
+	twoFactor, mandatory, err := h.twoFactorFor(c.UserContext(), Username(c))
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled":           twoFactor.Enabled,
+		"enabledAt":         twoFactor.EnabledAt,
+		"recoveryCodesLeft": twoFactor.RecoveryCodesLeft,
+		"required":          mandatory,
+	})
+}
+
+// POST /2fa/setup
+// responds with a new secret for an authenticator app, it isn't used until /2fa/enable
+func (h *Handlers) SetupTwoFactor(c *fiber.Ctx) error {
+	return h.setupTOTP(c, Username(c))
+}
+
+// POST /2fa/enable
+// body {"code": "123456"} from the app, proving it was added properly, responds with the recovery codes
+func (h *Handlers) EnableTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	username := Username(c)
+	twoFactor, err := h.Store.GetTwoFactor(c.UserContext(), db.User{Username: username})
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "call /2fa/setup first",
+		})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if twoFactor.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "two-factor is already on",
+		})
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "wrong code, check the time on your phone is right",
+		})
+	}
+
+	recoveryCodes, err := h.Store.EnableTOTP(c.UserContext(), db.User{Username: username}, step)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.TwoFactorEvent, map[string]string{"action": "enable"})
+
+	return c.JSON(fiber.Map{"recoveryCodes": recoveryCodes, "comment": "Store these recovery codes in a safe place, they won't be shown again."})
+}
+
+// POST /2fa/disable
+// needs a code (or a recovery code) once it's on, staff can't turn it off
+func (h *Handlers) DisableTwoFactor(c *fiber.Ctx) error {
+	username := Username(c)
+
+	twoFactor, mandatory, err := h.twoFactorFor(c.UserContext(), username)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if mandatory && twoFactor.Enabled {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "staff accounts have to keep two-factor on",
+		})
+	}
+
+	// one that was set up and never enabled is simply thrown away
+	if twoFactor.Enabled {
+		var req TwoFactorCodeRequest
+		if err := c.BodyParser(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "code or recoveryCode is required",
+			})
+		}
+
+		_, ok, err := h.checkSecondFactor(c, username, twoFactor, req)
+		if err != nil {
+			return dbErrorResponse(c, err)
+		}
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wrong code"})
+		}
+	}
+
+	if err := h.Store.DisableTOTP(c.UserContext(), db.User{Username: username}); err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if twoFactor.Enabled {
+		h.recordAudit(c, username, db.TwoFactorEvent, map[string]string{"action": "disable"})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// POST /2fa/recoveryCodes
+// needs a code from the app, responds with a new set of recovery codes and the old ones stop working
+func (h *Handlers) ReplaceRecoveryCodes(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	username := Username(c)
+	twoFactor, _, err := h.twoFactorFor(c.UserContext(), username)
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if !twoFactor.Enabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "two-factor isn't on",
+		})
+	}
+
+	// a recovery code can't be traded for a whole new set
+	_, ok, err := h.checkSecondFactor(c, username, twoFactor, TwoFactorCodeRequest{Code: req.Code})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wrong code"})
+	}
+
+	recoveryCodes, err := h.Store.ReplaceRecoveryCodes(c.UserContext(), db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+	h.recordAudit(c, username, db.TwoFactorEvent, map[string]string{"action": "replace recovery codes"})
+
+	return c.JSON(fiber.Map{"recoveryCodes": recoveryCodes, "comment": "Store these recovery codes in a safe place, they won't be shown again."})
+}
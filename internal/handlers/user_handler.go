@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/utils"
+)
+
+// POST /bio
+func (h *Handlers) UpdateBio(c *fiber.Ctx) error {
+	var req BioUpdateRequest
+
+	username := Username(c)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.BioText == "" {
+		fmt.Println("debug note: bio text is empty")
+	}
+
+	if req.Birthdate == "" {
+		fmt.Println("debug note: birth date is empty")
+	}
+
+	if !utils.IsValidDateFormat(req.Birthdate) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Birth date fails regex. Not in American format, MM-DD-YYYY.",
+		})
+	}
+
+	if req.FullName == "" {
+		fmt.Println("debug note: full name is empty")
+	}
+
+	if len(req.FullName) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Full name cannot be longer than 100 chars",
+		})
+	}
+
+	// all validations have passed
+	var meta db.UserMetadata = db.UserMetadata{Username: username, Full_name: req.FullName, Birthdate: req.Birthdate, Bio_text: req.BioText}
+	if err := h.Store.UpsertUserMetadata(c.UserContext(), meta); err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Updated metadata for user " + username,
+	})
+}
+
+// GET /user
+func (h *Handlers) GetUser(c *fiber.Ctx) error {
+	username := c.Query("username")
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Please pass a username parameter",
+		})
+	}
+
+	var user db.User
+	var userMetadata db.UserMetadata
+
+	complete, err := h.Store.SearchUser(c.UserContext(), db.User{Username: username})
+	if errors.Is(err, db.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   true,
+			"message": "No such user or account deleted",
+		})
+	}
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	user = complete.User
+	userMetadata = complete.Metadata
+
+	return c.JSON(fiber.Map{
+		"username": user.Username,
+		"email":    user.Email,
+		"joined":   user.Created_at,
+		"metadata": fiber.Map{
+			"full_name": userMetadata.Full_name,
+			"birthday":  userMetadata.Birthdate,
+			"bio":       userMetadata.Bio_text,
+			"isAdmin":   userMetadata.IsAdmin,
+			"score":     user.Score,
+		},
+	})
+}
+
+// GET /me
+// shorthand for /api/v1/user?username=<authenticated_user>
+func (h *Handlers) Me(c *fiber.Ctx) error {
+	// relative to wherever the routes are mounted, /api/v1/me -> /api/v1/user
+	return c.Redirect(strings.TrimSuffix(c.Path(), "/me") + "/user?username=" + Username(c))
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+)
+
+// GET /vote
+// determine if a user has voted on a post, and if they have
+// whether it is an upvote or downvote
+func (h *Handlers) GetVote(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Please pass an id parameter"})
+	}
+
+	username := Username(c)
+
+	didVote, didUpvote, err := h.Store.GetUserVote(c.UserContext(), db.User{Username: username}, db.Submission{Id: id})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	if !didVote {
+		return c.JSON(fiber.Map{
+			"didVote": false,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"didVote":   true,
+		"didUpvote": didUpvote,
+	})
+}
+
+// POST /vote
+func (h *Handlers) Vote(c *fiber.Ctx) error {
+	var req VoteRequest
+
+	username := Username(c)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.Id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "missing valid id parameter",
+		})
+	}
+
+	var result db.VoteResult
+	var err error
+	switch req.Action {
+	case "", "vote":
+		result, err = h.Store.Vote(c.UserContext(), db.User{Username: username}, db.Submission{Id: req.Id}, req.Upvote)
+	case "unvote":
+		result, err = h.Store.Unvote(c.UserContext(), db.User{Username: username}, db.Submission{Id: req.Id})
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "action must be 'vote' or 'unvote'",
+		})
+	}
+
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	if result.Changed {
+		h.recordAudit(c, username, db.VoteEvent, map[string]string{"submission": req.Id, "action": voteAction(req)})
+	}
+
+	// voteSuccess is false for a double vote, or unvoting a post that wasn't voted on
+	return c.JSON(fiber.Map{"id": req.Id, "voteSuccess": result.Changed, "score": result.Score})
+}
+
+// GET /allUserVotes
+func (h *Handlers) AllUserVotes(c *fiber.Ctx) error {
+	username := c.Query("username")
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Please pass a username parameter"})
+	}
+
+	page, err := h.Paging.FromQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	posts, next, err := h.Store.GetAllUserVotes(c.UserContext(), page, db.User{Username: username})
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	return pageResponse(c, posts, next)
+}
+
+// POST /commentVote
+func (h *Handlers) CommentVote(c *fiber.Ctx) error {
+	var req VoteRequest
+
+	username := Username(c)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "cannot parse JSON",
+		})
+	}
+
+	if req.Id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "missing valid comment `id` parameter",
+		})
+	}
+
+	var result db.VoteResult
+	var err error
+	switch req.Action {
+	case "", "vote":
+		result, err = h.Store.VoteOnComment(c.UserContext(), db.User{Username: username}, db.Comment{Id: req.Id}, req.Upvote)
+	case "unvote":
+		result, err = h.Store.UnvoteComment(c.UserContext(), db.User{Username: username}, db.Comment{Id: req.Id})
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "action must be 'vote' or 'unvote'",
+		})
+	}
+
+	if err != nil {
+		return dbErrorResponse(c, err)
+	}
+
+	if result.Changed {
+		h.recordAudit(c, username, db.VoteEvent, map[string]string{"comment": req.Id, "action": voteAction(req)})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": result.Changed,
+		"score":   result.Score,
+	})
+}
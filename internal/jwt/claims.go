@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/trentwiles/hackernews/internal/config"
+)
+
+// what a token is for, checked on every verify so one kind can't be passed off as another
+type TokenType string
+
+// the only kind of JWT we hand out, magic links and refresh tokens are random strings looked up in
+// the database (see db.CreateMagicLink and db.CreateSession) so they can't be mistaken for one of these
+const AccessToken TokenType = "access"
+
+// tokens issued before this many seconds ago are still fine if the clocks disagree a little
+const clockSkew = time.Minute
+
+// everything we put in a token
+// the user is the subject, iss and aud say it came from us and is meant for us (JWT_ISSUER and JWT_AUDIENCE),
+// jti is unique per token so one can be picked out of the logs
+type Claims struct {
+	Type    TokenType `json:"typ"`
+	Session string    `json:"sid,omitempty"`  // db.Session the token belongs to, revoking it kills the token
+	Role    string    `json:"role,omitempty"` // user, moderator or admin when the token was issued, for clients to show or hide things
+	jwt.RegisteredClaims
+}
+
+// who the token was issued to
+func (c *Claims) Username() string {
+	return c.Subject
+}
+
+// called by the parser after it's checked exp, nbf, iat, iss and aud
+func (c *Claims) Validate() error {
+	if c.Subject == "" {
+		return fmt.Errorf("token has no subject")
+	}
+	if c.ID == "" {
+		return fmt.Errorf("token has no jti")
+	}
+	if c.Type == "" {
+		return fmt.Errorf("token has no type")
+	}
+	return nil
+}
+
+// claims for a token of typ for username, good for expiresIn
+func NewClaims(typ TokenType, username string, expiresIn time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		Type: typ,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer(),
+			Subject:   username,
+			Audience:  jwt.ClaimStrings{audience()},
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			NotBefore: jwt.NewNumericDate(now.Add(-clockSkew)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
+	}
+}
+
+// signs claims with the current key in the default keyring
+func Sign(claims *Claims) (string, error) {
+	keyring, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Sign(claims)
+}
+
+// checks the signature against the default keyring, then that the token is ours, in date and a typ token
+func Verify(tokenString string, typ TokenType) (*Claims, error) {
+	keyring, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	err = keyring.Parse(tokenString, claims,
+		jwt.WithIssuer(issuer()),
+		jwt.WithAudience(audience()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	if claims.Type != typ {
+		return nil, fmt.Errorf("expected a %s token, got %s", typ, claims.Type)
+	}
+	return claims, nil
+}
+
+func issuer() string {
+	return config.GetEnvOrDefault("JWT_ISSUER", "hackernews")
+}
+
+func audience() string {
+	return config.GetEnvOrDefault("JWT_AUDIENCE", "hackernews")
+}
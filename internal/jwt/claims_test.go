@@ -0,0 +1,75 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// signs with a throwaway HMAC key for the length of the test
+func testKeyring(t *testing.T) *Keyring {
+	ring, err := NewKeyring(NewHMACKey("test", []byte("claims-test-secret")))
+	assert.Nil(t, err, "keyring")
+
+	SetDefault(ring)
+	t.Cleanup(func() { SetDefault(nil) })
+	return ring
+}
+
+func TestClaims(t *testing.T) {
+	testKeyring(t)
+
+	token, err := GenerateSessionJWT("trent", "session-1", "moderator", 5)
+	assert.Nil(t, err, "sign")
+
+	claims, err := Verify(token, AccessToken)
+	assert.Nil(t, err, "verify")
+	assert.Equal(t, "trent", claims.Username(), "subject")
+	assert.Equal(t, "session-1", claims.Session, "session")
+	assert.Equal(t, "moderator", claims.Role, "role")
+	assert.Equal(t, "hackernews", claims.Issuer, "issuer")
+	assert.Equal(t, jwt.ClaimStrings{"hackernews"}, claims.Audience, "audience")
+	assert.NotNil(t, claims.IssuedAt, "iat")
+
+	again, _ := GenerateSessionJWT("trent", "session-1", "moderator", 5)
+	other, _ := Verify(again, AccessToken)
+	assert.NotEqual(t, claims.ID, other.ID, "jti per token")
+
+	_, err = Verify(token, TokenType("magic"))
+	assert.NotNil(t, err, "wrong type")
+
+	t.Setenv("JWT_AUDIENCE", "someone-else")
+	_, err = Verify(token, AccessToken)
+	assert.NotNil(t, err, "wrong audience")
+	t.Setenv("JWT_AUDIENCE", "")
+
+	t.Setenv("JWT_ISSUER", "someone-else")
+	_, err = Verify(token, AccessToken)
+	assert.NotNil(t, err, "wrong issuer")
+}
+
+func TestClaimsRejects(t *testing.T) {
+	ring := testKeyring(t)
+
+	expired := NewClaims(AccessToken, "trent", -2*time.Minute)
+	token, _ := ring.Sign(expired)
+	_, err := Verify(token, AccessToken)
+	assert.NotNil(t, err, "expired")
+
+	// used to panic on the missing username
+	good := NewClaims(AccessToken, "trent", time.Minute)
+	for name, claims := range map[string]jwt.MapClaims{
+		"no subject": {"typ": "access", "iss": good.Issuer, "aud": good.Audience, "exp": good.ExpiresAt, "jti": "x"},
+		"no jti":     {"typ": "access", "iss": good.Issuer, "aud": good.Audience, "exp": good.ExpiresAt, "sub": "trent"},
+		"no type":    {"iss": good.Issuer, "aud": good.Audience, "exp": good.ExpiresAt, "sub": "trent", "jti": "x"},
+		"no expiry":  {"typ": "access", "iss": good.Issuer, "aud": good.Audience, "sub": "trent", "jti": "x"},
+		"old style":  {"username": "trent", "exp": good.ExpiresAt},
+	} {
+		token, _ := ring.Sign(claims)
+		claims, err := Verify(token, AccessToken)
+		assert.NotNil(t, err, name)
+		assert.Nil(t, claims, name)
+	}
+}
@@ -0,0 +1,282 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/trentwiles/hackernews/internal/config"
+)
+
+// smallest RSA key we'll sign or verify with
+const minRSABits = 2048
+
+// one signing key, tokens name it in their "kid" header
+// HMAC keys are a shared secret so they're never published, Ed25519 and RSA ones show up in the JWKS
+type Key struct {
+	Id     string
+	method jwt.SigningMethod
+	sign   any // nil for a key we only verify with, ie. a public key kept around after rotating
+	verify any
+}
+
+// HS256 with secret
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{Id: id, method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+}
+
+// reads an Ed25519 (EdDSA) or RSA (RS256) key out of PEM, a private key can sign, a public key can only verify
+func ParseKey(id string, pemBytes []byte) (Key, error) {
+	if private, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes); err == nil {
+		return Key{Id: id, method: jwt.SigningMethodEdDSA, sign: private, verify: private.(ed25519.PrivateKey).Public()}, nil
+	}
+	if public, err := jwt.ParseEdPublicKeyFromPEM(pemBytes); err == nil {
+		return Key{Id: id, method: jwt.SigningMethodEdDSA, verify: public}, nil
+	}
+
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes); err == nil {
+		if private.N.BitLen() < minRSABits {
+			return Key{}, fmt.Errorf("key %q: RSA keys need at least %d bits", id, minRSABits)
+		}
+		return Key{Id: id, method: jwt.SigningMethodRS256, sign: private, verify: &private.PublicKey}, nil
+	}
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes); err == nil {
+		if public.N.BitLen() < minRSABits {
+			return Key{}, fmt.Errorf("key %q: RSA keys need at least %d bits", id, minRSABits)
+		}
+		return Key{Id: id, method: jwt.SigningMethodRS256, verify: public}, nil
+	}
+
+	return Key{}, fmt.Errorf("key %q: not an Ed25519 or RSA key in PEM", id)
+}
+
+// "HS256", "EdDSA" or "RS256"
+func (k Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+func (k Key) CanSign() bool {
+	return k.sign != nil
+}
+
+// the key new tokens are signed with, plus the ones before it that are still accepted
+// so rotating doesn't sign everybody out: keep the old key in the ring until its tokens have expired
+type Keyring struct {
+	signer Key
+	keys   []Key // signer first
+}
+
+func NewKeyring(signer Key, previous ...Key) (*Keyring, error) {
+	if !signer.CanSign() {
+		return nil, fmt.Errorf("key %q can't sign, it's a public key", signer.Id)
+	}
+
+	ring := &Keyring{signer: signer}
+	seen := map[string]bool{}
+	for _, key := range append([]Key{signer}, previous...) {
+		if key.Id == "" {
+			return nil, fmt.Errorf("every key needs an id")
+		}
+		if seen[key.Id] {
+			return nil, fmt.Errorf("key id %q used twice", key.Id)
+		}
+		seen[key.Id] = true
+		ring.keys = append(ring.keys, key)
+	}
+	return ring, nil
+}
+
+// id of the key new tokens are signed with
+func (r *Keyring) SignerId() string {
+	return r.signer.Id
+}
+
+// signs claims with the current key and names it in the "kid" header
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.signer.method, claims)
+	token.Header["kid"] = r.signer.Id
+	return token.SignedString(r.signer.sign)
+}
+
+// checks the signature against whichever key the token names, a token has to use that key's algorithm,
+// and fills in claims. opts add checks on top, ie. jwt.WithIssuer
+// tokens with no "kid" are from before there was a keyring and are tried against every HMAC key
+func (r *Keyring) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	var algorithms []string
+	for _, key := range r.keys {
+		algorithms = append(algorithms, key.Algorithm())
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("token has no kid")
+			}
+
+			var set jwt.VerificationKeySet
+			for _, key := range r.keys {
+				if key.method == jwt.SigningMethodHS256 {
+					set.Keys = append(set.Keys, key.verify)
+				}
+			}
+			return set, nil
+		}
+
+		key, ok := r.key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.Algorithm() {
+			return nil, fmt.Errorf("key %q is %s, token says %v", kid, key.Algorithm(), token.Header["alg"])
+		}
+		return key.verify, nil
+	}, append(opts, jwt.WithValidMethods(algorithms))...)
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+func (r *Keyring) key(id string) (Key, bool) {
+	for _, key := range r.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// a public key in JSON Web Key form (RFC 7517), OKP for Ed25519 and RSA for RSA
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// public keys for everything in the ring, so other services can check our tokens themselves
+// HMAC keys are left out, publishing them would let anyone sign tokens
+func (r *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range r.keys {
+		jwk := JWK{Kid: key.Id, Alg: key.Algorithm(), Use: "sig"}
+		switch public := key.verify.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+var (
+	defaultMu      sync.Mutex
+	defaultKeyring *Keyring
+)
+
+// the keyring Sign and Verify use, loaded from the environment the first time it's needed
+func Default() (*Keyring, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultKeyring == nil {
+		config.LoadEnv()
+		ring, err := KeyringFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		defaultKeyring = ring
+	}
+	return defaultKeyring, nil
+}
+
+// replaces the default keyring, nil means load it from the environment again next time
+func SetDefault(ring *Keyring) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultKeyring = ring
+}
+
+// builds the keyring from JWT_KEYS, JWT_TOKEN and JWT_PREVIOUS_TOKENS
+//
+// JWT_KEYS is a comma separated list of id=path, each path a PEM file with an Ed25519 or RSA key,
+// the first one signs (so it needs the private key) and the rest only verify
+// without JWT_KEYS, tokens are signed with JWT_TOKEN (HS256) like they always were
+// with it, JWT_TOKEN is only used to verify, so tokens from before the switch keep working until they expire
+// JWT_PREVIOUS_TOKENS is a comma separated list of old HMAC secrets, for rotating JWT_TOKEN
+func KeyringFromEnv() (*Keyring, error) {
+	var keys []Key
+	for _, entry := range splitList(config.GetEnvOrDefault("JWT_KEYS", "")) {
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("JWT_KEYS entries look like id=path/to/key.pem, got %q", entry)
+		}
+
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_KEYS: %w", err)
+		}
+		key, err := ParseKey(id, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_KEYS: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	secrets := splitList(config.GetEnvOrDefault("JWT_PREVIOUS_TOKENS", ""))
+	if secret := config.GetEnvOrDefault("JWT_TOKEN", ""); secret != "" {
+		secrets = append([]string{secret}, secrets...)
+	}
+	for _, secret := range secrets {
+		keys = append(keys, NewHMACKey(hmacKeyId(secret), []byte(secret)))
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no JWT signing key, set JWT_TOKEN or JWT_KEYS")
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// HMAC secrets don't come with an id, so they get one from a hash of the secret: changing JWT_TOKEN changes the kid
+func hmacKeyId(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "hs-" + hex.EncodeToString(sum[:4])
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,177 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"username": "trent", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+// PEM for a fresh Ed25519 or RSA key, the private key and its public half
+func testPEM(t *testing.T, rsaKey bool) ([]byte, []byte) {
+	var private, public any
+	if rsaKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err, "generate RSA key")
+		private, public = key, &key.PublicKey
+	} else {
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		assert.Nil(t, err, "generate Ed25519 key")
+		private, public = key, pub
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.Nil(t, err, "marshal private key")
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	assert.Nil(t, err, "marshal public key")
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+}
+
+func TestKeyringAlgorithms(t *testing.T) {
+	edPEM, _ := testPEM(t, false)
+	rsaPEM, _ := testPEM(t, true)
+
+	ed, err := ParseKey("ed", edPEM)
+	assert.Nil(t, err, "parse Ed25519 key")
+	rs, err := ParseKey("rs", rsaPEM)
+	assert.Nil(t, err, "parse RSA key")
+
+	for _, key := range []Key{NewHMACKey("hs", []byte("secret")), ed, rs} {
+		ring, err := NewKeyring(key)
+		assert.Nil(t, err, "keyring for %s", key.Algorithm())
+
+		token, err := ring.Sign(testClaims())
+		assert.Nil(t, err, "sign with %s", key.Algorithm())
+
+		claims := jwt.MapClaims{}
+		err = ring.Parse(token, claims)
+		assert.Nil(t, err, "verify %s", key.Algorithm())
+		assert.Equal(t, "trent", claims["username"], "claims from %s", key.Algorithm())
+
+		parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		assert.Equal(t, key.Id, parsed.Header["kid"], "kid header for %s", key.Algorithm())
+	}
+
+	_, err = ParseKey("junk", []byte("not a key"))
+	assert.NotNil(t, err, "junk isn't a key")
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldPEM, oldPublicPEM := testPEM(t, false)
+	newPEM, _ := testPEM(t, false)
+
+	oldKey, _ := ParseKey("2025-01", oldPEM)
+	newKey, _ := ParseKey("2025-06", newPEM)
+	oldPublic, err := ParseKey("2025-01", oldPublicPEM)
+	assert.Nil(t, err, "parse public key")
+	assert.False(t, oldPublic.CanSign(), "public key can't sign")
+
+	before, _ := NewKeyring(oldKey)
+	issued, _ := before.Sign(testClaims())
+
+	// the old key stays in the ring, only its public half is needed
+	during, err := NewKeyring(newKey, oldPublic)
+	assert.Nil(t, err, "keyring mid-rotation")
+	err = during.Parse(issued, jwt.MapClaims{})
+	assert.Nil(t, err, "tokens from the old key still verify")
+	assert.Equal(t, "2025-06", during.SignerId(), "new tokens use the new key")
+
+	after, _ := NewKeyring(newKey)
+	err = after.Parse(issued, jwt.MapClaims{})
+	assert.NotNil(t, err, "old key gone, its tokens stop working")
+
+	_, err = NewKeyring(oldPublic)
+	assert.NotNil(t, err, "public key can't be the signer")
+	_, err = NewKeyring(newKey, oldKey, oldPublic)
+	assert.NotNil(t, err, "ids must be unique")
+}
+
+func TestKeyringRejects(t *testing.T) {
+	edPEM, edPublicPEM := testPEM(t, false)
+	ed, _ := ParseKey("ed", edPEM)
+	ring, _ := NewKeyring(ed, NewHMACKey("hs", []byte("secret")))
+
+	// signed with the public key as an HMAC secret under the Ed25519 key's id
+	forged, _ := NewKeyring(NewHMACKey("ed", edPublicPEM))
+	token, _ := forged.Sign(testClaims())
+	err := ring.Parse(token, jwt.MapClaims{})
+	assert.NotNil(t, err, "algorithm must match the key")
+
+	stranger, _ := NewKeyring(NewHMACKey("other", []byte("secret")))
+	token, _ = stranger.Sign(testClaims())
+	err = ring.Parse(token, jwt.MapClaims{})
+	assert.NotNil(t, err, "unknown kid")
+
+	// tokens from before keys had ids
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("secret"))
+	err = ring.Parse(legacy, jwt.MapClaims{})
+	assert.Nil(t, err, "no kid, checked against the HMAC keys")
+	legacy, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("wrong"))
+	err = ring.Parse(legacy, jwt.MapClaims{})
+	assert.NotNil(t, err, "no kid, wrong secret")
+
+	// what used to come in through the Authorization header
+	token, _ = ring.Sign(testClaims())
+	for name, malformed := range map[string]string{"empty": "", "junk": "skfljksf", "trailing text": token + " def", "scheme left on": "Bearer " + token} {
+		assert.NotNil(t, ring.Parse(malformed, jwt.MapClaims{}), name)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edPEM, _ := testPEM(t, false)
+	_, rsaPublicPEM := testPEM(t, true)
+	ed, _ := ParseKey("ed", edPEM)
+	rs, _ := ParseKey("rs", rsaPublicPEM)
+	ring, _ := NewKeyring(ed, rs, NewHMACKey("hs", []byte("secret")))
+
+	set := ring.JWKS()
+	assert.Equal(t, 2, len(set.Keys), "HMAC keys aren't published")
+	assert.Equal(t, JWK{Kty: "OKP", Kid: "ed", Alg: "EdDSA", Use: "sig", Crv: "Ed25519", X: set.Keys[0].X}, set.Keys[0], "Ed25519 key")
+	assert.Equal(t, 43, len(set.Keys[0].X), "32 bytes, base64url")
+	assert.Equal(t, "RSA", set.Keys[1].Kty, "RSA key")
+	assert.Equal(t, "AQAB", set.Keys[1].E, "RSA exponent")
+}
+
+func TestKeyringFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	edPEM, _ := testPEM(t, false)
+	_, oldPublicPEM := testPEM(t, true)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "new.pem"), edPEM, 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "old.pem"), oldPublicPEM, 0600))
+
+	t.Setenv("JWT_KEYS", "")
+	t.Setenv("JWT_TOKEN", "current")
+	t.Setenv("JWT_PREVIOUS_TOKENS", "older, oldest")
+	ring, err := KeyringFromEnv()
+	assert.Nil(t, err, "HMAC only")
+	assert.Equal(t, hmacKeyId("current"), ring.SignerId(), "JWT_TOKEN signs")
+	assert.Equal(t, 3, len(ring.keys), "previous secrets verify")
+
+	t.Setenv("JWT_KEYS", "2025-06="+filepath.Join(dir, "new.pem")+",2025-01="+filepath.Join(dir, "old.pem"))
+	ring, err = KeyringFromEnv()
+	assert.Nil(t, err, "with key files")
+	assert.Equal(t, "2025-06", ring.SignerId(), "first key file signs")
+	assert.Equal(t, 5, len(ring.keys), "JWT_TOKEN and friends still verify")
+
+	t.Setenv("JWT_KEYS", "no-path")
+	_, err = KeyringFromEnv()
+	assert.NotNil(t, err, "bad entry")
+
+	t.Setenv("JWT_KEYS", "2025-01="+filepath.Join(dir, "old.pem"))
+	_, err = KeyringFromEnv()
+	assert.NotNil(t, err, "first key must be able to sign")
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(embedded)
+	assert.Nil(t, err, "embedded migrations parse")
+	assert.NotEmpty(t, migrations, "at least the baseline migration is embedded")
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions are sequential with no gaps")
+		assert.NotEmpty(t, m.Up, "up migration has contents")
+		assert.NotEmpty(t, m.Down, "down migration has contents")
+	}
+}
+
+func TestLoadOrdering(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_second.up.sql":   {Data: []byte("SELECT 2;")},
+		"migrations/0002_second.down.sql": {Data: []byte("SELECT -2;")},
+		"migrations/0001_first.up.sql":    {Data: []byte("SELECT 1;")},
+		"migrations/0001_first.down.sql":  {Data: []byte("SELECT -1;")},
+	}
+
+	migrations, err := Load(fsys)
+	assert.Nil(t, err, "valid migrations parse")
+	assert.Equal(t, 2, len(migrations), "two migrations")
+	assert.Equal(t, "first", migrations[0].Name, "sorted by version")
+	assert.Equal(t, "SELECT -2;", migrations[1].Down, "down contents read")
+}
+
+func TestLoadRejectsBadFiles(t *testing.T) {
+	_, err := Load(fstest.MapFS{
+		"migrations/0001_first.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.NotNil(t, err, "missing down migration")
+
+	_, err = Load(fstest.MapFS{
+		"migrations/0001_first.up.sql":   {Data: []byte("SELECT 1;")},
+		"migrations/0001_first.down.sql": {Data: []byte("SELECT -1;")},
+		"migrations/0001_other.up.sql":   {Data: []byte("SELECT 1;")},
+		"migrations/0001_other.down.sql": {Data: []byte("SELECT -1;")},
+	})
+	assert.NotNil(t, err, "duplicate version")
+
+	_, err = Load(fstest.MapFS{
+		"migrations/first.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.NotNil(t, err, "badly named file")
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS admins;
+DROP TABLE IF EXISTS comment_votes;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS votes;
+DROP FUNCTION IF EXISTS days_between(TIMESTAMP, TIMESTAMP);
+DROP TABLE IF EXISTS submissions;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS bio;
+DROP TABLE IF EXISTS magic_links;
+DROP TABLE IF EXISTS users;
+DROP TYPE IF EXISTS audit_event;
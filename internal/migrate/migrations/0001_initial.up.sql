@@ -1,15 +1,23 @@
+-- baseline schema, identical to the old db/schema.sql except that every statement
+-- is idempotent, so databases created from that file can run this as a no-op
+
 -- Note: for proper functionality of UUIDs, you may need to install the extension
 -- by running the following in the psql console:
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
-CREATE TYPE audit_event AS ENUM (
-    'login',
-    'logout',
-    'failed_login',
-    'post',
-    'comment',
-    'post_click',
-    'sent_email'
-);
+-- CREATE TYPE has no IF NOT EXISTS, so swallow the error when the type is already there
+DO $$ BEGIN
+    CREATE TYPE audit_event AS ENUM (
+        'login',
+        'logout',
+        'failed_login',
+        'post',
+        'comment',
+        'post_click',
+        'sent_email'
+    );
+EXCEPTION
+    WHEN duplicate_object THEN NULL;
+END $$;
 -- no plans to use passwords
 -- instead i'm going to email magic links
 -- saves the hastle of hashing passwords, plus security improvements
@@ -121,7 +129,7 @@ CREATE TABLE IF NOT EXISTS admins (
 );
 
 -- users for automated access (API)
-CREATE TABLE api_tokens (
+CREATE TABLE IF NOT EXISTS api_tokens (
     username VARCHAR(100) PRIMARY KEY,
     token VARCHAR(255) NOT NULL,
     FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
@@ -138,7 +146,7 @@ CREATE TABLE api_tokens (
 -- | 28+ days         |   0.5         |
 -- |------------------|---------------|
 
-CREATE TABLE reports (
+CREATE TABLE IF NOT EXISTS reports (
     id SERIAL PRIMARY KEY,
     reporter VARCHAR(100) NOT NULL REFERENCES users(username),
     target_type VARCHAR(20) NOT NULL,  -- 'post', 'comment'
@@ -146,4 +154,4 @@ CREATE TABLE reports (
     target_user VARCHAR(100) NOT NULL REFERENCES users(username),
     rweight FLOAT NOT NULL, -- "weight" of the report (logic determined on frontend)
     created_at TIMESTAMP DEFAULT NOW()
-);
\ No newline at end of file
+);
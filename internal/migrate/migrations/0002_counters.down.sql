@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS submissions_score_idx;
+
+ALTER TABLE comments
+    DROP COLUMN IF EXISTS comment_count,
+    DROP COLUMN IF EXISTS downvotes,
+    DROP COLUMN IF EXISTS upvotes,
+    DROP COLUMN IF EXISTS score;
+
+ALTER TABLE submissions
+    DROP COLUMN IF EXISTS comment_count,
+    DROP COLUMN IF EXISTS downvotes,
+    DROP COLUMN IF EXISTS upvotes,
+    DROP COLUMN IF EXISTS score;
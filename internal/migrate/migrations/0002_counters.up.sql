@@ -0,0 +1,48 @@
+-- denormalized counters so listing pages don't have to aggregate votes/comments on every request
+-- kept up to date by the Go code inside the same transaction as the vote/comment write,
+-- `hn repair` recomputes them from the raw tables if they ever drift
+ALTER TABLE submissions
+    ADD COLUMN score INTEGER NOT NULL DEFAULT 0,          -- upvotes - downvotes
+    ADD COLUMN upvotes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN downvotes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;  -- every comment on the post, replies included
+
+ALTER TABLE comments
+    ADD COLUMN score INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN upvotes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN downvotes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;  -- direct replies only
+
+-- backfill from whatever is already there
+UPDATE submissions SET
+    upvotes = counts.upvotes,
+    downvotes = counts.downvotes,
+    score = counts.upvotes - counts.downvotes
+FROM (
+    SELECT submission_id, COUNT(*) FILTER (WHERE positive) AS upvotes, COUNT(*) FILTER (WHERE NOT positive) AS downvotes
+    FROM votes
+    GROUP BY submission_id
+) counts
+WHERE submissions.id = counts.submission_id;
+
+UPDATE submissions SET comment_count = counts.total
+FROM (SELECT in_response_to, COUNT(*) AS total FROM comments GROUP BY in_response_to) counts
+WHERE submissions.id = counts.in_response_to;
+
+UPDATE comments SET
+    upvotes = counts.upvotes,
+    downvotes = counts.downvotes,
+    score = counts.upvotes - counts.downvotes
+FROM (
+    SELECT comment_id, COUNT(*) FILTER (WHERE positive) AS upvotes, COUNT(*) FILTER (WHERE NOT positive) AS downvotes
+    FROM comment_votes
+    GROUP BY comment_id
+) counts
+WHERE comments.id = counts.comment_id;
+
+UPDATE comments SET comment_count = counts.total
+FROM (SELECT parent_comment, COUNT(*) AS total FROM comments WHERE parent_comment IS NOT NULL GROUP BY parent_comment) counts
+WHERE comments.id = counts.parent_comment;
+
+-- front page "best" sort
+CREATE INDEX IF NOT EXISTS submissions_score_idx ON submissions (score DESC, created_at DESC);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS submissions_domain_idx;
+DROP INDEX IF EXISTS comments_search_idx;
+DROP INDEX IF EXISTS submissions_search_idx;
+
+ALTER TABLE comments
+    DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE submissions
+    DROP COLUMN IF EXISTS domain,
+    DROP COLUMN IF EXISTS search_vector;
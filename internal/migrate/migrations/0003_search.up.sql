@@ -0,0 +1,19 @@
+-- full text search, see PostgresStore.Search
+-- the vectors are generated columns so every insert/update keeps them current without triggers
+-- titles are weighted above bodies so a title match ranks higher
+ALTER TABLE submissions
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(body, '')), 'B')
+    ) STORED,
+    -- host part of the link, lowercased and without a leading www., for the domain filter
+    ADD COLUMN domain TEXT GENERATED ALWAYS AS (
+        lower(substring(link FROM '^[A-Za-z][A-Za-z0-9+.-]*://(?:www\.)?([^/:?#]+)'))
+    ) STORED;
+
+ALTER TABLE comments
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS submissions_search_idx ON submissions USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS comments_search_idx ON comments USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS submissions_domain_idx ON submissions (domain);
@@ -0,0 +1,18 @@
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+
+DROP INDEX IF EXISTS audit_log_event_idx;
+DROP INDEX IF EXISTS audit_log_username_idx;
+DROP INDEX IF EXISTS audit_log_ts_idx;
+
+-- enum values can't be dropped, 'vote', 'report', 'delete' and 'admin_action' stay on audit_event
+
+-- the old primary key can't hold more than one event per user, so the history goes
+TRUNCATE audit_log;
+ALTER TABLE audit_log
+    DROP COLUMN id,
+    ALTER COLUMN metadata TYPE VARCHAR(255) USING metadata::text,
+    ALTER COLUMN ts DROP NOT NULL,
+    ALTER COLUMN username SET NOT NULL,
+    ADD PRIMARY KEY (username);
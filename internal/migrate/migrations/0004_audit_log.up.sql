@@ -0,0 +1,37 @@
+-- audit_log was keyed on username, so it could only ever hold one event per user
+-- it gets a proper id, and username becomes optional (failed logins don't always have one)
+ALTER TABLE audit_log DROP CONSTRAINT IF EXISTS audit_log_pkey;
+ALTER TABLE audit_log
+    ADD COLUMN id BIGSERIAL PRIMARY KEY,
+    ALTER COLUMN username DROP NOT NULL,
+    ALTER COLUMN ts SET NOT NULL,
+    ALTER COLUMN metadata TYPE JSONB USING CASE WHEN metadata IS NULL THEN NULL ELSE jsonb_build_object('note', metadata) END;
+
+-- no FK to users on purpose, the history outlives the account
+
+-- ADD VALUE is fine inside a transaction on Postgres 12+, the values just can't be used until it commits
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'vote';
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'report';
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'delete';
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'admin_action';
+
+CREATE INDEX IF NOT EXISTS audit_log_ts_idx ON audit_log (ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_log_username_idx ON audit_log (username, ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_log_event_idx ON audit_log (event_type, ts DESC, id DESC);
+
+-- append-only: rows can be added but never changed or removed, TRUNCATE doesn't fire row triggers so it gets its own
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,3 @@
+-- without the column every row would read as an admin, so moderators lose their access instead
+DELETE FROM admins WHERE role <> 'admin';
+ALTER TABLE admins DROP COLUMN role;
@@ -0,0 +1,5 @@
+-- admins becomes the staff table: every existing row stays an admin, moderators can delete
+-- other people's posts and comments but don't get the admin console
+ALTER TABLE admins
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'admin'
+    CHECK (role IN ('admin', 'moderator'));
@@ -0,0 +1,2 @@
+-- set every time the key authenticates a request, NULL until it's first used
+ALTER TABLE api_tokens ADD COLUMN last_used TIMESTAMP;
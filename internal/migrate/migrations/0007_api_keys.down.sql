@@ -0,0 +1,11 @@
+-- only hashes were kept, so there's nothing to put back: everyone has to generate a new key
+CREATE TABLE IF NOT EXISTS api_tokens (
+    username VARCHAR(100) PRIMARY KEY,
+    token VARCHAR(255) NOT NULL,
+    last_used TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS api_keys;
+
+-- enum values can't be dropped, 'api_key' stays on audit_event
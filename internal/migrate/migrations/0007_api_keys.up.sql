@@ -0,0 +1,28 @@
+-- api_tokens held one plaintext token per user, api_keys holds any number of named keys per user
+-- only a SHA-256 of each key is stored, the prefix is kept in the clear so people can tell their keys apart
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username VARCHAR(100) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    last_used TIMESTAMP,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_username_idx ON api_keys (username, created_at DESC);
+
+-- existing tokens keep working, with every scope but admin (they could never reach admin routes)
+INSERT INTO api_keys (username, name, prefix, key_hash, scopes, last_used)
+SELECT username, 'default', LEFT(token, 8), encode(sha256(convert_to(token, 'UTF8')), 'hex'),
+       ARRAY['read', 'vote', 'submit', 'comment'], last_used
+FROM api_tokens;
+
+DROP TABLE api_tokens;
+
+-- creating, rotating and revoking keys goes in the audit log
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'api_key';
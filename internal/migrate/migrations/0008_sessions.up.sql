@@ -0,0 +1,19 @@
+-- one row per signed in device: the access JWT carries the session id, the refresh token is only stored hashed
+-- refreshing swaps refresh_hash for a new one, previous_hash is kept so a stolen token that's been
+-- used twice can be caught and the whole session killed
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username VARCHAR(100) NOT NULL,
+    refresh_hash CHAR(64) NOT NULL UNIQUE,
+    previous_hash CHAR(64),
+    ip VARCHAR(100) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_username_idx ON sessions (username, last_seen DESC);
+CREATE INDEX IF NOT EXISTS sessions_previous_hash_idx ON sessions (previous_hash);
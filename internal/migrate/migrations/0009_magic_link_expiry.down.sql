@@ -0,0 +1,7 @@
+-- hashes can't be turned back into tokens, so outstanding links are lost
+DELETE FROM magic_links;
+
+DROP INDEX IF EXISTS magic_links_expires_at_idx;
+ALTER TABLE magic_links DROP COLUMN expires_at;
+ALTER TABLE magic_links DROP COLUMN token_hash;
+ALTER TABLE magic_links ADD COLUMN token VARCHAR(255) NOT NULL UNIQUE;
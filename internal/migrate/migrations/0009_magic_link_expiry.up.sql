@@ -0,0 +1,10 @@
+-- magic links expire, and like API keys and refresh tokens only a SHA-256 of the token is stored
+-- links sent before this have nothing to hash or expire them by, so they're dropped and people ask for a new one
+DELETE FROM magic_links;
+
+ALTER TABLE magic_links DROP COLUMN token;
+ALTER TABLE magic_links ADD COLUMN token_hash CHAR(64) NOT NULL UNIQUE;
+ALTER TABLE magic_links ADD COLUMN expires_at TIMESTAMP NOT NULL;
+
+-- for the cleanup job
+CREATE INDEX IF NOT EXISTS magic_links_expires_at_idx ON magic_links (expires_at);
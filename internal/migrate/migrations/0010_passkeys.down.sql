@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS passkey_challenges;
+DROP TABLE IF EXISTS passkeys;
+ALTER TABLE users DROP COLUMN IF EXISTS passkey_handle;
+
+-- enum values can't be dropped, 'passkey' stays on audit_event
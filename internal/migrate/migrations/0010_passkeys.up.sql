@@ -0,0 +1,30 @@
+-- passkeys (WebAuthn credentials), a user can sign in with any of theirs instead of a magic link
+-- users get a random handle the first time they add one, authenticators hand it back when signing in
+ALTER TABLE users ADD COLUMN passkey_handle BYTEA UNIQUE;
+
+CREATE TABLE IF NOT EXISTS passkeys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username VARCHAR(100) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    credential JSONB NOT NULL, -- public key, sign count and flags, as go-webauthn stores them
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS passkeys_username_idx ON passkeys (username, created_at DESC);
+
+-- a registration or sign in that's been started and not finished, holding the challenge the authenticator signs
+CREATE TABLE IF NOT EXISTS passkey_challenges (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    username VARCHAR(100), -- NULL when signing in, nobody's known yet
+    data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS passkey_challenges_expires_at_idx ON passkey_challenges (expires_at);
+
+-- adding and removing passkeys goes in the audit log
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'passkey';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS two_factor_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS totp_secrets;
+
+-- enum values can't be dropped, 'two_factor' stays on audit_event
@@ -0,0 +1,31 @@
+-- optional TOTP two-factor, required for staff, checked after a magic link or passkey before a session starts
+CREATE TABLE IF NOT EXISTS totp_secrets (
+    username VARCHAR(100) PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL, -- base32, needed as is to work out codes
+    enabled_at TIMESTAMP, -- NULL until the first code is confirmed
+    last_step BIGINT NOT NULL DEFAULT 0, -- the newest 30 second window a code was used from, so a code can't be used twice
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+-- single use codes for when the phone is lost, hashed like API keys, deleted once used
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    username VARCHAR(100) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (username, code_hash),
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+-- a sign in that's past the magic link (or passkey) and waiting on a code
+CREATE TABLE IF NOT EXISTS two_factor_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    username VARCHAR(100) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS two_factor_challenges_expires_at_idx ON two_factor_challenges (expires_at);
+
+-- turning it on or off and using up recovery codes goes in the audit log
+ALTER TYPE audit_event ADD VALUE IF NOT EXISTS 'two_factor';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS comments_replies_idx;
+DROP INDEX IF EXISTS comments_top_level_idx;
@@ -0,0 +1,3 @@
+-- CommentTree takes the best few replies of each comment at a time, these let it read just those
+CREATE INDEX IF NOT EXISTS comments_top_level_idx ON comments (in_response_to, score DESC, created_at DESC, id DESC) WHERE parent_comment IS NULL;
+CREATE INDEX IF NOT EXISTS comments_replies_idx ON comments (parent_comment, score DESC, created_at DESC, id DESC) WHERE parent_comment IS NOT NULL;
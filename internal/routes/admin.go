@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func adminRoutes(r fiber.Router, h *handlers.Handlers) {
+	// anyone signed in can ask, the answer is just false for most people
+	r.Get("/checkAdmin", h.RequireAuth, h.CheckAdmin)
+
+	r.Get("/adminMetrics", h.RequireAdmin, h.AdminMetrics)
+	r.Get("/auditLog", h.RequireAdmin, h.AuditLog)
+	r.Get("/clean", h.RequireAdmin, h.Clean)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+// what the frontend needs to show a captcha, challenges are cheap to hand out so an IP asking for lots just gets harder ones
+func captchaRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/captcha", h.CaptchaStatus)
+	r.Get("/captcha/challenge", h.CaptchaChallenge)
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func commentRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/commentTree", h.CommentTree)
+	r.Get("/comments", h.Comments)
+
+	r.Post("/comment", h.RequireScope(db.ScopeComment), h.CreateComment)
+	r.Delete("/comment", h.RequireScope(db.ScopeComment), h.DeleteComment)
+}
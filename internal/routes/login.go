@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func loginRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Post("/login", loginHandlers(h)...)
+	r.Get("/magic", h.Magic)
+	r.Post("/refresh", h.Refresh)
+
+	// sessions belong to the website, API keys are revoked through /apiKeys instead
+	r.Post("/logout", h.RequireJWT, h.Logout)
+	r.Post("/logoutAll", h.RequireJWT, h.LogoutAll)
+	r.Get("/sessions", h.RequireJWT, h.Sessions)
+	r.Delete("/sessions", h.RequireJWT, h.RevokeSession)
+}
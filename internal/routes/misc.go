@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func miscRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/status", h.Status)
+	r.Get("/fetchWebsiteTitle", h.FetchWebsiteTitle)
+	r.Get("/urlCheck", h.URLCheck)
+}
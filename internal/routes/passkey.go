@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+// passkeys are added from settings once signed in with a magic link, then used instead of one
+func passkeyRoutes(r fiber.Router, h *handlers.Handlers) {
+	passkeys := r.Group("/passkeys", h.RequirePasskeys)
+
+	// signing in doesn't send email, but each begin still writes a challenge to the database
+	passkeys.Post("/login/begin", ipLimiter(h.LoginIPLimit), h.BeginPasskeyLogin)
+	passkeys.Post("/login/finish", h.FinishPasskeyLogin)
+
+	passkeys.Post("/register/begin", h.RequireJWT, h.BeginPasskeyRegistration)
+	passkeys.Post("/register/finish", h.RequireJWT, h.FinishPasskeyRegistration)
+	passkeys.Get("/", h.RequireJWT, h.ListPasskeys)
+	passkeys.Delete("/", h.RequireJWT, h.DeletePasskey)
+}
@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+const version = "/api/v1"
+
+// upper bound on how long a single request may spend in the database
+// fasthttp doesn't tell us when a client hangs up, so this deadline is what cancels abandoned queries
+const requestTimeout = 15 * time.Second
+
+// builds the whole web app, staticDir is served at / unless it's ""
+// nothing here touches the network, so tests can drive the result with app.Test
+func New(h *handlers.Handlers, staticDir string) *fiber.App {
+	// behind a reverse proxy every connection comes from the proxy, so c.IP() (rate limits, audit log, sessions) reads
+	// the client's address from ProxyHeader instead, but only on connections from TrustedProxies, anyone else could
+	// put whatever they like in it
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             h.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          h.TrustedProxies,
+		EnableIPValidation:      true,
+		// c.IP() and headers are kept past the request (audit log, sessions, limiters, challenges),
+		// without this they point into buffers fasthttp reuses for the next request
+		Immutable: true,
+	})
+	app.Use(cors.New())
+	app.Use(logger.New())
+
+	// every handler passes c.UserContext() down to the database
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+
+	// where other services find the keys to check our tokens with, the standard path rather than under /api/v1
+	app.Get("/.well-known/jwks.json", h.JWKS)
+
+	if staticDir != "" {
+		app.Static("/", staticDir)
+	}
+
+	// who's calling is worked out once for every API route, the routes themselves only say whether it matters
+	api := app.Group(version, h.Authenticate, apiKeyLimiter(h.APIKeyLimit))
+	loginRoutes(api, h)
+	passkeyRoutes(api, h)
+	twoFactorRoutes(api, h)
+	userRoutes(api, h)
+	apiKeyRoutes(api, h)
+	submissionRoutes(api, h)
+	voteRoutes(api, h)
+	commentRoutes(api, h)
+	captchaRoutes(api, h)
+	adminRoutes(api, h)
+	miscRoutes(api, h)
+
+	// 404
+	app.Use(func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Route not found", "status": 404})
+	})
+
+	return app
+}
+
+// bots get their own budget, keyed on the user so every key they hold shares it
+// website traffic (JWTs) and signed out requests aren't counted
+func apiKeyLimiter(limit handlers.RateLimit) fiber.Handler {
+	return limitBy(limit, "API key rate limit exceeded, try again later", func(c *fiber.Ctx) string {
+		if handlers.Method(c) != handlers.AuthAPIKey {
+			return ""
+		}
+		return handlers.Username(c)
+	})
+}
+
+// every /login sends an email, so on top of the captcha there's a budget per IP and per address
+// the IP counts every attempt, the address only ones CheckLogin let through,
+// otherwise anyone could use up someone else's budget without ever passing the captcha
+func loginHandlers(h *handlers.Handlers) []fiber.Handler {
+	perIP := ipLimiter(h.LoginIPLimit)
+	perEmail := limitBy(h.LoginEmailLimit, "Too many sign in links sent to this address, check your inbox or try again later", handlers.LoginEmail)
+
+	return []fiber.Handler{perIP, h.CheckLogin, perEmail, h.Login}
+}
+
+// LoginIPLimit for a sign in step, each call starts a budget of its own
+func ipLimiter(limit handlers.RateLimit) fiber.Handler {
+	return limitBy(limit, "Too many sign in attempts, try again later", func(c *fiber.Ctx) string {
+		return c.IP()
+	})
+}
+
+// at most limit.Max requests per limit.Window for each key, requests key returns "" for aren't counted
+// a Max of 0 turns it off
+func limitBy(limit handlers.RateLimit, message string, key func(c *fiber.Ctx) string) fiber.Handler {
+	if limit.Max <= 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	return limiter.New(limiter.Config{
+		Max:        limit.Max,
+		Expiration: limit.Window,
+		Next: func(c *fiber.Ctx) bool {
+			return key(c) == ""
+		},
+		KeyGenerator: key,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": message})
+		},
+	})
+}
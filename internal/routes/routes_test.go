@@ -0,0 +1,638 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/trentwiles/hackernews/internal/captcha"
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/email"
+	"github.com/trentwiles/hackernews/internal/handlers"
+	"github.com/trentwiles/hackernews/internal/jwt"
+	"github.com/trentwiles/hackernews/internal/totp"
+)
+
+// app over a fresh memory store, do sends a request with the given Authorization header ("" for none)
+// and optionally a JSON body, configure changes the handlers before the app is built
+func testApp(t *testing.T, apiKeyLimit handlers.RateLimit, configure ...func(h *handlers.Handlers)) (*db.MemoryStore, func(method, path, auth string, body ...string) int) {
+	// set before anything loads .env, godotenv never overrides what's already there
+	t.Setenv("JWT_TOKEN", "routes-test-secret")
+
+	store := db.NewMemoryStore()
+	h := &handlers.Handlers{
+		Store:            store,
+		Paging:           handlers.Paging{DefaultLimit: 10, MaxLimit: 100},
+		TokenExpiresIn:   5,
+		SessionExpiresIn: time.Hour,
+		APIKeyLimit:      apiKeyLimit,
+		MagicLinkTTL:     15 * time.Minute,
+		LoginIPLimit:     handlers.RateLimit{Max: 4, Window: time.Minute},
+		LoginEmailLimit:  handlers.RateLimit{Max: 2, Window: time.Minute},
+		Captcha:          captcha.Static(false),
+		Mailer:           email.NewMemory(),
+		Site:             email.Site{Name: "Test News", URL: "https://news.example.com"},
+	}
+	for _, fn := range configure {
+		fn(h)
+	}
+	app := New(h, "")
+
+	do := func(method, path, auth string, body ...string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(strings.Join(body, "")))
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		// "X-API-Key: <key>" goes in its own header
+		if key, ok := strings.CutPrefix(auth, "X-API-Key: "); ok {
+			req.Header.Set("X-API-Key", key)
+		} else if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := app.Test(req)
+		assert.Nil(t, err, "request")
+		return resp.StatusCode
+	}
+	return store, do
+}
+
+// starts a session for username, creating the user if needed, and returns the Authorization header for it
+func bearer(t *testing.T, store *db.MemoryStore, username string) string {
+	ctx := context.Background()
+	if _, err := store.SearchUser(ctx, db.User{Username: username}); err != nil {
+		assert.Nil(t, store.CreateUser(ctx, db.User{Username: username, Email: username + "@example.com", Registered_ip: "127.0.0.1"}), "create %s", username)
+	}
+
+	session, _, err := store.CreateSession(ctx, db.User{Username: username}, "127.0.0.1", "test", time.Now().Add(time.Hour))
+	assert.Nil(t, err, "start session")
+
+	signed, err := jwt.GenerateSessionJWT(username, session.Id, string(db.RoleUser), 5)
+	assert.Nil(t, err, "sign token")
+	return "Bearer " + signed
+}
+
+func TestRoutes(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{})
+	store.AddAdmin("admin", "test")
+	store.AddStaff("mod", db.RoleModerator, "test")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"public route", "GET", "/api/v1/status", "", 200},
+		{"public keys", "GET", "/.well-known/jwks.json", "", 200},
+		{"unknown route", "GET", "/api/v1/nope", "", 404},
+		{"signed out", "GET", "/api/v1/checkAdmin", "", 401},
+		{"bad token", "GET", "/api/v1/checkAdmin", "Bearer nonsense", 401},
+		{"signed in", "GET", "/api/v1/checkAdmin", bearer(t, store, "alice"), 200},
+		{"admin route signed out", "GET", "/api/v1/adminMetrics", "", 401},
+		{"admin route as a regular user", "GET", "/api/v1/adminMetrics", bearer(t, store, "alice"), 403},
+		{"admin route as a moderator", "GET", "/api/v1/clean", bearer(t, store, "mod"), 403},
+		{"admin route as an admin", "GET", "/api/v1/auditLog", bearer(t, store, "admin"), 200},
+		{"optional auth signed out", "GET", "/api/v1/urlCheck?q=example.com", "", 200},
+		{"optional auth with a bad token", "GET", "/api/v1/urlCheck?q=example.com", "Bearer nonsense", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, do(tt.method, tt.path, tt.auth))
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{})
+	store.AddStaff("mod", db.RoleModerator, "test")
+
+	ctx := context.Background()
+	for _, username := range []string{"alice", "bob", "mod"} {
+		assert.Nil(t, store.CreateUser(ctx, db.User{Username: username, Email: username + "@example.com", Registered_ip: "127.0.0.1"}), "create %s", username)
+	}
+	post, err := store.CreateSubmission(ctx, db.Submission{Username: "alice", Title: "title", Link: "https://example.com"})
+	assert.Nil(t, err, "create submission")
+
+	comment := func() string {
+		id, err := store.InsertNewComment(ctx, db.Comment{InResponseTo: post, Author: "alice", Content: "hello"})
+		assert.Nil(t, err, "create comment")
+		return "/api/v1/comment?id=" + id
+	}
+
+	first := comment()
+	assert.Equal(t, 401, do("DELETE", first, ""), "signed out")
+	assert.Equal(t, 403, do("DELETE", first, bearer(t, store, "bob")), "someone else's comment")
+	assert.Equal(t, 200, do("DELETE", first, bearer(t, store, "alice")), "own comment")
+	assert.Equal(t, 404, do("DELETE", first, bearer(t, store, "alice")), "already gone")
+
+	assert.Equal(t, 200, do("DELETE", comment(), bearer(t, store, "mod")), "moderator")
+
+	// a key without the admin scope only deletes the moderator's own
+	_, key, err := store.CreateAPIKey(ctx, db.User{Username: "mod"}, db.APIKeyOptions{Name: "commenter", Scopes: []db.Scope{db.ScopeComment}})
+	assert.Nil(t, err, "create key")
+	assert.Equal(t, 403, do("DELETE", comment(), "ApiKey "+key), "moderator's comment-scoped key")
+	own, err := store.InsertNewComment(ctx, db.Comment{InResponseTo: post, Author: "mod", Content: "mine"})
+	assert.Nil(t, err, "create own comment")
+	assert.Equal(t, 200, do("DELETE", "/api/v1/comment?id="+own, "ApiKey "+key), "moderator's own comment")
+}
+
+func TestAPIKeys(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{Max: 6, Window: time.Minute})
+	store.AddAdmin("bot", "test")
+
+	ctx := context.Background()
+	assert.Nil(t, store.CreateUser(ctx, db.User{Username: "bot", Email: "bot@example.com", Registered_ip: "127.0.0.1"}), "create user")
+	_, key, err := store.CreateAPIKey(ctx, db.User{Username: "bot"}, db.APIKeyOptions{Name: "reader", Scopes: []db.Scope{db.ScopeRead}})
+	assert.Nil(t, err, "create key")
+
+	assert.Equal(t, 200, do("GET", "/api/v1/checkAdmin", "ApiKey "+key), "Authorization header")
+	assert.Equal(t, 200, do("GET", "/api/v1/checkAdmin", "X-API-Key: "+key), "X-API-Key header")
+	assert.Equal(t, 401, do("GET", "/api/v1/checkAdmin", "ApiKey nonsense"), "bad key")
+	assert.Equal(t, 401, do("GET", "/api/v1/checkAdmin", "X-API-Key: nonsense"), "bad key in X-API-Key")
+
+	// scopes: read only, and an admin's key still needs the admin scope
+	assert.Equal(t, 403, do("POST", "/api/v1/vote", "ApiKey "+key), "no vote scope")
+	assert.Equal(t, 403, do("GET", "/api/v1/auditLog", "ApiKey "+key), "no admin scope")
+	assert.Equal(t, 403, do("GET", "/api/v1/apiKeys", "ApiKey "+key), "keys can't manage keys")
+
+	// sixth keyed request this window, then over the limit
+	assert.Equal(t, 200, do("GET", "/api/v1/status", "ApiKey "+key), "last one under the limit")
+	assert.Equal(t, 429, do("GET", "/api/v1/status", "ApiKey "+key), "over the limit")
+
+	// the same user on the website isn't held back, and neither is anyone signed out
+	assert.Equal(t, 200, do("GET", "/api/v1/apiKeys", bearer(t, store, "bot")), "JWT")
+	assert.Equal(t, 200, do("GET", "/api/v1/status", ""), "signed out")
+}
+
+func TestAdminKeysNeedTwoFactor(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{})
+	ctx := context.Background()
+	jwt := bearer(t, store, "bot")
+	store.AddAdmin("bot", "test")
+
+	// made before two-factor was required for them, or while it was off
+	_, key, err := store.CreateAPIKey(ctx, db.User{Username: "bot"}, db.APIKeyOptions{Name: "admin", Scopes: []db.Scope{db.ScopeRead, db.ScopeSubmit, db.ScopeComment, db.ScopeAdmin}})
+	assert.Nil(t, err, "create key")
+	assert.Equal(t, 403, do("GET", "/api/v1/auditLog", "ApiKey "+key), "admin key without two-factor")
+
+	// moderating someone else's content doesn't go through RequireRole, but it's held back all the same
+	bearer(t, store, "alice")
+	post, err := store.CreateSubmission(ctx, db.Submission{Username: "alice", Title: "title", Link: "https://example.com"})
+	assert.Nil(t, err, "create submission")
+	comment, err := store.InsertNewComment(ctx, db.Comment{InResponseTo: post, Author: "alice", Content: "hello"})
+	assert.Nil(t, err, "create comment")
+	assert.Equal(t, 403, do("DELETE", "/api/v1/comment?id="+comment, "ApiKey "+key), "deleting a comment without two-factor")
+	assert.Equal(t, 403, do("DELETE", "/api/v1/submission", "ApiKey "+key, `{"id": "`+post+`"}`), "deleting a submission without two-factor")
+	assert.Equal(t, 403, do("POST", "/api/v1/apiKeys", jwt, `{"scopes": ["admin"]}`), "can't create one either")
+	assert.Equal(t, 200, do("POST", "/api/v1/apiKeys", jwt, `{"scopes": ["read"]}`), "other keys are fine")
+
+	store.SetupTOTP(ctx, db.User{Username: "bot"}, totp.NewSecret())
+	store.EnableTOTP(ctx, db.User{Username: "bot"}, totp.Step(time.Now()))
+	assert.Equal(t, 200, do("GET", "/api/v1/auditLog", "ApiKey "+key), "with two-factor on")
+	assert.Equal(t, 200, do("DELETE", "/api/v1/comment?id="+comment, "ApiKey "+key), "deleting a comment with it")
+	assert.Equal(t, 200, do("DELETE", "/api/v1/submission", "ApiKey "+key, `{"id": "`+post+`"}`), "deleting a submission with it")
+	assert.Equal(t, 200, do("POST", "/api/v1/apiKeys", jwt, `{"scopes": ["admin"]}`), "and it can be created")
+}
+
+func TestSessions(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{})
+	laptop := bearer(t, store, "alice")
+	phone := bearer(t, store, "alice")
+	tablet := bearer(t, store, "alice")
+
+	assert.Equal(t, 200, do("GET", "/api/v1/sessions", laptop), "list")
+
+	// logging out kills that token straight away, other devices carry on
+	assert.Equal(t, 200, do("POST", "/api/v1/logout", laptop), "logout")
+	assert.Equal(t, 401, do("GET", "/api/v1/sessions", laptop), "logged out token")
+	assert.Equal(t, 200, do("GET", "/api/v1/sessions", phone), "other device")
+
+	assert.Equal(t, 200, do("POST", "/api/v1/logoutAll", phone), "logout everywhere")
+	assert.Equal(t, 401, do("GET", "/api/v1/sessions", phone), "this device")
+	assert.Equal(t, 401, do("GET", "/api/v1/sessions", tablet), "every other device")
+
+	_, refresh, err := store.CreateSession(context.Background(), db.User{Username: "alice"}, "127.0.0.1", "test", time.Now().Add(time.Hour))
+	assert.Nil(t, err, "start session")
+	assert.Equal(t, 200, do("POST", "/api/v1/refresh", "", `{"refreshToken": "`+refresh+`"}`), "refresh")
+	assert.Equal(t, 401, do("POST", "/api/v1/refresh", "", `{"refreshToken": "`+refresh+`"}`), "refresh token only works once")
+	assert.Equal(t, 400, do("POST", "/api/v1/refresh", "", `{}`), "no token")
+
+	// tokens that aren't tied to a session can't be revoked, so they're refused
+	legacy, err := jwt.Sign(jwt.NewClaims(jwt.AccessToken, "alice", 5*time.Minute))
+	assert.Nil(t, err, "sign token")
+	assert.Equal(t, 401, do("GET", "/api/v1/checkAdmin", "Bearer "+legacy), "token without a session")
+}
+
+func TestLoginLimits(t *testing.T) {
+	_, do := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.LoginIPLimit = handlers.RateLimit{Max: 6, Window: time.Minute}
+		config.Captcha = captcha.Static(true)
+	})
+
+	login := func(email, captchaToken string) int {
+		return do("POST", "/api/v1/login", "", `{"username": "alice", "email": "`+email+`", "captchaToken": "`+captchaToken+`"}`)
+	}
+
+	// no captcha token, so these stop at a 400 without sending anything
+	// the IP limit counts them, but not the address's, or anyone could use it up for her
+	assert.Equal(t, 400, login("alice@example.com", ""), "unchecked")
+	assert.Equal(t, 400, login("alice@example.com", ""), "unchecked again")
+
+	assert.Equal(t, 200, login("alice@example.com", "ok"), "first link")
+	assert.Equal(t, 200, login("Alice@Example.com", "ok"), "second link, same address")
+	assert.Equal(t, 429, login("alice@example.com", "ok"), "address over the limit")
+
+	assert.Equal(t, 200, login("bob@example.com", "ok"), "another address")
+	assert.Equal(t, 429, login("carol@example.com", "ok"), "IP over the limit")
+}
+
+// sends a request through a reverse proxy that says it came from ip, "" for none
+func forwardedFrom(t *testing.T, app *fiber.App, method, path, ip, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ip != "" {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := app.Test(req)
+	assert.Nil(t, err, "request")
+	return resp.StatusCode
+}
+
+// app.Test connections come from 0.0.0.0, so that's the proxy
+func proxiedApp(t *testing.T, trusted ...string) (*db.MemoryStore, *handlers.Handlers, *fiber.App) {
+	var h *handlers.Handlers
+	store, _ := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.LoginEmailLimit = handlers.RateLimit{}
+		config.TrustedProxies = trusted
+		config.ProxyHeader = "X-Forwarded-For"
+		h = config
+	})
+	return store, h, New(h, "")
+}
+
+func TestLoginLimitsBehindProxy(t *testing.T) {
+	login := func(app *fiber.App, ip string) int {
+		return forwardedFrom(t, app, "POST", "/api/v1/login", ip, `{"username": "alice", "email": "alice@example.com"}`)
+	}
+
+	_, _, app := proxiedApp(t, "0.0.0.0")
+	for range 4 {
+		assert.Equal(t, 400, login(app, "203.0.113.1"), "under the limit")
+	}
+	assert.Equal(t, 429, login(app, "203.0.113.1"), "first client over the limit")
+	assert.Equal(t, 400, login(app, "203.0.113.2"), "second client has a budget of its own")
+	assert.Equal(t, 429, login(app, "203.0.113.1, 203.0.113.2"), "the client is the first address")
+
+	// anyone else's X-Forwarded-For is ignored, or a client could pick a fresh address for every request
+	_, _, app = proxiedApp(t, "10.0.0.1")
+	for range 4 {
+		assert.Equal(t, 400, login(app, "203.0.113.1"), "under the limit")
+	}
+	assert.Equal(t, 429, login(app, "203.0.113.2"), "untrusted header, same connection address")
+}
+
+func TestPasskeys(t *testing.T) {
+	store, do := testApp(t, handlers.RateLimit{})
+	assert.Equal(t, 503, do("POST", "/api/v1/passkeys/login/begin", ""), "off without WEBAUTHN_RP_ID")
+	assert.Equal(t, 503, do("GET", "/api/v1/passkeys", bearer(t, store, "alice")), "listing too")
+
+	store, do = testApp(t, handlers.RateLimit{}, func(h *handlers.Handlers) {
+		w, err := webauthn.New(&webauthn.Config{RPID: "localhost", RPDisplayName: "test", RPOrigins: []string{"http://localhost"}})
+		assert.Nil(t, err, "webauthn config")
+		h.WebAuthn = w
+	})
+	alice := bearer(t, store, "alice")
+
+	assert.Equal(t, 200, do("POST", "/api/v1/passkeys/login/begin", ""), "anyone can start signing in")
+	assert.Equal(t, 400, do("POST", "/api/v1/passkeys/login/finish", "", `{"challengeId": "`+uuid.NewString()+`", "credential": {}}`), "unknown challenge")
+	assert.Equal(t, 401, do("POST", "/api/v1/passkeys/register/begin", ""), "adding one needs a session")
+	assert.Equal(t, 200, do("POST", "/api/v1/passkeys/register/begin", alice), "start adding one")
+
+	// a registration challenge belongs to whoever started it
+	bearer(t, store, "bob")
+	challenge, err := store.CreatePasskeyChallenge(context.Background(), "bob", []byte(`{}`), time.Now().Add(time.Minute))
+	assert.Nil(t, err, "bob's challenge")
+	assert.Equal(t, 400, do("POST", "/api/v1/passkeys/register/finish", alice, `{"challengeId": "`+challenge+`", "credential": {}}`), "someone else's challenge")
+
+	challenge, _ = store.CreatePasskeyChallenge(context.Background(), "alice", []byte(`{}`), time.Now().Add(time.Minute))
+	assert.Equal(t, 400, do("POST", "/api/v1/passkeys/register/finish", alice, `{"challengeId": "`+challenge+`", "credential": {"id": "junk"}}`), "junk credential")
+
+	assert.Equal(t, 200, do("GET", "/api/v1/passkeys", alice), "list")
+	assert.Equal(t, 404, do("DELETE", "/api/v1/passkeys?id="+uuid.NewString(), alice), "unknown passkey")
+	assert.Equal(t, 400, do("DELETE", "/api/v1/passkeys", alice), "no id")
+}
+
+// sends a request straight to app and decodes the JSON response, for when more than the status matters
+func send(t *testing.T, app *fiber.App, method, path, auth, body string) (int, map[string]any) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := app.Test(req)
+	assert.Nil(t, err, "request")
+
+	decoded := map[string]any{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
+
+func TestTwoFactor(t *testing.T) {
+	var h *handlers.Handlers
+	store, _ := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.LoginIPLimit = handlers.RateLimit{}
+		h = config
+	})
+	app := New(h, "")
+	ctx := context.Background()
+
+	code := func(secret string) string {
+		c, err := totp.Code(secret, totp.Step(time.Now()))
+		assert.Nil(t, err, "code")
+		return c
+	}
+	magic := func(username string) map[string]any {
+		token, err := store.CreateMagicLink(ctx, db.User{Username: username, Email: username + "@example.com"}, time.Now().Add(time.Minute))
+		assert.Nil(t, err, "magic link for %s", username)
+		status, body := send(t, app, "GET", "/api/v1/magic?token="+token, "", "")
+		assert.Equal(t, 200, status, "magic link for %s", username)
+		return body
+	}
+
+	// alice turned it on a while ago
+	bearer(t, store, "alice")
+	secret := totp.NewSecret()
+	store.SetupTOTP(ctx, db.User{Username: "alice"}, secret)
+	recoveryCodes, _ := store.EnableTOTP(ctx, db.User{Username: "alice"}, totp.Step(time.Now())-10)
+
+	body := magic("alice")
+	assert.Equal(t, true, body["twoFactorRequired"], "magic link isn't enough")
+	assert.Nil(t, body["token"], "no JWT yet")
+	token := body["twoFactorToken"].(string)
+
+	status, body := send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "code": "000000"}`)
+	assert.Equal(t, 401, status, "wrong code")
+	assert.Equal(t, float64(4), body["attemptsLeft"], "tries left")
+	status, body = send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "code": "`+code(secret)+`"}`)
+	assert.Equal(t, 200, status, "right code")
+	assert.NotNil(t, body["token"], "signed in")
+	status, _ = send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "code": "`+code(secret)+`"}`)
+	assert.Equal(t, 401, status, "challenge is done with")
+
+	token = magic("alice")["twoFactorToken"].(string)
+	status, _ = send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "code": "`+code(secret)+`"}`)
+	assert.Equal(t, 401, status, "same code twice")
+	status, _ = send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "recoveryCode": "`+recoveryCodes[0]+`"}`)
+	assert.Equal(t, 200, status, "recovery code")
+
+	// bob is staff without it, so he sets it up on the way in
+	bearer(t, store, "bob")
+	store.AddAdmin("bob", "test")
+	body = magic("bob")
+	assert.Equal(t, true, body["setupRequired"], "staff have to set it up")
+	token = body["twoFactorToken"].(string)
+	status, body = send(t, app, "POST", "/api/v1/login/2fa/setup", "", `{"token": "`+token+`"}`)
+	assert.Equal(t, 200, status, "setup while signing in")
+	bobSecret := body["secret"].(string)
+	status, body = send(t, app, "POST", "/api/v1/login/2fa", "", `{"token": "`+token+`", "code": "`+code(bobSecret)+`"}`)
+	assert.Equal(t, 200, status, "first code turns it on")
+	assert.Equal(t, 10, len(body["recoveryCodes"].([]any)), "recovery codes handed out")
+	status, _ = send(t, app, "POST", "/api/v1/2fa/disable", "Bearer "+body["token"].(string), `{"recoveryCode": "`+body["recoveryCodes"].([]any)[0].(string)+`"}`)
+	assert.Equal(t, 403, status, "staff can't turn it off")
+
+	// carol was made a moderator after signing in
+	bearer(t, store, "carol")
+	_, refreshToken, _ := store.CreateSession(ctx, db.User{Username: "carol"}, "127.0.0.1", "test", time.Now().Add(time.Hour))
+	store.AddStaff("carol", db.RoleModerator, "test")
+	status, _ = send(t, app, "POST", "/api/v1/refresh", "", `{"refreshToken": "`+refreshToken+`"}`)
+	assert.Equal(t, 401, status, "staff without two-factor sign in again")
+
+	// dave turns it on and off from settings
+	dave := bearer(t, store, "dave")
+	status, body = send(t, app, "GET", "/api/v1/2fa", dave, "")
+	assert.Equal(t, 200, status, "status")
+	assert.Equal(t, false, body["enabled"], "off to begin with")
+	status, body = send(t, app, "POST", "/api/v1/2fa/setup", dave, "")
+	assert.Equal(t, 200, status, "setup")
+	daveSecret := body["secret"].(string)
+	status, _ = send(t, app, "POST", "/api/v1/2fa/enable", dave, `{"code": "000000"}`)
+	assert.Equal(t, 400, status, "wrong code")
+	status, body = send(t, app, "POST", "/api/v1/2fa/enable", dave, `{"code": "`+code(daveSecret)+`"}`)
+	assert.Equal(t, 200, status, "enable")
+	daveCodes := body["recoveryCodes"].([]any)
+	status, _ = send(t, app, "POST", "/api/v1/2fa/setup", dave, "")
+	assert.Equal(t, 409, status, "can't set up over one that's on")
+	status, _ = send(t, app, "POST", "/api/v1/2fa/disable", dave, `{"code": "000000"}`)
+	assert.Equal(t, 400, status, "wrong code")
+	status, _ = send(t, app, "POST", "/api/v1/2fa/disable", dave, `{"recoveryCode": "`+daveCodes[0].(string)+`"}`)
+	assert.Equal(t, 200, status, "disable")
+	assert.NotNil(t, magic("dave")["token"], "back to just the magic link")
+}
+
+func TestProofOfWorkCaptcha(t *testing.T) {
+	var h *handlers.Handlers
+	store, _ := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		pow, err := captcha.NewProofOfWork(nil, 4)
+		assert.Nil(t, err, "NewProofOfWork")
+		config.Captcha = pow
+		config.ProofOfWork = pow
+		h = config
+	})
+	app := New(h, "")
+	alice := bearer(t, store, "alice")
+
+	// fetches a challenge as auth and does the work
+	solve := func(auth string) string {
+		status, challenge := send(t, app, "GET", "/api/v1/captcha/challenge", auth, "")
+		assert.Equal(t, 200, status, "challenge")
+		difficulty := int(challenge["difficulty"].(float64))
+
+		for nonce := 0; ; nonce++ {
+			token := challenge["challenge"].(string) + ":" + strconv.Itoa(nonce)
+			sum := sha256.Sum256([]byte(token))
+			if new(big.Int).SetBytes(sum[:]).BitLen() <= 256-difficulty {
+				return token
+			}
+		}
+	}
+	submit := func(token string) int {
+		status, _ := send(t, app, "POST", "/api/v1/submit", alice, `{"link": "https://example.com", "title": "Example", "captchaToken": "`+token+`"}`)
+		return status
+	}
+
+	_, challenge := send(t, app, "GET", "/api/v1/captcha/challenge", alice, "")
+	assert.Equal(t, 4+h.ProofOfWork.NewAccountExtra, int(challenge["difficulty"].(float64)), "alice only just signed up")
+
+	token := solve(alice)
+	assert.Equal(t, 201, submit(token), "solved")
+	assert.Equal(t, 400, submit(token), "replayed")
+	assert.Equal(t, 400, submit(solve("")), "challenge fetched signed out")
+
+	status, _ := send(t, app, "GET", "/api/v1/captcha/challenge", "", "")
+	assert.Equal(t, 200, status, "signed out challenges, for /login")
+	h.ProofOfWork = nil
+	status, _ = send(t, app, "GET", "/api/v1/captcha/challenge", "", "")
+	assert.Equal(t, 404, status, "another captcha provider")
+}
+
+func TestCaptchaTrust(t *testing.T) {
+	var h *handlers.Handlers
+	store, _ := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.CaptchaTrust = map[string]handlers.TrustPolicy{handlers.CaptchaComment: {MinKarma: 1}}
+		h = config
+	})
+	app := New(h, "")
+	ctx := context.Background()
+	alice := bearer(t, store, "alice")
+	bearer(t, store, "bob")
+
+	required := func(auth string) map[string]any {
+		status, body := send(t, app, "GET", "/api/v1/captcha", auth, "")
+		assert.Equal(t, 200, status, "captcha status")
+		return body["required"].(map[string]any)
+	}
+	assert.Equal(t, map[string]any{"login": true, "submit": true, "comment": true}, required(""), "signed out")
+	assert.Equal(t, true, required(alice)["comment"], "alice has no karma yet")
+
+	postId, err := store.CreateSubmission(ctx, db.Submission{Username: "alice", Title: "Example", Link: "https://example.com"})
+	assert.Nil(t, err, "submission")
+	comment := `{"inResponseTo": "` + postId + `", "content": "first"}`
+	status, _ := send(t, app, "POST", "/api/v1/comment", alice, comment)
+	assert.Equal(t, 400, status, "untrusted comment without a captcha")
+
+	store.Vote(ctx, db.User{Username: "bob"}, db.Submission{Id: postId}, true)
+	assert.Equal(t, map[string]any{"login": true, "submit": true, "comment": false}, required(alice), "one upvote is enough for comments")
+
+	status, _ = send(t, app, "POST", "/api/v1/comment", alice, comment)
+	assert.Equal(t, 200, status, "trusted comment without a captcha")
+	status, _ = send(t, app, "POST", "/api/v1/submit", alice, `{"link": "https://example.com/2", "title": "Another"}`)
+	assert.Equal(t, 400, status, "submissions still ask")
+}
+
+func TestLoginEmail(t *testing.T) {
+	var h *handlers.Handlers
+	testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.Captcha = captcha.Static(true)
+		h = config
+	})
+	app := New(h, "")
+	mailer := h.Mailer.(*email.Memory)
+
+	status, _ := send(t, app, "POST", "/api/v1/login", "", `{"email": "erin@example.com", "username": "erin", "captchaToken": "ok"}`)
+	assert.Equal(t, 200, status, "login")
+
+	sent := mailer.Messages()
+	assert.Equal(t, 1, len(sent), "one email")
+	assert.Equal(t, "erin@example.com", sent[0].To, "to erin")
+	_, link, found := strings.Cut(sent[0].Body, "https://news.example.com/magic?token=")
+	assert.True(t, found, "has a link to the frontend")
+
+	token, _, _ := strings.Cut(link, `"`)
+	status, body := send(t, app, "GET", "/api/v1/magic?token="+token, "", "")
+	assert.Equal(t, 200, status, "the emailed link signs erin in")
+	assert.NotNil(t, body["token"], "access token")
+}
+
+func TestLoginOtherEmail(t *testing.T) {
+	var h *handlers.Handlers
+	store, _ := testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		config.Captcha = captcha.Static(true)
+		config.LoginEmailLimit = handlers.RateLimit{}
+		h = config
+	})
+	app := New(h, "")
+	bearer(t, store, "victim")
+
+	status, _ := send(t, app, "POST", "/api/v1/login", "", `{"email": "attacker@example.com", "username": "victim", "captchaToken": "ok"}`)
+	assert.Equal(t, 400, status, "someone else's username")
+	assert.Empty(t, h.Mailer.(*email.Memory).Messages(), "no link sent")
+
+	status, _ = send(t, app, "POST", "/api/v1/login", "", `{"email": "victim@example.com", "username": "victim", "captchaToken": "ok"}`)
+	assert.Equal(t, 200, status, "the account's own address")
+
+	// a link that was somehow sent to another address still doesn't sign anyone in
+	token, err := store.CreateMagicLink(context.Background(), db.User{Username: "victim", Email: "attacker@example.com"}, time.Now().Add(time.Minute))
+	assert.Nil(t, err, "magic link")
+	status, body := send(t, app, "GET", "/api/v1/magic?token="+token, "", "")
+	assert.Equal(t, 401, status, "mismatched link")
+	assert.Nil(t, body["token"], "no access token")
+}
+
+func TestProofOfWorkBehindProxy(t *testing.T) {
+	var h *handlers.Handlers
+	testApp(t, handlers.RateLimit{}, func(config *handlers.Handlers) {
+		pow, err := captcha.NewProofOfWork(nil, 4)
+		assert.Nil(t, err, "NewProofOfWork")
+		config.Captcha = pow
+		config.ProofOfWork = pow
+		config.TrustedProxies = []string{"0.0.0.0"}
+		config.ProxyHeader = "X-Forwarded-For"
+		h = config
+	})
+	app := New(h, "")
+
+	difficulty := func(ip string) int {
+		req := httptest.NewRequest("GET", "/api/v1/captcha/challenge", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := app.Test(req)
+		assert.Nil(t, err, "request")
+
+		var challenge captcha.Challenge
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&challenge), "decode")
+		return challenge.Difficulty
+	}
+
+	for range h.ProofOfWork.HotIPThreshold {
+		assert.Equal(t, 4, difficulty("203.0.113.1"), "under the threshold")
+	}
+	assert.Equal(t, 4+h.ProofOfWork.HotIPExtra, difficulty("203.0.113.1"), "hot client")
+	assert.Equal(t, 4, difficulty("203.0.113.2"), "everyone else behind the same proxy")
+}
+
+func TestClientIPBehindProxy(t *testing.T) {
+	store, h, _ := proxiedApp(t, "0.0.0.0")
+	h.Captcha = captcha.Static(true)
+	app := New(h, "")
+	ctx := context.Background()
+
+	status := forwardedFrom(t, app, "POST", "/api/v1/login", "203.0.113.7", `{"email": "erin@example.com", "username": "erin", "captchaToken": "ok"}`)
+	assert.Equal(t, 200, status, "login")
+	_, link, _ := strings.Cut(h.Mailer.(*email.Memory).Messages()[0].Body, "/magic?token=")
+	token, _, _ := strings.Cut(link, `"`)
+	assert.Equal(t, 200, forwardedFrom(t, app, "GET", "/api/v1/magic?token="+token, "203.0.113.8", ""), "magic link")
+
+	// more requests, so anything still pointing at an earlier request's buffer would show it
+	for range 3 {
+		forwardedFrom(t, app, "GET", "/api/v1/status", "198.51.100.99", "")
+	}
+
+	entries, _, err := store.AuditLog(ctx, db.AuditFilter{Username: "erin"}, db.Page{Limit: 10})
+	assert.Nil(t, err, "audit log")
+	ips := map[db.AuditEvent]string{}
+	for _, entry := range entries {
+		ips[entry.Event] = entry.Ip
+	}
+	assert.Equal(t, "203.0.113.7", ips[db.SentEmail], "audit entry has the client's address, not the proxy's")
+	assert.Equal(t, "203.0.113.8", ips[db.Login], "so does the sign in")
+
+	sessions, err := store.ListSessions(ctx, db.User{Username: "erin"})
+	assert.Nil(t, err, "sessions")
+	assert.Equal(t, 1, len(sessions), "one session")
+	assert.Equal(t, "203.0.113.8", sessions[0].Ip, "session too")
+}
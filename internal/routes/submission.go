@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func submissionRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/submission", h.GetSubmission)
+	r.Get("/all", h.AllSubmissions)
+	r.Get("/userSubmissions", h.UserSubmissions)
+	r.Get("/searchSubmissions", h.SearchSubmissions)
+
+	r.Post("/submit", h.RequireScope(db.ScopeSubmit), h.Submit)
+	r.Delete("/submission", h.RequireScope(db.ScopeSubmit), h.DeleteSubmission)
+	r.Post("/flag", h.RequireScope(db.ScopeVote), h.Flag)
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+// six digit codes are guessable given enough tries, so everything that checks one is limited per IP
+// (and /login/2fa per sign in as well)
+func twoFactorRoutes(r fiber.Router, h *handlers.Handlers) {
+	perIP := ipLimiter(h.LoginIPLimit)
+
+	// the second step of signing in, there's no JWT yet
+	r.Post("/login/2fa", perIP, h.TwoFactorLogin)
+	r.Post("/login/2fa/setup", perIP, h.TwoFactorLoginSetup)
+
+	// settings, for the website rather than API keys
+	r.Get("/2fa", h.RequireJWT, h.TwoFactorStatus)
+	r.Post("/2fa/setup", h.RequireJWT, h.SetupTwoFactor)
+	r.Post("/2fa/enable", h.RequireJWT, perIP, h.EnableTwoFactor)
+	r.Post("/2fa/disable", h.RequireJWT, perIP, h.DisableTwoFactor)
+	r.Post("/2fa/recoveryCodes", h.RequireJWT, perIP, h.ReplaceRecoveryCodes)
+}
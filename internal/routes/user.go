@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func userRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/user", h.GetUser)
+	r.Get("/me", h.RequireAuth, h.Me)
+	r.Post("/bio", h.RequireScope(db.ScopeSubmit), h.UpdateBio)
+
+	// data exports, GET also takes the JWT as ?authToken= since it's opened as a plain link
+	r.Post("/dump", h.RequireScope(db.ScopeRead), h.CreateDump)
+	r.Get("/dump", h.GetDump)
+}
+
+// managing keys needs the website, a leaked key can't be used to make more of them
+func apiKeyRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Post("/generateKey", h.RequireJWT, h.GenerateKey)
+	r.Post("/apiKeys", h.RequireJWT, h.GenerateKey)
+	r.Get("/apiKeys", h.RequireJWT, h.ListAPIKeys)
+	r.Post("/apiKeys/rotate", h.RequireJWT, h.RotateAPIKey)
+	r.Delete("/apiKeys", h.RequireJWT, h.RevokeAPIKey)
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/trentwiles/hackernews/internal/db"
+	"github.com/trentwiles/hackernews/internal/handlers"
+)
+
+func voteRoutes(r fiber.Router, h *handlers.Handlers) {
+	r.Get("/allUserVotes", h.AllUserVotes)
+
+	r.Get("/vote", h.RequireScope(db.ScopeRead), h.GetVote)
+	r.Post("/vote", h.RequireScope(db.ScopeVote), h.Vote)
+	r.Post("/commentVote", h.RequireScope(db.ScopeVote), h.CommentVote)
+}
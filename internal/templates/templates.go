@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// the HTML emails, inside the binary so it doesn't matter which directory the server starts in
+//
+//go:embed *.html
+var FS embed.FS
@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the SHA1 vectors from RFC 6238 appendix B, cut down to six digits
+func TestCode(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := Code(secret, Step(time.Unix(unix, 0)))
+		assert.Nil(t, err, "code at %d", unix)
+		assert.Equal(t, want, code, "code at %d", unix)
+	}
+
+	_, err := Code("not base32!", 1)
+	assert.NotNil(t, err, "bad secret")
+}
+
+func TestValidate(t *testing.T) {
+	secret := NewSecret()
+	assert.Equal(t, 32, len(secret), "160 bits of base32")
+
+	now := time.Now()
+	code, _ := Code(secret, Step(now))
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok, "current code")
+	assert.Equal(t, Step(now), step, "matched step")
+
+	_, ok = Validate(secret, code[:3]+" "+code[3:], now)
+	assert.True(t, ok, "spaces are ignored")
+	_, ok = Validate(strings.ToLower(secret), code, now)
+	assert.True(t, ok, "secret in lowercase")
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok, "one step late")
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok, "too late")
+
+	_, ok = Validate(secret, "12345", now)
+	assert.False(t, ok, "too short")
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("JBSWY3DPEHPK3PXP", "Hacker News", "alice")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Hacker%20News:alice?"), uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Hacker+News")
+}